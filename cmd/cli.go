@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/color"
+	"github.com/EternityX/go-vee/internal/config"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// Flags shared by the client commands
+type clientFlags struct {
+	apiKey    string
+	config    string
+	json      bool
+	lan       bool
+	transport string
+	timeout   time.Duration
+	logLevel  string
+
+	lanInterfaces string
+	lanTargets    string
+	cloudURL      string
+}
+
+func newClientFlagSet(name string, usage string) (*flag.FlagSet, *clientFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts := &clientFlags{}
+
+	fs.StringVar(&opts.apiKey, "api-key", os.Getenv("GOVEE_API_KEY"), "Govee API key")
+	fs.StringVar(&opts.config, "config", os.Getenv("GO_VEE_CONFIG"), "Path to a JSON configuration file with accounts")
+	fs.BoolVar(&opts.json, "json", false, "Print JSON instead of a table")
+	fs.BoolVar(&opts.lan, "lan", true, "Use the LAN")
+	fs.StringVar(&opts.transport, "transport", service.TransportAuto, "Control transport: auto, lan or cloud")
+	fs.DurationVar(&opts.timeout, "timeout", 15*time.Second, "Timeout of the whole command")
+	fs.StringVar(&opts.logLevel, "log-level", envOrDefault("LOG_LEVEL", "warn"), "Log level: debug, info, warn or error")
+	fs.StringVar(&opts.lanInterfaces, "lan-interfaces", os.Getenv("LAN_INTERFACES"), "Comma separated network interfaces to scan, or \"all\"")
+	fs.StringVar(&opts.cloudURL, "cloud-url", envOrDefault("GOVEE_API_URL", service.DefaultCloudURL), "Base URL of the Govee cloud API")
+	fs.StringVar(&opts.lanTargets, "lan-targets", os.Getenv("LAN_TARGETS"), "Comma separated IPs or subnets to probe by unicast")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-vee %s\n\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+
+	return fs, opts
+}
+
+// Builds a service from the API key flag and the accounts in the config file
+func (opts *clientFlags) service() (*service.GoveeService, error) {
+	logger, err := logging.New(os.Stderr, opts.logLevel, "text")
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+
+	cfg, err := config.Load(opts.config)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []service.Account
+	if opts.apiKey != "" {
+		accounts = append(accounts, service.Account{Name: "default", APIKey: opts.apiKey})
+	}
+	for _, account := range cfg.Accounts {
+		accounts = append(accounts, service.Account{Name: account.Name, APIKey: account.APIKey})
+	}
+
+	if !opts.lan && len(accounts) == 0 {
+		return nil, errors.New("a Govee API key is required without LAN access: use -api-key or GOVEE_API_KEY")
+	}
+
+	svc := service.NewGoveeService(accounts, opts.lan)
+	svc.SetCloudURL(opts.cloudURL)
+	svc.ConfigureLAN(lanSettings(cfg, opts.lanInterfaces, opts.lanTargets))
+
+	return svc, nil
+}
+
+func (opts *clientFlags) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), opts.timeout)
+}
+
+func printJSON(w io.Writer, value interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// Scans the LAN and refreshes the cloud device list, returning the merged inventory
+func loadInventory(ctx context.Context, svc *service.GoveeService, lan bool) []service.InventoryDevice {
+	if lan {
+		if _, err := svc.DiscoverLANDevices(ctx); err != nil {
+			slog.Warn("LAN discovery failed", "error", err)
+		}
+	}
+
+	return svc.Inventory(ctx)
+}
+
+// Finds a device by its Govee app name, device ID or LAN IP address
+func resolveDevice(ctx context.Context, svc *service.GoveeService, lan bool, name string) (service.InventoryDevice, error) {
+	inventory := loadInventory(ctx, svc, lan)
+
+	var matches []service.InventoryDevice
+	for _, device := range inventory {
+		if strings.EqualFold(device.Device, name) || device.IP == name {
+			return device, nil
+		}
+
+		if strings.EqualFold(device.Name, name) {
+			matches = append(matches, device)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return service.InventoryDevice{}, fmt.Errorf("no device named %q", name)
+	case 1:
+		return matches[0], nil
+	}
+
+	return service.InventoryDevice{}, fmt.Errorf("%d devices are named %q, use the device ID instead", len(matches), name)
+}
+
+func runDiscover(args []string) error {
+	fs, opts := newClientFlagSet("discover", "discover [flags]")
+	fs.Parse(args)
+
+	svc, err := opts.service()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	devices, err := svc.DiscoverLANDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(os.Stdout, devices)
+	}
+
+	rows := make([][]string, 0, len(devices))
+	for _, d := range devices {
+		data := d.Msg.Data
+		rows = append(rows, []string{data.IP, data.Device, data.SKU, data.WifiVersionSoft})
+	}
+
+	return printTable(os.Stdout, []string{"IP", "DEVICE", "SKU", "WIFI FIRMWARE"}, rows)
+}
+
+func runDevices(args []string) error {
+	fs, opts := newClientFlagSet("devices", "devices [flags]")
+	fs.Parse(args)
+
+	svc, err := opts.service()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	devices := loadInventory(ctx, svc, opts.lan)
+
+	if opts.json {
+		return printJSON(os.Stdout, devices)
+	}
+
+	rows := make([][]string, 0, len(devices))
+	for _, d := range devices {
+		rows = append(rows, []string{d.Name, d.Device, d.SKU, d.IP, yesNo(d.Cloud), d.Account})
+	}
+
+	return printTable(os.Stdout, []string{"NAME", "DEVICE", "SKU", "IP", "CLOUD", "ACCOUNT"}, rows)
+}
+
+// Sends one capability to a named device and prints how it was delivered
+func control(opts *clientFlags, name string, capability service.ControlCapability) error {
+	svc, err := opts.service()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	device, err := resolveDevice(ctx, svc, opts.lan, name)
+	if err != nil {
+		return err
+	}
+
+	result, err := svc.ControlDevice(ctx, device.SKU, device.Device, capability, opts.transport)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(os.Stdout, struct {
+			Device    string  `json:"device"`
+			Transport string  `json:"transport"`
+			LANError  string  `json:"lanError,omitempty"`
+			LatencyMs float64 `json:"latencyMs"`
+		}{
+			Device:    device.Device,
+			Transport: result.Transport,
+			LANError:  result.LANError,
+			LatencyMs: float64(result.Latency.Microseconds()) / 1000,
+		})
+	}
+
+	fmt.Printf("%s: sent via %s in %s\n", device.Name, result.Transport, result.Latency.Round(time.Millisecond))
+	return nil
+}
+
+func runPower(command string, args []string) error {
+	fs, opts := newClientFlagSet(command, command+" [flags] <name>")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a device name")
+	}
+
+	value := 0
+	if command == "on" {
+		value = 1
+	}
+
+	return control(opts, fs.Arg(0), service.ControlCapability{
+		Type:     "devices.capabilities.on_off",
+		Instance: "powerSwitch",
+		Value:    float64(value),
+	})
+}
+
+func runBrightness(args []string) error {
+	fs, opts := newClientFlagSet("brightness", "brightness [flags] <name> <1-100>")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a device name and a brightness")
+	}
+
+	brightness, err := strconv.Atoi(fs.Arg(1))
+	if err != nil || brightness < 1 || brightness > 100 {
+		return fmt.Errorf("invalid brightness %q: expected a number from 1 to 100", fs.Arg(1))
+	}
+
+	return control(opts, fs.Arg(0), service.ControlCapability{
+		Type:     "devices.capabilities.range",
+		Instance: "brightness",
+		Value:    float64(brightness),
+	})
+}
+
+func runColor(args []string) error {
+	fs, opts := newClientFlagSet("color", "color [flags] <name> <color>")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a device name and a color")
+	}
+
+	rgb, err := color.Parse(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	return control(opts, fs.Arg(0), service.ControlCapability{
+		Type:     "devices.capabilities.color_setting",
+		Instance: "colorRgb",
+		Value:    float64(rgb.Packed()),
+	})
+}
+
+func runStatus(args []string) error {
+	fs, opts := newClientFlagSet("status", "status [flags] <name>")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a device name")
+	}
+
+	svc, err := opts.service()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	device, err := resolveDevice(ctx, svc, opts.lan, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	state, _, err := svc.QueryState(ctx, device.SKU, device.Device)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(os.Stdout, state)
+	}
+
+	rgb := color.RGB{R: state.Color.R, G: state.Color.G, B: state.Color.B}
+	colorText := rgb.Hex()
+	if state.ColorTemperatureK > 0 {
+		colorText = strconv.Itoa(state.ColorTemperatureK) + "K"
+	}
+
+	power := "off"
+	if state.On {
+		power = "on"
+	}
+
+	return printTable(os.Stdout, []string{"NAME", "POWER", "BRIGHTNESS", "COLOR"}, [][]string{
+		{device.Name, power, strconv.Itoa(state.Brightness) + "%", colorText},
+	})
+}
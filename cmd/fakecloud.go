@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/fakecloud"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// Runs the fake Govee cloud API until SIGINT or SIGTERM
+func runFakeCloud(args []string) error {
+	fs := flag.NewFlagSet("fake-cloud", flag.ExitOnError)
+
+	var addrFlag string
+	var apiKeysFlag string
+	var latencyFlag time.Duration
+	var jitterFlag time.Duration
+	var errorRateFlag float64
+	var rateLimitFlag int
+	var rateWindowFlag time.Duration
+	var logLevelFlag string
+
+	fs.StringVar(&addrFlag, "addr", "127.0.0.1:8081", "Address to listen on")
+	fs.StringVar(&apiKeysFlag, "api-keys", "", "Comma separated API keys to accept (default: any key)")
+	fs.DurationVar(&latencyFlag, "latency", 0, "Delay before every response")
+	fs.DurationVar(&jitterFlag, "jitter", 0, "Random extra delay of up to this duration")
+	fs.Float64Var(&errorRateFlag, "error-rate", 0, "Probability (0-1) that a request fails with 500 or 503")
+	fs.IntVar(&rateLimitFlag, "rate-limit", 10000, "Requests allowed per API key in each rate window, 0 to disable")
+	fs.DurationVar(&rateWindowFlag, "rate-window", 24*time.Hour, "Length of the rate limit window")
+	fs.StringVar(&logLevelFlag, "log-level", envOrDefault("LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-vee fake-cloud [flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	logger, err := logging.New(os.Stderr, logLevelFlag, "text")
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	var apiKeys []string
+	if apiKeysFlag != "" {
+		apiKeys = strings.Split(apiKeysFlag, ",")
+	}
+
+	server := &http.Server{
+		Addr: addrFlag,
+		Handler: fakecloud.New(fakecloud.Options{
+			APIKeys:    apiKeys,
+			Latency:    latencyFlag,
+			Jitter:     jitterFlag,
+			ErrorRate:  errorRateFlag,
+			RateLimit:  rateLimitFlag,
+			RateWindow: rateWindowFlag,
+			Logger:     logger,
+		}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	logger.Info("Fake Govee cloud API listening", "addr", addrFlag, "url", "http://"+addrFlag)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -1,86 +1,118 @@
 package main
 
 import (
-	"flag"
-	"log"
-	"net/http"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 
-	"github.com/EternityX/go-vee/internal/handlers"
+	"github.com/EternityX/go-vee/internal/config"
 	"github.com/EternityX/go-vee/internal/service"
+	"github.com/EternityX/go-vee/internal/service/lan"
 )
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
-		next.ServeHTTP(w, r)
-	})
-}
-
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Govee-API-Key")
+const usage = `Usage: go-vee <command> [flags] [arguments]
+
+Commands:
+  serve                      Run the REST API server (default)
+  discover                   Scan the LAN for devices
+  devices                    List devices from the cloud and the LAN
+  on <name>                  Switch a device on
+  off <name>                 Switch a device off
+  brightness <name> <1-100>  Set the brightness
+  color <name> <color>       Set the color: #ff8800, orange, hsl(32,100%,50%) or 2700K
+  status <name>              Show power, brightness and color
+  simulate                   Run simulated LAN devices for development
+  fake-cloud                 Run a fake Govee cloud API for development
+
+A device can be named by its name in the Govee app, its device ID or its LAN IP address.
+Run "go-vee <command> -h" for the flags of a command.
+`
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	return fallback
+}
 
-		next.ServeHTTP(w, r)
-	})
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
-func main() {
-	var apiKeyFlag string
-	var portFlag string
-	var lanFlag bool
-
-	flag.StringVar(&apiKeyFlag, "api-key", "", "Govee API key")
-	flag.StringVar(&portFlag, "port", "", "Port to listen on")
-	flag.BoolVar(&lanFlag, "lan", true, "Enable LAN discovery (default: true)")
-	flag.Parse()
-
-	apiKey := apiKeyFlag
-	if apiKey == "" {
-		apiKey = os.Getenv("GOVEE_API_KEY")
+// Combines the lan section of the config file with the -lan-interfaces and -lan-targets flags,
+// which replace the configured values when set. "all" selects every multicast interface.
+func lanSettings(cfg *config.Config, interfaces string, targets string) (lan.DiscoverOptions, []service.StaticDevice) {
+	opts := lan.DiscoverOptions{
+		Interfaces:    cfg.LAN.Interfaces,
+		AllInterfaces: cfg.LAN.AllInterfaces,
+		Targets:       cfg.LAN.Targets,
 	}
 
-	// Only require API key if LAN mode is disabled
-	if !lanFlag && apiKey == "" {
-		log.Fatal("Govee API key is required when LAN mode is disabled. Provide it via -api-key flag or GOVEE_API_KEY environment variable")
+	if interfaces == "all" {
+		opts.Interfaces = nil
+		opts.AllInterfaces = true
+	} else if interfaces != "" {
+		opts.Interfaces = strings.Split(interfaces, ",")
+		opts.AllInterfaces = false
 	}
 
-	goveeService := service.NewGoveeService(apiKey, lanFlag)
-	goveeHandler := handlers.NewGoveeHandler(goveeService)
+	if targets != "" {
+		opts.Targets = strings.Split(targets, ",")
+	}
 
-	mux := http.NewServeMux()
+	static := make([]service.StaticDevice, 0, len(cfg.LAN.Devices))
+	for _, device := range cfg.LAN.Devices {
+		static = append(static, service.StaticDevice{IP: device.IP, Device: device.Device, SKU: device.SKU})
+	}
 
-	// Handle devices endpoint
-	mux.HandleFunc("/api/v1/devices", goveeHandler.HandleDevices)
-	mux.HandleFunc("/api/v1/devices/control", goveeHandler.HandleControl)
-	mux.HandleFunc("/api/v1/devices/lan", goveeHandler.HandleLANDevices)
+	return opts, static
+}
 
-	// Apply middleware
-	handler := corsMiddleware(loggingMiddleware(mux))
+func main() {
+	args := os.Args[1:]
 
-	port := portFlag
-	if port == "" {
-		port = os.Getenv("PORT")
-		if port == "" {
-			log.Fatal("Port is required. Provide it via -port flag or PORT environment variable")
-		}
+	// Without a command, or with only flags, behave like earlier versions and start the server
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		serve(args)
+		return
 	}
 
-	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: handler,
+	command, args := args[0], args[1:]
+
+	var err error
+	switch command {
+	case "serve":
+		serve(args)
+		return
+	case "discover":
+		err = runDiscover(args)
+	case "devices":
+		err = runDevices(args)
+	case "on", "off":
+		err = runPower(command, args)
+	case "brightness":
+		err = runBrightness(args)
+	case "color":
+		err = runColor(args)
+	case "status":
+		err = runStatus(args)
+	case "simulate":
+		err = runSimulate(args)
+	case "fake-cloud":
+		err = runFakeCloud(args)
+	case "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Printf("LAN Discovery: %t", lanFlag)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
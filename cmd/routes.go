@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/EternityX/go-vee/internal/handlers"
+)
+
+// A route of the go-vee API. Handlers check the request method themselves.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// Handlers of the go-vee API. The history and Hue routes are only served when their handler is set.
+type apiHandlers struct {
+	govee    *handlers.GoveeHandler
+	scenes   *handlers.SceneHandler
+	jobs     *handlers.JobHandler
+	webhooks *handlers.WebhookHandler
+	history  *handlers.HistoryHandler
+	hue      *handlers.HueHandler
+}
+
+// Returns the routes of the go-vee API. Every route is documented in internal/apidocs/openapi.json.
+func apiRoutes(h apiHandlers) []route {
+	routes := []route{
+		// Devices
+		{"/api/v1/devices", h.govee.HandleDevices},
+		{"/api/v1/devices/control", h.govee.HandleControl},
+		{"/api/v1/devices/lan", h.govee.HandleLANDevices},
+		{"/api/v1/devices/state", h.govee.HandleDeviceState},
+		{"/api/v1/inventory", h.govee.HandleInventory},
+		{"/api/v1/events", h.govee.HandleEvents},
+
+		// Sensor readings
+		{"/api/v1/readings", h.govee.HandleReadings},
+		{"/api/v1/readings/{device}", h.govee.HandleReadingHistory},
+		{"/api/v1/readings/{device}/refresh", h.govee.HandleRefreshReadings},
+		{"/api/v1/alerts", h.govee.HandleAlerts},
+
+		// Snapshots
+		{"/api/v1/snapshots", h.govee.HandleSnapshots},
+		{"/api/v1/snapshots/{id}", h.govee.HandleSnapshot},
+		{"/api/v1/snapshots/{id}/restore", h.govee.HandleRestoreSnapshot},
+
+		// Scenes
+		{"/api/v1/scenes", h.scenes.HandleScenes},
+		{"/api/v1/scenes/{name}", h.scenes.HandleScene},
+		{"/api/v1/scenes/{name}/activate", h.scenes.HandleActivate},
+
+		// Asynchronous jobs
+		{"/api/v1/jobs", h.jobs.HandleJobs},
+		{"/api/v1/jobs/{id}", h.jobs.HandleJob},
+		{"/api/v1/jobs/{id}/cancel", h.jobs.HandleCancelJob},
+
+		// Webhooks
+		{"/api/v1/webhooks", h.webhooks.HandleWebhooks},
+		{"/api/v1/webhooks/{id}", h.webhooks.HandleWebhook},
+		{"/api/v1/webhooks/{id}/deliveries", h.webhooks.HandleDeliveries},
+
+		// API documentation
+		{"/api/v1/openapi.json", handlers.HandleOpenAPI},
+		{"/api/v1/docs", handlers.HandleDocs},
+
+		// Probes
+		{"/healthz", h.govee.HandleHealthz},
+		{"/readyz", h.govee.HandleReadyz},
+	}
+
+	if h.history != nil {
+		routes = append(routes, route{"/api/v1/history", h.history.HandleHistory})
+	}
+
+	if h.hue != nil {
+		routes = append(routes, route{"/api/v1/hue/link", h.hue.HandleLink})
+	}
+
+	return routes
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/apidocs"
+	"github.com/EternityX/go-vee/internal/handlers"
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/hue"
+	"github.com/EternityX/go-vee/internal/jobs"
+	"github.com/EternityX/go-vee/internal/scenes"
+	"github.com/EternityX/go-vee/internal/service"
+	"github.com/EternityX/go-vee/internal/webhooks"
+)
+
+var testMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema map[string]interface{} `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPI struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse        `json:"responses"`
+		Schemas   map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) openAPI {
+	t.Helper()
+
+	var spec openAPI
+	if err := json.Unmarshal(apidocs.OpenAPI, &spec); err != nil {
+		t.Fatalf("parsing the OpenAPI document: %v", err)
+	}
+	return spec
+}
+
+// Returns the documented operations of a path keyed by upper case method
+func (spec openAPI) operations(t *testing.T, path string) map[string]openAPIOperation {
+	t.Helper()
+
+	operations := make(map[string]openAPIOperation)
+	for key, raw := range spec.Paths[path] {
+		if key == "parameters" {
+			continue
+		}
+
+		var op openAPIOperation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			t.Fatalf("%s %s: %v", key, path, err)
+		}
+		operations[strings.ToUpper(key)] = op
+	}
+	return operations
+}
+
+// Returns the JSON schema of a documented response, following a reference to a shared response
+func (spec openAPI) responseSchema(response openAPIResponse) map[string]interface{} {
+	if name, ok := strings.CutPrefix(response.Ref, "#/components/responses/"); ok {
+		response = spec.Components.Responses[name]
+	}
+	return response.Content["application/json"].Schema
+}
+
+// Checks a decoded JSON value against the subset of JSON schema used by the document
+func (spec openAPI) check(schema map[string]interface{}, value interface{}, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := spec.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+		return spec.check(resolved, value, at)
+	}
+
+	if options, ok := schema["oneOf"].([]interface{}); ok {
+		for _, option := range options {
+			if spec.check(option.(map[string]interface{}), value, at) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v matches none of the oneOf schemas", at, value)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %T, want an object", at, value)
+		}
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required property %s", at, name)
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range object {
+			if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+				if err := spec.check(propertySchema, property, at+"."+name); err != nil {
+					return err
+				}
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				if err := spec.check(additional, property, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %T, want an array", at, value)
+		}
+
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range array {
+				if err := spec.check(items, item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: got %T, want a string", at, value)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: got %v, want an integer", at, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: got %T, want a number", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: got %T, want a boolean", at, value)
+		}
+	}
+
+	return nil
+}
+
+// Serves the routes of the API with every optional feature enabled and nothing configured
+func testMux(t *testing.T) (*http.ServeMux, []route) {
+	t.Helper()
+
+	svc := service.NewGoveeService(nil, false)
+	jobManager := jobs.NewManager(1, 10, 10)
+
+	sceneStore, err := scenes.NewStore(filepath.Join(t.TempDir(), "scenes.json"))
+	if err != nil {
+		t.Fatalf("scenes.NewStore: %v", err)
+	}
+
+	historyStore, err := history.Open(t.TempDir(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("history.Open: %v", err)
+	}
+	t.Cleanup(func() { historyStore.Close() })
+
+	hueBridge, err := hue.New(svc, hue.Options{AdvertiseIP: "127.0.0.1", Port: 80})
+	if err != nil {
+		t.Fatalf("hue.New: %v", err)
+	}
+
+	routes := apiRoutes(apiHandlers{
+		govee:    handlers.NewGoveeHandler(svc, jobManager),
+		scenes:   handlers.NewSceneHandler(sceneStore, svc, jobManager),
+		jobs:     handlers.NewJobHandler(jobManager),
+		webhooks: handlers.NewWebhookHandler(webhooks.NewManager(1, time.Second)),
+		history:  handlers.NewHistoryHandler(historyStore),
+		hue:      handlers.NewHueHandler(hueBridge),
+	})
+
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+
+	return mux, routes
+}
+
+var pathParameter = regexp.MustCompile(`\{[^}]+\}`)
+
+// Sends a request with an empty JSON body. Streaming endpoints are stopped after a moment.
+func serveTest(mux *http.ServeMux, method string, path string) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var body *strings.Reader
+	if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
+		body = strings.NewReader("{}")
+	} else {
+		body = strings.NewReader("")
+	}
+
+	req := httptest.NewRequestWithContext(ctx, method, pathParameter.ReplaceAllString(path, "test"), body)
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	spec := loadSpec(t)
+	mux, routes := testMux(t)
+
+	registered := make([]string, 0, len(routes))
+	for _, route := range routes {
+		registered = append(registered, route.pattern)
+	}
+	sort.Strings(registered)
+
+	documented := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		documented = append(documented, path)
+	}
+	sort.Strings(documented)
+
+	for _, path := range documented {
+		if !slices.Contains(registered, path) {
+			t.Errorf("%s is documented but not registered", path)
+		}
+	}
+
+	for _, path := range registered {
+		if !slices.Contains(documented, path) {
+			t.Errorf("%s is registered but not documented", path)
+		}
+	}
+
+	for _, path := range registered {
+		operations := spec.operations(t, path)
+
+		for _, method := range testMethods {
+			_, documented := operations[method]
+			status := serveTest(mux, method, path).Code
+
+			if documented && status == http.StatusMethodNotAllowed {
+				t.Errorf("%s %s is documented but answers 405", method, path)
+			}
+			if !documented && status != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s is not documented but answers %d", method, path, status)
+			}
+		}
+	}
+}
+
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	spec := loadSpec(t)
+	mux, _ := testMux(t)
+
+	// Requests whose status is known in a service without accounts or devices
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/healthz", http.StatusOK},
+		{http.MethodGet, "/api/v1/scenes", http.StatusOK},
+		{http.MethodGet, "/api/v1/jobs", http.StatusOK},
+		{http.MethodGet, "/api/v1/webhooks", http.StatusOK},
+		{http.MethodGet, "/api/v1/snapshots", http.StatusOK},
+		{http.MethodGet, "/api/v1/history", http.StatusOK},
+		{http.MethodGet, "/api/v1/alerts", http.StatusOK},
+		{http.MethodGet, "/api/v1/scenes/{name}", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/jobs/{id}", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/webhooks/{id}", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/snapshots/{id}", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/devices/control", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/scenes", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/webhooks", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		response := serveTest(mux, tt.method, tt.path)
+		if response.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, response.Code, tt.status, response.Body)
+			continue
+		}
+
+		documented, ok := spec.operations(t, tt.path)[tt.method].Responses[strconv.Itoa(tt.status)]
+		if !ok {
+			t.Errorf("%s %s: status %d is not documented", tt.method, tt.path, tt.status)
+			continue
+		}
+
+		var body interface{}
+		if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s: invalid JSON: %v", tt.method, tt.path, err)
+			continue
+		}
+
+		if err := spec.check(spec.responseSchema(documented), body, "body"); err != nil {
+			t.Errorf("%s %s: %v", tt.method, tt.path, err)
+		}
+	}
+
+	// Every handler answers a method it does not support with the documented error body
+	var body interface{}
+	if err := json.Unmarshal(serveTest(mux, http.MethodPatch, "/api/v1/devices").Body.Bytes(), &body); err != nil {
+		t.Fatalf("405 response: %v", err)
+	}
+	if err := spec.check(map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"}, body, "body"); err != nil {
+		t.Errorf("405 response: %v", err)
+	}
+}
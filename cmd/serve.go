@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/certs"
+	"github.com/EternityX/go-vee/internal/config"
+	"github.com/EternityX/go-vee/internal/dashboard"
+	"github.com/EternityX/go-vee/internal/handlers"
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/homeassistant"
+	"github.com/EternityX/go-vee/internal/hue"
+	"github.com/EternityX/go-vee/internal/jobs"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/scenes"
+	"github.com/EternityX/go-vee/internal/service"
+	"github.com/EternityX/go-vee/internal/webhooks"
+)
+
+// Runs the REST API server until SIGINT or SIGTERM
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+
+	var apiKeyFlag string
+	var portFlag string
+	var lanFlag bool
+	var logLevelFlag string
+	var logFormatFlag string
+	var configFlag string
+	var corsOriginsFlag string
+	var bindFlag string
+	var tlsCertFlag string
+	var tlsKeyFlag string
+	var unixSocketFlag string
+	var discoveryIntervalFlag time.Duration
+	var shutdownTimeoutFlag time.Duration
+	var statePollIntervalFlag time.Duration
+	var webhookAttemptsFlag int
+	var cloudEventsFlag bool
+	var cloudEventsBrokerFlag string
+	var mqttBrokerFlag string
+	var mqttUsernameFlag string
+	var mqttPasswordFlag string
+	var mqttDiscoveryPrefixFlag string
+	var mqttTopicPrefixFlag string
+	var hueFlag bool
+	var huePortFlag int
+	var hueAdvertiseIPFlag string
+	var hueOpenPairingFlag bool
+	var hueStateFileFlag string
+	var scenesFileFlag string
+	var lanInterfacesFlag string
+	var lanTargetsFlag string
+	var cloudURLFlag string
+	var cloudTimeoutFlag time.Duration
+	var cloudRetriesFlag int
+	var cloudBreakerThresholdFlag int
+	var cloudBreakerCooldownFlag time.Duration
+	var jobWorkersFlag int
+	var lanCommandGapFlag time.Duration
+	var jobHistoryFlag int
+	var dashboardFlag bool
+	var readingsIntervalFlag time.Duration
+	var readingsHistoryFlag int
+	var historyDirFlag string
+	var historyRetentionFlag time.Duration
+
+	fs.StringVar(&apiKeyFlag, "api-key", "", "Govee API key")
+	fs.StringVar(&portFlag, "port", "", "Port to listen on")
+	fs.BoolVar(&lanFlag, "lan", true, "Enable LAN discovery (default: true)")
+	fs.StringVar(&logLevelFlag, "log-level", envOrDefault("LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	fs.StringVar(&logFormatFlag, "log-format", envOrDefault("LOG_FORMAT", "text"), "Log format: text or json")
+	fs.StringVar(&configFlag, "config", os.Getenv("GO_VEE_CONFIG"), "Path to a JSON configuration file")
+	fs.StringVar(&corsOriginsFlag, "cors-origins", os.Getenv("CORS_ORIGINS"), "Comma separated list of allowed CORS origins, or * for all (default: none)")
+	fs.StringVar(&bindFlag, "bind", os.Getenv("BIND_ADDRESS"), "Interface address to listen on (default: all interfaces)")
+	fs.StringVar(&tlsCertFlag, "tls-cert", os.Getenv("TLS_CERT_FILE"), "TLS certificate file; enables HTTPS together with -tls-key")
+	fs.StringVar(&tlsKeyFlag, "tls-key", os.Getenv("TLS_KEY_FILE"), "TLS private key file")
+	fs.StringVar(&unixSocketFlag, "unix-socket", os.Getenv("UNIX_SOCKET"), "Path of a Unix domain socket to listen on")
+	fs.DurationVar(&discoveryIntervalFlag, "discovery-interval", time.Minute, "Interval between background LAN discovery scans, 0 to disable")
+	fs.DurationVar(&shutdownTimeoutFlag, "shutdown-timeout", 15*time.Second, "How long to wait for in-flight requests on shutdown")
+	fs.DurationVar(&statePollIntervalFlag, "state-poll-interval", 30*time.Second, "Interval between LAN device state polls, 0 to disable")
+	fs.IntVar(&webhookAttemptsFlag, "webhook-attempts", 5, "Maximum delivery attempts per webhook event")
+	fs.BoolVar(&cloudEventsFlag, "cloud-events", false, "Subscribe to device events pushed by the Govee cloud")
+	fs.StringVar(&cloudEventsBrokerFlag, "cloud-events-broker", envOrDefault("GOVEE_EVENT_BROKER", service.DefaultEventBroker), "MQTT broker URL for Govee cloud events")
+	fs.StringVar(&mqttBrokerFlag, "mqtt-broker", os.Getenv("MQTT_BROKER"), "MQTT broker URL for the Home Assistant bridge, e.g. mqtt://localhost:1883 (default: disabled)")
+	fs.StringVar(&mqttUsernameFlag, "mqtt-username", os.Getenv("MQTT_USERNAME"), "MQTT user name for the Home Assistant bridge")
+	fs.StringVar(&mqttPasswordFlag, "mqtt-password", os.Getenv("MQTT_PASSWORD"), "MQTT password for the Home Assistant bridge")
+	fs.StringVar(&mqttDiscoveryPrefixFlag, "mqtt-discovery-prefix", "homeassistant", "Home Assistant MQTT discovery prefix")
+	fs.StringVar(&mqttTopicPrefixFlag, "mqtt-topic-prefix", "go-vee", "Prefix of the state and command topics published by the bridge")
+	fs.BoolVar(&hueFlag, "hue", false, "Emulate a Philips Hue bridge")
+	fs.IntVar(&huePortFlag, "hue-port", 80, "Port of the emulated Hue bridge API")
+	fs.StringVar(&hueAdvertiseIPFlag, "hue-advertise-ip", os.Getenv("HUE_ADVERTISE_IP"), "IP address announced by the emulated Hue bridge (default: detected)")
+	fs.BoolVar(&hueOpenPairingFlag, "hue-open-pairing", false, "Let Hue apps pair without opening the pairing window first")
+	fs.StringVar(&hueStateFileFlag, "hue-state-file", envOrDefault("GO_VEE_HUE_STATE_FILE", "hue.json"), "JSON file that stores Hue users and light IDs")
+	fs.StringVar(&scenesFileFlag, "scenes-file", envOrDefault("GO_VEE_SCENES_FILE", "scenes.json"), "JSON file that stores scenes")
+	fs.StringVar(&lanInterfacesFlag, "lan-interfaces", os.Getenv("LAN_INTERFACES"), "Comma separated network interfaces to scan for LAN devices, or \"all\" (default: the default route)")
+	fs.StringVar(&lanTargetsFlag, "lan-targets", os.Getenv("LAN_TARGETS"), "Comma separated IPs or subnets to probe for LAN devices by unicast")
+	fs.StringVar(&cloudURLFlag, "cloud-url", envOrDefault("GOVEE_API_URL", service.DefaultCloudURL), "Base URL of the Govee cloud API")
+	fs.DurationVar(&cloudTimeoutFlag, "cloud-timeout", service.DefaultCloudPolicy().Timeout, "Timeout of a single Govee cloud API call")
+	fs.IntVar(&cloudRetriesFlag, "cloud-retries", service.DefaultCloudPolicy().Retries, "Retries of a Govee cloud API call that failed upstream")
+	fs.IntVar(&cloudBreakerThresholdFlag, "cloud-breaker-threshold", service.DefaultCloudPolicy().BreakerThreshold, "Consecutive Govee cloud API failures that open the circuit breaker, 0 to disable")
+	fs.DurationVar(&cloudBreakerCooldownFlag, "cloud-breaker-cooldown", service.DefaultCloudPolicy().BreakerCooldown, "How long the circuit breaker stays open before a probe call")
+	fs.DurationVar(&lanCommandGapFlag, "lan-command-gap", service.DefaultLANCommandGap, "Minimum time between two LAN commands to the same device")
+	fs.IntVar(&jobWorkersFlag, "job-workers", 4, "Number of asynchronous control jobs run at the same time")
+	fs.IntVar(&jobHistoryFlag, "job-history", 200, "Number of finished asynchronous jobs kept for status polling")
+	fs.BoolVar(&dashboardFlag, "dashboard", true, "Serve the web dashboard at /")
+	fs.DurationVar(&readingsIntervalFlag, "readings-interval", 5*time.Minute, "Interval between cloud polls of sensor readings, 0 to disable")
+	fs.IntVar(&readingsHistoryFlag, "readings-history", service.DefaultReadingHistory, "Number of sensor readings kept per device")
+	fs.StringVar(&historyDirFlag, "history-dir", os.Getenv("GO_VEE_HISTORY_DIR"), "Directory of the control and state history (default: disabled)")
+	fs.DurationVar(&historyRetentionFlag, "history-retention", 30*24*time.Hour, "How long history entries are kept, in whole days (a multiple of 24h), 0 to keep them forever")
+	fs.Parse(args)
+
+	logger, err := logging.New(os.Stderr, logLevelFlag, logFormatFlag)
+	if err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	cfg, err := config.Load(configFlag)
+	if err != nil {
+		fatal("Failed to load configuration", "error", err)
+	}
+
+	if corsOriginsFlag != "" {
+		cfg.CORS.AllowedOrigins = strings.Split(corsOriginsFlag, ",")
+	}
+
+	apiKey := apiKeyFlag
+	if apiKey == "" {
+		apiKey = os.Getenv("GOVEE_API_KEY")
+	}
+
+	var accounts []service.Account
+	if apiKey != "" {
+		accounts = append(accounts, service.Account{Name: "default", APIKey: apiKey})
+	}
+	for _, account := range cfg.Accounts {
+		accounts = append(accounts, service.Account{Name: account.Name, APIKey: account.APIKey})
+	}
+
+	// Only require API key if LAN mode is disabled
+	if !lanFlag && len(accounts) == 0 {
+		fatal("Govee API key is required when LAN mode is disabled. Provide it via -api-key flag, GOVEE_API_KEY environment variable or accounts in the config file")
+	}
+
+	goveeService := service.NewGoveeService(accounts, lanFlag)
+	goveeService.SetCloudURL(cloudURLFlag)
+
+	cloudPolicy := service.DefaultCloudPolicy()
+	cloudPolicy.Timeout = cloudTimeoutFlag
+	cloudPolicy.Retries = cloudRetriesFlag
+	cloudPolicy.BreakerThreshold = cloudBreakerThresholdFlag
+	cloudPolicy.BreakerCooldown = cloudBreakerCooldownFlag
+	goveeService.SetCloudPolicy(cloudPolicy)
+
+	goveeService.ConfigureLAN(lanSettings(cfg, lanInterfacesFlag, lanTargetsFlag))
+	goveeService.SetLANCommandGap(lanCommandGapFlag)
+
+	if readingsHistoryFlag < 1 {
+		fatal("-readings-history must be at least 1")
+	}
+	goveeService.SetReadingHistory(readingsHistoryFlag)
+
+	alertRules := make([]service.AlertRule, len(cfg.Alerts))
+	for i, alert := range cfg.Alerts {
+		alertRules[i] = service.AlertRule{
+			Name:   alert.Name,
+			Device: alert.Device,
+			Metric: alert.Metric,
+			Above:  alert.Above,
+			Below:  alert.Below,
+		}
+	}
+	if err := goveeService.SetAlertRules(alertRules); err != nil {
+		fatal("Invalid alert configuration", "error", err)
+	}
+
+	var historyStore *history.Store
+	if historyDirFlag != "" {
+		historyStore, err = history.Open(historyDirFlag, historyRetentionFlag)
+		if err != nil {
+			fatal("Failed to open history", "error", err)
+		}
+		goveeService.SetHistory(historyStore)
+	}
+
+	if jobWorkersFlag < 1 {
+		fatal("-job-workers must be at least 1")
+	}
+	jobManager := jobs.NewManager(jobWorkersFlag, 100, jobHistoryFlag)
+	goveeHandler := handlers.NewGoveeHandler(goveeService, jobManager)
+	jobHandler := handlers.NewJobHandler(jobManager)
+
+	webhookManager := webhooks.NewManager(webhookAttemptsFlag, time.Second)
+	for _, webhook := range cfg.Webhooks {
+		_, err := webhookManager.Add(webhooks.Target{
+			ID:           webhook.ID,
+			URL:          webhook.URL,
+			Secret:       webhook.Secret,
+			Devices:      webhook.Devices,
+			EventTypes:   webhook.EventTypes,
+			Capabilities: webhook.Capabilities,
+			Source:       webhooks.SourceConfig,
+		})
+		if err != nil {
+			fatal("Invalid webhook configuration", "error", err)
+		}
+	}
+	webhookHandler := handlers.NewWebhookHandler(webhookManager)
+
+	sceneStore, err := scenes.NewStore(scenesFileFlag)
+	if err != nil {
+		fatal("Failed to load scenes", "error", err)
+	}
+	sceneHandler := handlers.NewSceneHandler(sceneStore, goveeService, jobManager)
+
+	var hueBridge *hue.Bridge
+	if hueFlag {
+		advertiseIP := hueAdvertiseIPFlag
+		if advertiseIP == "" {
+			advertiseIP, err = hue.DetectIP()
+			if err != nil {
+				fatal("Failed to start Hue bridge emulation", "error", err)
+			}
+		}
+
+		hueBridge, err = hue.New(goveeService, hue.Options{
+			AdvertiseIP: advertiseIP,
+			Port:        huePortFlag,
+			OpenPairing: hueOpenPairingFlag,
+			StateFile:   hueStateFileFlag,
+		})
+		if err != nil {
+			fatal("Failed to load Hue bridge state", "error", err)
+		}
+	}
+
+	// Cancelled on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background work outlives ctx until the servers have drained, since in-flight requests
+	// still submit jobs, trigger webhooks and record history
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	var background sync.WaitGroup
+	runBackground := func(fn func(ctx context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			fn(backgroundCtx)
+		}()
+	}
+
+	api := apiHandlers{
+		govee:    goveeHandler,
+		scenes:   sceneHandler,
+		jobs:     jobHandler,
+		webhooks: webhookHandler,
+	}
+	if historyStore != nil {
+		api.history = handlers.NewHistoryHandler(historyStore)
+	}
+	if hueBridge != nil {
+		api.hue = handlers.NewHueHandler(hueBridge)
+	}
+
+	mux := http.NewServeMux()
+	for _, route := range apiRoutes(api) {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+
+	if dashboardFlag {
+		dashboardHandler := dashboard.Handler()
+		mux.Handle("/{$}", dashboardHandler)
+		mux.Handle("/assets/", dashboardHandler)
+	}
+
+	authenticator := handlers.NewAuthenticator(cfg.Auth.Tokens)
+	authenticator.AllowPublic("/healthz")
+	authenticator.AllowPublic("/readyz")
+	authenticator.AllowPublic("/api/v1/openapi.json")
+	authenticator.AllowPublic("/api/v1/docs")
+	authenticator.AllowQueryToken("/api/v1/events")
+	if dashboardFlag {
+		for _, path := range dashboard.Paths {
+			authenticator.AllowPublic(path)
+		}
+	}
+
+	// Apply middleware
+	handler := handlers.CORSMiddleware(cfg.CORS.AllowedOrigins)(handlers.LoggingMiddleware(authenticator.Middleware(handlers.ActorMiddleware(handlers.GoveeKeyMiddleware(mux)))))
+
+	port := portFlag
+	if port == "" {
+		port = os.Getenv("PORT")
+	}
+
+	if port == "" && unixSocketFlag == "" {
+		fatal("Port is required. Provide it via -port flag or PORT environment variable, or listen on a Unix socket with -unix-socket")
+	}
+
+	if (tlsCertFlag == "") != (tlsKeyFlag == "") {
+		fatal("Both -tls-cert and -tls-key are required to enable HTTPS")
+	}
+
+	var servers []*http.Server
+
+	// Each listener gets its own server so HTTP/2 setup for TLS does not interfere with plain listeners
+	newServer := func() *http.Server {
+		server := &http.Server{
+			Handler:  handler,
+			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		}
+		server.RegisterOnShutdown(goveeHandler.CloseStreams)
+		servers = append(servers, server)
+		return server
+	}
+
+	var tlsConfig *tls.Config
+	if tlsCertFlag != "" {
+		reloader, err := certs.NewReloader(tlsCertFlag, tlsKeyFlag)
+		if err != nil {
+			fatal("Failed to load TLS certificate", "error", err)
+		}
+
+		tlsConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+
+		runBackground(func(ctx context.Context) {
+			reloader.Watch(ctx, 30*time.Second)
+		})
+	}
+
+	errCh := make(chan error, 3)
+
+	if port != "" {
+		listener, err := listenTCP(bindFlag, port)
+		if err != nil {
+			fatal("Failed to start listener", "error", err)
+		}
+
+		server := newServer()
+		server.TLSConfig = tlsConfig
+		go func() {
+			if tlsConfig != nil {
+				errCh <- server.ServeTLS(listener, "", "")
+			} else {
+				errCh <- server.Serve(listener)
+			}
+		}()
+
+		slog.Info("Server starting", "addr", listener.Addr().String(), "tls", tlsConfig != nil)
+	}
+
+	if unixSocketFlag != "" {
+		listener, err := listenUnix(unixSocketFlag)
+		if err != nil {
+			fatal("Failed to start listener", "error", err)
+		}
+
+		server := newServer()
+		go func() {
+			errCh <- server.Serve(listener)
+		}()
+
+		slog.Info("Server starting", "socket", unixSocketFlag)
+	}
+
+	if lanFlag && discoveryIntervalFlag > 0 {
+		runBackground(func(ctx context.Context) {
+			goveeService.RunDiscovery(ctx, discoveryIntervalFlag)
+		})
+	}
+
+	if lanFlag && statePollIntervalFlag > 0 {
+		runBackground(func(ctx context.Context) {
+			goveeService.RunStatePolling(ctx, statePollIntervalFlag)
+		})
+	}
+
+	// Readings come from the cloud state API, which needs a configured account
+	if len(accounts) > 0 && readingsIntervalFlag > 0 {
+		runBackground(func(ctx context.Context) {
+			goveeService.RunReadingPolling(ctx, readingsIntervalFlag)
+		})
+	}
+
+	runBackground(func(ctx context.Context) {
+		webhookManager.Run(ctx, goveeService.Events())
+	})
+
+	runBackground(jobManager.Run)
+
+	if historyStore != nil {
+		runBackground(func(ctx context.Context) {
+			historyStore.Run(ctx)
+		})
+	}
+
+	if hueBridge != nil {
+		// The Hue API has its own user registration, so it is served on a separate listener without go-vee auth
+		listener, err := listenTCP(bindFlag, strconv.Itoa(huePortFlag))
+		if err != nil {
+			fatal("Failed to start Hue bridge listener", "error", err)
+		}
+
+		hueServer := &http.Server{
+			Handler:  handlers.LoggingMiddleware(hueBridge.Handler()),
+			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		}
+		servers = append(servers, hueServer)
+
+		go func() {
+			errCh <- hueServer.Serve(listener)
+		}()
+
+		runBackground(func(ctx context.Context) {
+			if err := hueBridge.RunSSDP(ctx); err != nil {
+				slog.Error("SSDP responder stopped", "error", err)
+			}
+		})
+
+		slog.Info("Hue bridge emulation starting", "addr", listener.Addr().String())
+	}
+
+	if mqttBrokerFlag != "" {
+		bridge := homeassistant.New(goveeService, homeassistant.Options{
+			Broker:          mqttBrokerFlag,
+			Username:        mqttUsernameFlag,
+			Password:        mqttPasswordFlag,
+			DiscoveryPrefix: mqttDiscoveryPrefixFlag,
+			TopicPrefix:     mqttTopicPrefixFlag,
+		})
+
+		runBackground(bridge.Run)
+	}
+
+	if cloudEventsFlag {
+		runBackground(func(ctx context.Context) {
+			goveeService.RunCloudEvents(ctx, cloudEventsBrokerFlag)
+		})
+	}
+
+	slog.Info("Server configuration", "lan_discovery", lanFlag, "auth", authenticator.Enabled(), "accounts", len(accounts), "home_assistant", mqttBrokerFlag != "")
+
+	exitCode := 0
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server stopped", "error", err)
+			exitCode = 1
+		}
+	case <-ctx.Done():
+		slog.Info("Shutdown signal received, draining in-flight requests", "timeout", shutdownTimeoutFlag)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeoutFlag)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down server gracefully", "error", err)
+		}
+	}
+
+	stopBackground()
+	background.Wait()
+
+	// Closed last, since requests and background work record history until they have stopped
+	if historyStore != nil {
+		historyStore.Close()
+	}
+	slog.Info("Server stopped")
+	os.Exit(exitCode)
+}
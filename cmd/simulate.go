@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/simulator"
+)
+
+// Collects repeated -device flags
+type deviceFlags []simulator.Device
+
+func (d *deviceFlags) String() string {
+	return fmt.Sprint(*d)
+}
+
+// Parses ip[,device[,sku]]; missing IDs and SKUs are generated
+func (d *deviceFlags) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) > 3 {
+		return fmt.Errorf("expected ip[,device[,sku]], got %q", value)
+	}
+
+	device := simulator.Device{
+		IP:     parts[0],
+		Device: fmt.Sprintf("5A:1E:00:00:00:00:00:%02X", len(*d)+1),
+		SKU:    "H6022",
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		device.Device = parts[1]
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		device.SKU = parts[2]
+	}
+
+	*d = append(*d, device)
+	return nil
+}
+
+// Runs simulated LAN devices until SIGINT or SIGTERM
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+
+	var devices deviceFlags
+	var interfaceFlag string
+	var noMulticastFlag bool
+	var dropFlag float64
+	var malformedFlag float64
+	var delayFlag time.Duration
+	var jitterFlag time.Duration
+	var logLevelFlag string
+
+	fs.Var(&devices, "device", "Simulated device as ip[,device[,sku]]; repeat for more devices (default: 127.0.0.1)")
+	fs.StringVar(&interfaceFlag, "interface", "", "Network interface that joins the multicast group (default: chosen by the system)")
+	fs.BoolVar(&noMulticastFlag, "no-multicast", false, "Only answer unicast scans")
+	fs.Float64Var(&dropFlag, "drop", 0, "Probability (0-1) of ignoring a packet")
+	fs.Float64Var(&malformedFlag, "malformed", 0, "Probability (0-1) of replying with malformed JSON")
+	fs.DurationVar(&delayFlag, "delay", 0, "Delay before handling each packet")
+	fs.DurationVar(&jitterFlag, "jitter", 0, "Random extra delay of up to this duration")
+	fs.StringVar(&logLevelFlag, "log-level", envOrDefault("LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-vee simulate [flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	logger, err := logging.New(os.Stderr, logLevelFlag, "text")
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	if len(devices) == 0 {
+		devices.Set("127.0.0.1")
+	}
+
+	sim, err := simulator.New(simulator.Options{
+		Devices:     devices,
+		Interface:   interfaceFlag,
+		NoMulticast: noMulticastFlag,
+		Faults: simulator.Faults{
+			DropRate:      dropFlag,
+			MalformedRate: malformedFlag,
+			Delay:         delayFlag,
+			Jitter:        jitterFlag,
+		},
+		Logger: logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := sim.Start(ctx); err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		fmt.Printf("%s\t%s\t%s\n", device.IP, device.Device, device.SKU)
+	}
+
+	sim.Wait()
+	return nil
+}
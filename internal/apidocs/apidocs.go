@@ -0,0 +1,14 @@
+package apidocs
+
+import _ "embed"
+
+// The OpenAPI 3 document of the go-vee HTTP API. It is maintained by hand and must be updated
+// together with the handlers; the tests in cmd check it against the registered routes.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// A page that renders OpenAPI without loading anything from outside the server
+//
+//go:embed index.html
+var Page []byte
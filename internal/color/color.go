@@ -0,0 +1,215 @@
+// Package color converts between the color models used by go-vee clients and the
+// packed RGB and Kelvin values understood by Govee devices.
+package color
+
+import (
+	"fmt"
+	"math"
+)
+
+type RGB struct {
+	R int `json:"r"`
+	G int `json:"g"`
+	B int `json:"b"`
+}
+
+// Returns the color packed as 0xRRGGBB, the format of the colorRgb capability
+func (c RGB) Packed() int {
+	return c.R<<16 | c.G<<8 | c.B
+}
+
+// Unpacks a 0xRRGGBB value
+func FromPacked(value int) RGB {
+	return RGB{
+		R: (value >> 16) & 0xFF,
+		G: (value >> 8) & 0xFF,
+		B: value & 0xFF,
+	}
+}
+
+func clamp(value, min, max float64) float64 {
+	return math.Max(min, math.Min(max, value))
+}
+
+func toByte(value float64) int {
+	return int(math.Round(clamp(value, 0, 1) * 255))
+}
+
+// Converts hue (degrees), saturation and value (0-1) to RGB
+func FromHSV(h, s, v float64) RGB {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	s = clamp(s, 0, 1)
+	v = clamp(v, 0, 1)
+
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return RGB{R: toByte(r + m), G: toByte(g + m), B: toByte(b + m)}
+}
+
+// Converts hue (degrees), saturation and lightness (0-1) to RGB
+func FromHSL(h, s, l float64) RGB {
+	s = clamp(s, 0, 1)
+	l = clamp(l, 0, 1)
+
+	v := l + s*math.Min(l, 1-l)
+	var sv float64
+	if v > 0 {
+		sv = 2 * (1 - l/v)
+	}
+
+	return FromHSV(h, sv, v)
+}
+
+// Returns hue (degrees), saturation and value (0-1) of an RGB color
+func (c RGB) HSV() (h, s, v float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	delta := maxC - minC
+
+	switch {
+	case delta == 0:
+		h = 0
+	case maxC == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case maxC == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	if maxC > 0 {
+		s = delta / maxC
+	}
+
+	return h, s, maxC
+}
+
+func gammaCorrect(value float64) float64 {
+	if value <= 0.0031308 {
+		return 12.92 * value
+	}
+
+	return 1.055*math.Pow(value, 1/2.4) - 0.055
+}
+
+func inverseGamma(value float64) float64 {
+	if value > 0.04045 {
+		return math.Pow((value+0.055)/1.055, 2.4)
+	}
+
+	return value / 12.92
+}
+
+// Converts CIE 1931 xy chromaticity coordinates at full brightness to RGB (wide gamut D65)
+func FromXY(x, y float64) RGB {
+	if y <= 0 {
+		return RGB{R: 255, G: 255, B: 255}
+	}
+
+	Y := 1.0
+	X := (Y / y) * x
+	Z := (Y / y) * (1 - x - y)
+
+	r := X*1.656492 - Y*0.354851 - Z*0.255038
+	g := -X*0.707196 + Y*1.655397 + Z*0.036152
+	b := X*0.051713 - Y*0.121364 + Z*1.011530
+
+	// Scale down so no channel exceeds 1 while keeping the hue
+	if maxC := math.Max(r, math.Max(g, b)); maxC > 1 {
+		r, g, b = r/maxC, g/maxC, b/maxC
+	}
+
+	return RGB{R: toByte(gammaCorrect(r)), G: toByte(gammaCorrect(g)), B: toByte(gammaCorrect(b))}
+}
+
+// Returns the CIE 1931 xy chromaticity coordinates of an RGB color
+func (c RGB) XY() (x, y float64) {
+	r := inverseGamma(float64(c.R) / 255)
+	g := inverseGamma(float64(c.G) / 255)
+	b := inverseGamma(float64(c.B) / 255)
+
+	X := r*0.664511 + g*0.154324 + b*0.162028
+	Y := r*0.283881 + g*0.668433 + b*0.047685
+	Z := r*0.000088 + g*0.072310 + b*0.986039
+
+	sum := X + Y + Z
+	if sum == 0 {
+		return 0.3127, 0.3290 // D65 white point
+	}
+
+	return X / sum, Y / sum
+}
+
+// Approximates the RGB color of a black body at the given temperature in Kelvin,
+// following Tanner Helland's fit of the CIE 1964 color matching functions
+func FromKelvin(kelvin int) RGB {
+	temp := clamp(float64(kelvin), 1000, 40000) / 100
+
+	var r, g, b float64
+	if temp <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(temp) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(temp-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(temp-60, -0.0755148492)
+	}
+
+	switch {
+	case temp >= 66:
+		b = 255
+	case temp <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(temp-10) - 305.0447927307
+	}
+
+	return RGB{R: toByte(r / 255), G: toByte(g / 255), B: toByte(b / 255)}
+}
+
+// Converts a color temperature in mireds to Kelvin
+func MiredToKelvin(mired int) int {
+	if mired <= 0 {
+		return 0
+	}
+
+	return int(math.Round(1e6 / float64(mired)))
+}
+
+// Converts a color temperature in Kelvin to mireds
+func KelvinToMired(kelvin int) int {
+	if kelvin <= 0 {
+		return 0
+	}
+
+	return int(math.Round(1e6 / float64(kelvin)))
+}
+
+// Formats the color as #rrggbb
+func (c RGB) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
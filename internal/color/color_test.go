@@ -0,0 +1,275 @@
+package color_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/EternityX/go-vee/internal/color"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  color.RGB
+	}{
+		// Hex
+		{"#ff8800", color.RGB{R: 255, G: 136, B: 0}},
+		{"#FF8800", color.RGB{R: 255, G: 136, B: 0}},
+		{"ff8800", color.RGB{R: 255, G: 136, B: 0}},
+		{"#f80", color.RGB{R: 255, G: 136, B: 0}},
+		{" #000000 ", color.RGB{}},
+
+		// CSS names
+		{"orange", color.RGB{R: 255, G: 165, B: 0}},
+		{"RebeccaPurple", color.RGB{R: 102, G: 51, B: 153}},
+
+		// rgb()
+		{"rgb(255, 136, 0)", color.RGB{R: 255, G: 136, B: 0}},
+		{"rgb(255 136 0)", color.RGB{R: 255, G: 136, B: 0}},
+		{"rgb(100%, 50%, 0%)", color.RGB{R: 255, G: 128, B: 0}},
+		{"rgb(300, -5, 0)", color.RGB{R: 255, G: 0, B: 0}},
+
+		// hsl()
+		{"hsl(0, 100%, 50%)", color.RGB{R: 255, G: 0, B: 0}},
+		{"hsl(32, 100%, 50%)", color.RGB{R: 255, G: 136, B: 0}},
+		{"hsl(120deg, 100%, 25%)", color.RGB{R: 0, G: 128, B: 0}},
+		{"hsl(240, 100, 50)", color.RGB{R: 0, G: 0, B: 255}},
+		{"hsl(0, 0%, 100%)", color.RGB{R: 255, G: 255, B: 255}},
+
+		// hsv() and hsb()
+		{"hsv(180, 100%, 100%)", color.RGB{R: 0, G: 255, B: 255}},
+		{"hsv(300, 50%, 100%)", color.RGB{R: 255, G: 128, B: 255}},
+		{"hsb(32, 100%, 100%)", color.RGB{R: 255, G: 136, B: 0}},
+
+		// Color temperatures
+		{"2000K", color.RGB{R: 255, G: 137, B: 14}},
+		{"6600k", color.RGB{R: 255, G: 255, B: 255}},
+		{"9000 K", color.RGB{R: 210, G: 223, B: 255}},
+	}
+
+	for _, tt := range tests {
+		got, err := color.Parse(tt.value)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejectsMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"#",
+		"#ff88",
+		"#ff88000",
+		"#gg0000",
+		"notacolor",
+		"rgb(1, 2)",
+		"rgb(1, 2, 3, 4)",
+		"rgb(a, b, c)",
+		"hsl(32, 100%)",
+		"cmyk(0, 0, 0)",
+		"999K",
+		"40001K",
+	}
+
+	for _, value := range tests {
+		if got, err := color.Parse(value); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", value, got)
+		}
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		value string
+		want  color.RGB
+		ok    bool
+	}{
+		{"#123456", color.RGB{R: 0x12, G: 0x34, B: 0x56}, true},
+		{"abc", color.RGB{R: 0xaa, G: 0xbb, B: 0xcc}, true},
+		{"#ffffff", color.RGB{R: 255, G: 255, B: 255}, true},
+		{"#12345", color.RGB{}, false},
+		{"#12345z", color.RGB{}, false},
+		{"-12345", color.RGB{}, false},
+		{"orange", color.RGB{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := color.ParseHex(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseHex(%q): error %v, want ok %v", tt.value, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseHex(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPacked(t *testing.T) {
+	rgb := color.RGB{R: 0x12, G: 0x34, B: 0x56}
+	if got := rgb.Packed(); got != 0x123456 {
+		t.Errorf("Packed() = %#x, want 0x123456", got)
+	}
+	if got := color.FromPacked(0x123456); got != rgb {
+		t.Errorf("FromPacked(0x123456) = %+v, want %+v", got, rgb)
+	}
+	if got := rgb.Hex(); got != "#123456" {
+		t.Errorf("Hex() = %s, want #123456", got)
+	}
+}
+
+func TestHSV(t *testing.T) {
+	tests := []struct {
+		h, s, v float64
+		want    color.RGB
+	}{
+		{0, 1, 1, color.RGB{R: 255, G: 0, B: 0}},
+		{120, 1, 1, color.RGB{R: 0, G: 255, B: 0}},
+		{240, 1, 1, color.RGB{R: 0, G: 0, B: 255}},
+		{32, 1, 1, color.RGB{R: 255, G: 136, B: 0}},
+		{360, 1, 1, color.RGB{R: 255, G: 0, B: 0}},
+		{-60, 1, 1, color.RGB{R: 255, G: 0, B: 255}},
+		{0, 0, 1, color.RGB{R: 255, G: 255, B: 255}},
+		{200, 1, 0, color.RGB{}},
+		{0, 2, 2, color.RGB{R: 255, G: 0, B: 0}},
+	}
+
+	for _, tt := range tests {
+		if got := color.FromHSV(tt.h, tt.s, tt.v); got != tt.want {
+			t.Errorf("FromHSV(%v, %v, %v) = %+v, want %+v", tt.h, tt.s, tt.v, got, tt.want)
+		}
+	}
+
+	// Back to HSV
+	h, s, v := color.RGB{R: 255, G: 136, B: 0}.HSV()
+	if math.Abs(h-32) > 0.1 || s != 1 || v != 1 {
+		t.Errorf("HSV() of #ff8800 = %v, %v, %v, want 32, 1, 1", h, s, v)
+	}
+
+	h, s, v = color.RGB{R: 255, G: 0, B: 128}.HSV()
+	if math.Abs(h-329.9) > 0.1 || s != 1 || v != 1 {
+		t.Errorf("HSV() of #ff0080 = %v, %v, %v, want 329.9, 1, 1", h, s, v)
+	}
+}
+
+func TestHSL(t *testing.T) {
+	tests := []struct {
+		h, s, l float64
+		want    color.RGB
+	}{
+		{0, 1, 0.5, color.RGB{R: 255, G: 0, B: 0}},
+		{120, 1, 0.25, color.RGB{R: 0, G: 128, B: 0}},
+		{210, 0.5, 0.5, color.RGB{R: 64, G: 128, B: 191}},
+		{0, 0, 0.5, color.RGB{R: 128, G: 128, B: 128}},
+		{90, 1, 1, color.RGB{R: 255, G: 255, B: 255}},
+		{90, 1, 0, color.RGB{}},
+	}
+
+	for _, tt := range tests {
+		if got := color.FromHSL(tt.h, tt.s, tt.l); got != tt.want {
+			t.Errorf("FromHSL(%v, %v, %v) = %+v, want %+v", tt.h, tt.s, tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestXY(t *testing.T) {
+	// The primaries of the wide gamut sit at the corners of the gamut triangle
+	corners := []struct {
+		rgb  color.RGB
+		x, y float64
+	}{
+		{color.RGB{R: 255}, 0.7006, 0.2993},
+		{color.RGB{G: 255}, 0.1724, 0.7468},
+		{color.RGB{B: 255}, 0.1355, 0.0399},
+	}
+
+	for _, tt := range corners {
+		x, y := tt.rgb.XY()
+		if math.Abs(x-tt.x) > 0.0005 || math.Abs(y-tt.y) > 0.0005 {
+			t.Errorf("XY() of %+v = %.4f, %.4f, want %.4f, %.4f", tt.rgb, x, y, tt.x, tt.y)
+		}
+
+		if got := color.FromXY(tt.x, tt.y); got != tt.rgb {
+			t.Errorf("FromXY(%v, %v) = %+v, want %+v", tt.x, tt.y, got, tt.rgb)
+		}
+	}
+
+	// Colors inside the gamut survive a round trip
+	for _, rgb := range []color.RGB{{R: 255, G: 136}, {R: 255, G: 255, B: 255}, {R: 64, G: 128, B: 255}} {
+		x, y := rgb.XY()
+		if got := color.FromXY(x, y); got != rgb {
+			t.Errorf("FromXY(XY(%+v)) = %+v", rgb, got)
+		}
+	}
+
+	// Points outside the gamut are brought back into range instead of overflowing
+	outside := color.FromXY(0.8, 0.2)
+	if outside.R != 255 || outside.G != 0 {
+		t.Errorf("FromXY(0.8, 0.2) = %+v, want a saturated red", outside)
+	}
+
+	if got := color.FromXY(0.3, 0); got != (color.RGB{R: 255, G: 255, B: 255}) {
+		t.Errorf("FromXY with y = 0 returned %+v, want white", got)
+	}
+
+	if x, y := (color.RGB{}).XY(); x != 0.3127 || y != 0.3290 {
+		t.Errorf("XY() of black = %v, %v, want the D65 white point", x, y)
+	}
+}
+
+func TestKelvin(t *testing.T) {
+	tests := []struct {
+		kelvin int
+		want   color.RGB
+	}{
+		// The range of Govee lights
+		{2000, color.RGB{R: 255, G: 137, B: 14}},
+		{6600, color.RGB{R: 255, G: 255, B: 255}},
+		{9000, color.RGB{R: 210, G: 223, B: 255}},
+
+		// Clamped to the range of the approximation
+		{1000, color.RGB{R: 255, G: 68, B: 0}},
+		{500, color.RGB{R: 255, G: 68, B: 0}},
+		{40000, color.RGB{R: 152, G: 186, B: 255}},
+		{50000, color.RGB{R: 152, G: 186, B: 255}},
+	}
+
+	for _, tt := range tests {
+		if got := color.FromKelvin(tt.kelvin); got != tt.want {
+			t.Errorf("FromKelvin(%d) = %+v, want %+v", tt.kelvin, got, tt.want)
+		}
+	}
+}
+
+func TestMired(t *testing.T) {
+	tests := []struct {
+		mired, kelvin int
+	}{
+		{500, 2000},
+		{111, 9009},
+		{153, 6536},
+		{0, 0},
+		{-1, 0},
+	}
+
+	for _, tt := range tests {
+		if got := color.MiredToKelvin(tt.mired); got != tt.kelvin {
+			t.Errorf("MiredToKelvin(%d) = %d, want %d", tt.mired, got, tt.kelvin)
+		}
+	}
+
+	if got := color.KelvinToMired(2000); got != 500 {
+		t.Errorf("KelvinToMired(2000) = %d, want 500", got)
+	}
+	if got := color.KelvinToMired(9000); got != 111 {
+		t.Errorf("KelvinToMired(9000) = %d, want 111", got)
+	}
+	if got := color.KelvinToMired(0); got != 0 {
+		t.Errorf("KelvinToMired(0) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,107 @@
+package color
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parses a color written as a CSS name ("orange"), hex ("#ff8800", "#f80"), a function
+// ("rgb(255, 136, 0)", "hsl(32, 100%, 50%)", "hsv(32, 100%, 100%)") or a color
+// temperature ("2700K")
+func Parse(value string) (RGB, error) {
+	text := strings.ToLower(strings.TrimSpace(value))
+
+	if rgb, ok := FromName(text); ok {
+		return rgb, nil
+	}
+
+	if open := strings.IndexByte(text, '('); open > 0 && strings.HasSuffix(text, ")") {
+		return parseFunction(value, text[:open], text[open+1:len(text)-1])
+	}
+
+	if kelvin, ok := strings.CutSuffix(text, "k"); ok {
+		if k, err := strconv.Atoi(strings.TrimSpace(kelvin)); err == nil {
+			if k < 1000 || k > 40000 {
+				return RGB{}, fmt.Errorf("color temperature %dK is out of range 1000-40000", k)
+			}
+
+			return FromKelvin(k), nil
+		}
+	}
+
+	rgb, err := ParseHex(text)
+	if err != nil {
+		return RGB{}, fmt.Errorf("unrecognized color %q", value)
+	}
+
+	return rgb, nil
+}
+
+// Parses a hex color in #rrggbb or #rgb form, with or without the leading #
+func ParseHex(value string) (RGB, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) != 6 {
+		return RGB{}, fmt.Errorf("invalid hex color %q", value)
+	}
+
+	packed, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return RGB{}, fmt.Errorf("invalid hex color %q", value)
+	}
+
+	return FromPacked(int(packed)), nil
+}
+
+func parseFunction(value, name, body string) (RGB, error) {
+	fields := strings.FieldsFunc(body, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '/'
+	})
+
+	if len(fields) != 3 {
+		return RGB{}, fmt.Errorf("invalid color %q: expected 3 components", value)
+	}
+
+	var components [3]float64
+	for i, field := range fields {
+		number, percent := strings.CutSuffix(field, "%")
+		if i == 0 && name != "rgb" {
+			number = strings.TrimSuffix(number, "deg")
+		}
+
+		parsed, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return RGB{}, fmt.Errorf("invalid color %q: bad component %q", value, field)
+		}
+
+		switch {
+		case name == "rgb" && percent:
+			parsed = parsed / 100 * 255
+		case name != "rgb" && i > 0:
+			// Saturation, lightness and value are percentages, with or without the %
+			parsed /= 100
+		}
+
+		components[i] = parsed
+	}
+
+	switch name {
+	case "rgb":
+		return RGB{
+			R: toByte(components[0] / 255),
+			G: toByte(components[1] / 255),
+			B: toByte(components[2] / 255),
+		}, nil
+	case "hsl":
+		return FromHSL(components[0], components[1], components[2]), nil
+	case "hsv", "hsb":
+		return FromHSV(components[0], components[1], components[2]), nil
+	}
+
+	return RGB{}, fmt.Errorf("invalid color %q: unknown function %q", value, name)
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+const (
+	ScopeRead    = "read"
+	ScopeControl = "control"
+)
+
+type Config struct {
+	Auth     AuthConfig `json:"auth"`
+	CORS     CORSConfig `json:"cors"`
+	Accounts []Account  `json:"accounts"`
+	Webhooks []Webhook  `json:"webhooks"`
+	LAN      LANConfig  `json:"lan"`
+	Alerts   []Alert    `json:"alerts"`
+}
+
+// A named Govee account. Devices of every account are merged into one list.
+type Account struct {
+	Name   string `json:"name"`
+	APIKey string `json:"apiKey"`
+}
+
+type AuthConfig struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// A credential accepted by the go-vee API, either as a bearer token or an X-API-Key header
+type Token struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
+// An outbound webhook target. Empty filters match every event.
+type Webhook struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	Secret       string   `json:"secret"`
+	Devices      []string `json:"devices"`
+	EventTypes   []string `json:"eventTypes"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Where LAN discovery looks for devices. By default one multicast scan is sent out of the
+// interface picked by the routing table.
+type LANConfig struct {
+	Interfaces    []string    `json:"interfaces"`
+	AllInterfaces bool        `json:"allInterfaces"`
+	Targets       []string    `json:"targets"`
+	Devices       []LANDevice `json:"devices"`
+}
+
+// A device with a known address that is used without being discovered
+type LANDevice struct {
+	IP     string `json:"ip"`
+	Device string `json:"device"`
+	SKU    string `json:"sku"`
+}
+
+// A threshold on a sensor reading. The alert fires when the metric rises above Above or
+// drops below Below. An empty Device matches every device.
+type Alert struct {
+	Name   string   `json:"name"`
+	Device string   `json:"device"`
+	Metric string   `json:"metric"`
+	Above  *float64 `json:"above"`
+	Below  *float64 `json:"below"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowedOrigins"`
+}
+
+// Reports whether the token grants the given scope. The control scope implies read.
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || (s == ScopeControl && scope == ScopeRead) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads and validates a JSON configuration file. An empty path yields an empty configuration.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	for i, token := range c.Auth.Tokens {
+		if token.Token == "" {
+			return fmt.Errorf("auth token %d (%s) has an empty token", i, token.Name)
+		}
+
+		if len(token.Scopes) == 0 {
+			return fmt.Errorf("auth token %d (%s) has no scopes", i, token.Name)
+		}
+
+		for _, scope := range token.Scopes {
+			if scope != ScopeRead && scope != ScopeControl {
+				return fmt.Errorf("auth token %d (%s) has unknown scope %q", i, token.Name, scope)
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, account := range c.Accounts {
+		if account.Name == "" {
+			return fmt.Errorf("account %d has no name", i)
+		}
+
+		if account.APIKey == "" {
+			return fmt.Errorf("account %s has an empty API key", account.Name)
+		}
+
+		if names[account.Name] {
+			return fmt.Errorf("account name %s is used more than once", account.Name)
+		}
+		names[account.Name] = true
+	}
+
+	for i, webhook := range c.Webhooks {
+		if webhook.URL == "" {
+			return fmt.Errorf("webhook %d has no url", i)
+		}
+	}
+
+	for i, device := range c.LAN.Devices {
+		if device.IP == "" || device.Device == "" || device.SKU == "" {
+			return fmt.Errorf("lan device %d needs ip, device and sku", i)
+		}
+
+		if net.ParseIP(device.IP).To4() == nil {
+			return fmt.Errorf("lan device %s has an invalid IPv4 address %q", device.Device, device.IP)
+		}
+	}
+
+	alerts := make(map[string]bool)
+	for i, alert := range c.Alerts {
+		if alert.Name == "" {
+			return fmt.Errorf("alert %d has no name", i)
+		}
+
+		if alerts[alert.Name] {
+			return fmt.Errorf("alert name %s is used more than once", alert.Name)
+		}
+		alerts[alert.Name] = true
+
+		if alert.Metric == "" {
+			return fmt.Errorf("alert %s has no metric", alert.Name)
+		}
+
+		if alert.Above == nil && alert.Below == nil {
+			return fmt.Errorf("alert %s needs above or below", alert.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+"use strict";
+
+const tokenKey = "go-vee-token";
+const cards = new Map(); // device ID -> card
+
+let events = null;
+let declined = false; // the user dismissed the token prompt
+
+function token() {
+  return localStorage.getItem(tokenKey) || "";
+}
+
+function askToken() {
+  const value = prompt("Access token (leave empty when authentication is disabled)", token());
+  if (value === null) {
+    declined = true;
+    return false;
+  }
+  declined = false;
+  localStorage.setItem(tokenKey, value.trim());
+  return true;
+}
+
+function showMessage(text, info) {
+  const message = document.getElementById("message");
+  message.textContent = text;
+  message.className = info ? "message info" : "message";
+  message.hidden = !text;
+}
+
+// Calls the API, asking for an access token once when the server answers 401. Requests that
+// fail while another one already asked are retried with the new token without asking again.
+async function api(path, options = {}, retried = false) {
+  const used = token();
+  const headers = { ...(options.headers || {}) };
+  if (used) {
+    headers.Authorization = "Bearer " + used;
+  }
+  if (options.body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+
+  const response = await fetch(path, {
+    method: options.method || "GET",
+    headers,
+    body: options.body === undefined ? undefined : JSON.stringify(options.body),
+  });
+
+  if (response.status === 401 && !retried) {
+    if (token() !== used) {
+      return api(path, options, true);
+    }
+    if (!declined && askToken()) {
+      connectEvents();
+      return api(path, options, true);
+    }
+  }
+
+  const body = await response.json().catch(() => ({}));
+  if (!response.ok) {
+    throw new Error(body.description || body.error || response.statusText);
+  }
+  return body;
+}
+
+function hex(r, g, b) {
+  return "#" + [r, g, b].map((v) => Math.max(0, Math.min(255, v | 0)).toString(16).padStart(2, "0")).join("");
+}
+
+function packedToHex(value) {
+  const packed = Number(value) | 0;
+  return hex((packed >> 16) & 0xff, (packed >> 8) & 0xff, packed & 0xff);
+}
+
+// Sends the latest value of an input at most once per request: values that arrive while a
+// request is in flight replace each other and only the last one is sent afterwards
+function throttled(send) {
+  let inFlight = false;
+  let pending;
+
+  const run = async (value) => {
+    inFlight = true;
+    try {
+      await send(value);
+    } finally {
+      inFlight = false;
+      if (pending !== undefined) {
+        const next = pending;
+        pending = undefined;
+        run(next);
+      }
+    }
+  };
+
+  return (value) => {
+    if (inFlight) {
+      pending = value;
+    } else {
+      run(value);
+    }
+  };
+}
+
+async function control(card, type, instance, value) {
+  const { device } = card;
+  card.status.textContent = "sending…";
+  try {
+    const result = await api("/api/v1/devices/control", {
+      method: "POST",
+      body: { sku: device.sku, device: device.device, capability: { type, instance, value } },
+    });
+    card.status.textContent = "sent via " + result.transport + " in " + Math.round(result.latencyMs) + " ms";
+  } catch (err) {
+    card.status.textContent = err.message;
+  }
+}
+
+function applyState(card, state) {
+  if (!state) {
+    return;
+  }
+  if (state.on !== undefined) {
+    card.power.checked = state.on;
+    card.root.classList.toggle("off", !state.on);
+  }
+  if (state.brightness > 0) {
+    card.brightness.value = state.brightness;
+  }
+  if (state.color && (state.color.r || state.color.g || state.color.b)) {
+    const color = hex(state.color.r, state.color.g, state.color.b);
+    card.color.value = color;
+    card.swatch.style.background = color;
+  }
+}
+
+// Updates a card from a successful control command, so changes made by other clients show up
+function applyControl(card, event) {
+  const value = event.data && event.data.value;
+  switch (event.instance) {
+    case "powerSwitch":
+      applyState(card, { on: Number(value) === 1 });
+      break;
+    case "brightness":
+      applyState(card, { brightness: Number(value) });
+      break;
+    case "colorRgb": {
+      const color = packedToHex(value);
+      card.color.value = color;
+      card.swatch.style.background = color;
+      break;
+    }
+  }
+}
+
+const readingLabels = [
+  ["temperature", "Temperature", " °C"],
+  ["humidity", "Humidity", " %"],
+  ["pm25", "PM2.5", " µg/m³"],
+  ["waterLevel", "Water", " %"],
+  ["filterLife", "Filter", " %"],
+  ["battery", "Battery", " %"],
+];
+
+function applyReading(card, reading) {
+  const parts = readingLabels
+    .filter(([field]) => reading[field] !== undefined)
+    .map(([field, label, unit]) => label + " " + reading[field] + unit);
+  card.readings.textContent = parts.join(" · ");
+  card.readings.hidden = !parts.length;
+}
+
+async function loadReadings() {
+  try {
+    const result = await api("/api/v1/readings");
+    for (const reading of result.data) {
+      const card = cards.get(reading.device);
+      if (card) {
+        applyReading(card, reading);
+      }
+    }
+  } catch (err) {
+    // Readings are optional, the cards work without them
+  }
+}
+
+async function refreshState(card) {
+  card.status.textContent = "reading state…";
+  try {
+    const result = await api("/api/v1/devices/state?sku=" + encodeURIComponent(card.device.sku) + "&device=" + encodeURIComponent(card.device.device));
+    applyState(card, result.data);
+    card.status.textContent = "state read via " + result.transport;
+  } catch (err) {
+    card.status.textContent = err.message;
+  }
+}
+
+function renderDevice(device) {
+  const fragment = document.getElementById("device-card").content.cloneNode(true);
+  const root = fragment.querySelector(".card");
+  const card = {
+    device,
+    root,
+    power: root.querySelector(".power"),
+    brightness: root.querySelector(".brightness"),
+    color: root.querySelector(".color"),
+    swatch: root.querySelector(".swatch"),
+    status: root.querySelector(".status"),
+    readings: root.querySelector(".readings"),
+  };
+
+  root.querySelector(".name").textContent = device.name || device.device;
+  const meta = root.querySelector(".meta");
+  meta.textContent = device.sku + " · " + device.device + (device.ip ? " · " + device.ip : "");
+  for (const [flag, label] of [[device.lan, "LAN"], [device.cloud, "cloud"]]) {
+    if (flag) {
+      const badge = document.createElement("span");
+      badge.className = "badge";
+      badge.textContent = label;
+      meta.append(badge);
+    }
+  }
+
+  // Devices only seen on the LAN are lights, since only lights offer the LAN API
+  const light = device.type === "devices.types.light" || (!device.cloud && device.lan);
+  const readOnly = ["devices.types.thermometer", "devices.types.sensor"].includes(device.type);
+  card.brightness.parentElement.hidden = !light;
+  card.color.parentElement.hidden = !light;
+  card.power.parentElement.hidden = readOnly;
+
+  card.power.addEventListener("change", () => {
+    card.root.classList.toggle("off", !card.power.checked);
+    control(card, "devices.capabilities.on_off", "powerSwitch", card.power.checked ? 1 : 0);
+  });
+
+  const sendBrightness = throttled((value) => control(card, "devices.capabilities.range", "brightness", value));
+  card.brightness.addEventListener("input", () => sendBrightness(Number(card.brightness.value)));
+
+  const sendColor = throttled((value) => control(card, "devices.capabilities.color_setting", "colorRgb", value));
+  card.color.addEventListener("input", () => {
+    card.swatch.style.background = card.color.value;
+    sendColor(card.color.value);
+  });
+
+  root.querySelector(".refresh").addEventListener("click", () => refreshState(card));
+
+  applyState(card, device.state);
+  cards.set(device.device, card);
+  return root;
+}
+
+async function loadDevices() {
+  const container = document.getElementById("devices");
+  try {
+    const result = await api("/api/v1/inventory");
+    cards.clear();
+    container.textContent = "";
+    if (!result.data.length) {
+      container.innerHTML = '<span class="muted">No devices found</span>';
+      return;
+    }
+    for (const device of result.data) {
+      container.append(renderDevice(device));
+      // Reading the state over the LAN is free, the cloud state is only read on request
+      if (device.lan && !device.state) {
+        refreshState(cards.get(device.device));
+      }
+    }
+    loadReadings();
+  } catch (err) {
+    showMessage("Failed to load devices: " + err.message);
+  }
+}
+
+async function loadScenes() {
+  const container = document.getElementById("scenes");
+  try {
+    const result = await api("/api/v1/scenes");
+    if (!result.data.length) {
+      return;
+    }
+    container.textContent = "";
+    for (const scene of result.data) {
+      const button = document.createElement("button");
+      button.type = "button";
+      button.textContent = scene.name;
+      button.addEventListener("click", async () => {
+        try {
+          const activation = await api("/api/v1/scenes/" + encodeURIComponent(scene.name) + "/activate", { method: "POST", body: {} });
+          const failed = activation.data.filter((r) => !r.success);
+          showMessage(failed.length ? "Scene " + scene.name + " failed on " + failed.map((r) => r.device).join(", ") : "Scene " + scene.name + " activated", !failed.length);
+        } catch (err) {
+          showMessage("Failed to activate " + scene.name + ": " + err.message);
+        }
+      });
+      container.append(button);
+    }
+  } catch (err) {
+    showMessage("Failed to load scenes: " + err.message);
+  }
+}
+
+function connectEvents() {
+  if (events) {
+    events.close();
+  }
+
+  const status = document.getElementById("connection");
+  const query = token() ? "?access_token=" + encodeURIComponent(token()) : "";
+  events = new EventSource("/api/v1/events" + query);
+
+  events.onopen = () => {
+    status.textContent = "live";
+    status.classList.add("live");
+  };
+  events.onerror = () => {
+    status.textContent = events.readyState === EventSource.CLOSED ? "disconnected" : "reconnecting…";
+    status.classList.remove("live");
+  };
+
+  events.addEventListener("device.state_changed", (message) => {
+    const event = JSON.parse(message.data);
+    const card = cards.get(event.device);
+    if (card) {
+      applyState(card, event.data.current);
+    }
+  });
+  events.addEventListener("control.succeeded", (message) => {
+    const event = JSON.parse(message.data);
+    const card = cards.get(event.device);
+    if (card) {
+      applyControl(card, event);
+    }
+  });
+  events.addEventListener("device.reading", (message) => {
+    const event = JSON.parse(message.data);
+    const card = cards.get(event.device);
+    if (card) {
+      applyReading(card, event.data);
+    }
+  });
+  for (const type of ["lan.device.appeared", "lan.device.disappeared"]) {
+    events.addEventListener(type, () => loadDevices());
+  }
+}
+
+document.getElementById("token").addEventListener("click", () => {
+  if (askToken()) {
+    connectEvents();
+    loadScenes();
+    loadDevices();
+  }
+});
+document.getElementById("reload").addEventListener("click", () => loadDevices());
+
+connectEvents();
+loadScenes();
+loadDevices();
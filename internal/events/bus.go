@@ -0,0 +1,100 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published on the bus
+const (
+	TypeDeviceEvent        = "device.event"
+	TypeControlSucceeded   = "control.succeeded"
+	TypeControlFailed      = "control.failed"
+	TypeDeviceAppeared     = "lan.device.appeared"
+	TypeDeviceDisappeared  = "lan.device.disappeared"
+	TypeDeviceStateChanged = "device.state_changed"
+	TypeDeviceReading      = "device.reading"
+	TypeAlertFiring        = "reading.alert"
+	TypeAlertCleared       = "reading.alert_cleared"
+)
+
+type Event struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Time       time.Time   `json:"time"`
+	Source     string      `json:"source"`
+	SKU        string      `json:"sku,omitempty"`
+	Device     string      `json:"device,omitempty"`
+	Capability string      `json:"capability,omitempty"`
+	Instance   string      `json:"instance,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Fans events out to every subscriber. Slow subscribers drop events instead of blocking publishers.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	bus    *Bus
+	events chan Event
+	once   sync.Once
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Registers a subscriber with room for buffer undelivered events
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	sub := &Subscription{
+		bus:    b,
+		events: make(chan Event, buffer),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Delivers an event to every subscriber, filling in the ID and time when missing
+func (b *Bus) Publish(event Event) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+// Returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscribers, s)
+		s.bus.mu.Unlock()
+
+		close(s.events)
+	})
+}
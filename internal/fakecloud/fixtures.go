@@ -0,0 +1,202 @@
+package fakecloud
+
+// A dynamic scene listed by the scene endpoint of a light
+type Scene struct {
+	Name    string
+	ID      int
+	ParamID int
+}
+
+// A capability as listed by /user/devices
+type Capability struct {
+	Type       string                 `json:"type"`
+	Instance   string                 `json:"instance"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// A fake device. State holds the current value of every stateful instance.
+type Device struct {
+	SKU          string       `json:"sku"`
+	Device       string       `json:"device"`
+	DeviceName   string       `json:"deviceName"`
+	Type         string       `json:"type"`
+	Capabilities []Capability `json:"capabilities"`
+
+	Scenes    []Scene                `json:"-"`
+	DIYScenes []Scene                `json:"-"`
+	State     map[string]interface{} `json:"-"`
+}
+
+func onOff() Capability {
+	return Capability{
+		Type:     "devices.capabilities.on_off",
+		Instance: "powerSwitch",
+		Parameters: map[string]interface{}{
+			"dataType": "ENUM",
+			"options": []map[string]interface{}{
+				{"name": "on", "value": 1},
+				{"name": "off", "value": 0},
+			},
+		},
+	}
+}
+
+func brightness() Capability {
+	return Capability{
+		Type:     "devices.capabilities.range",
+		Instance: "brightness",
+		Parameters: map[string]interface{}{
+			"unit":     "unit.percent",
+			"dataType": "INTEGER",
+			"range":    map[string]interface{}{"min": 1, "max": 100, "precision": 1},
+		},
+	}
+}
+
+func colorRGB() Capability {
+	return Capability{
+		Type:     "devices.capabilities.color_setting",
+		Instance: "colorRgb",
+		Parameters: map[string]interface{}{
+			"dataType": "INTEGER",
+			"range":    map[string]interface{}{"min": 0, "max": 16777215, "precision": 1},
+		},
+	}
+}
+
+func colorTemperature(min, max int) Capability {
+	return Capability{
+		Type:     "devices.capabilities.color_setting",
+		Instance: "colorTemperatureK",
+		Parameters: map[string]interface{}{
+			"dataType": "INTEGER",
+			"range":    map[string]interface{}{"min": min, "max": max, "precision": 1},
+		},
+	}
+}
+
+// The device list leaves scene options empty; they are returned by the scene endpoints
+func sceneCapability(instance string) Capability {
+	return Capability{
+		Type:     "devices.capabilities.dynamic_scene",
+		Instance: instance,
+		Parameters: map[string]interface{}{
+			"dataType": "ENUM",
+			"options":  []interface{}{},
+		},
+	}
+}
+
+func property(instance string) Capability {
+	return Capability{
+		Type:     "devices.capabilities.property",
+		Instance: instance,
+	}
+}
+
+func lightState() map[string]interface{} {
+	return map[string]interface{}{
+		"online":            true,
+		"powerSwitch":       1,
+		"brightness":        100,
+		"colorRgb":          0xFFFFFF,
+		"colorTemperatureK": 0,
+	}
+}
+
+// Returns a fresh set of fixture devices: three lights, a socket, a thermometer and an air purifier
+func Fixtures() []Device {
+	lightCapabilities := func(minK, maxK int) []Capability {
+		return []Capability{
+			onOff(),
+			brightness(),
+			colorRGB(),
+			colorTemperature(minK, maxK),
+			sceneCapability("lightScene"),
+			sceneCapability("diyScene"),
+		}
+	}
+
+	scenes := []Scene{
+		{Name: "Sunrise", ID: 3853, ParamID: 4280},
+		{Name: "Sunset", ID: 3854, ParamID: 4281},
+		{Name: "Aurora", ID: 3858, ParamID: 4285},
+		{Name: "Candlelight", ID: 3867, ParamID: 4294},
+	}
+
+	return []Device{
+		{
+			SKU:          "H6008",
+			Device:       "D7:B4:C1:38:B3:A1:46:D6",
+			DeviceName:   "Living Room Bulb",
+			Type:         "devices.types.light",
+			Capabilities: lightCapabilities(2700, 6500),
+			Scenes:       scenes,
+			State:        lightState(),
+		},
+		{
+			SKU:          "H6199",
+			Device:       "9A:52:D4:AD:FC:E8:7F:3B",
+			DeviceName:   "TV Backlight",
+			Type:         "devices.types.light",
+			Capabilities: lightCapabilities(2000, 9000),
+			Scenes:       scenes,
+			DIYScenes:    []Scene{{Name: "Movie Night", ID: 8216, ParamID: 0}},
+			State:        lightState(),
+		},
+		{
+			SKU:          "H6022",
+			Device:       "1F:80:C5:32:32:36:72:4E",
+			DeviceName:   "Desk Lamp",
+			Type:         "devices.types.light",
+			Capabilities: lightCapabilities(2200, 6500),
+			Scenes:       scenes[:2],
+			State:        lightState(),
+		},
+		{
+			SKU:          "H5080",
+			Device:       "3C:45:7A:52:B4:19:06:33",
+			DeviceName:   "Heater Plug",
+			Type:         "devices.types.socket",
+			Capabilities: []Capability{onOff()},
+			State: map[string]interface{}{
+				"online":      true,
+				"powerSwitch": 0,
+			},
+		},
+		{
+			SKU:        "H5179",
+			Device:     "6B:20:A4:C1:38:5F:E3:1D",
+			DeviceName: "Bedroom Thermometer",
+			Type:       "devices.types.thermometer",
+			Capabilities: []Capability{
+				property("sensorTemperature"),
+				property("sensorHumidity"),
+				property("battery"),
+			},
+			State: map[string]interface{}{
+				"online":            true,
+				"sensorTemperature": 71.6,
+				"sensorHumidity":    44,
+				"battery":           87,
+			},
+		},
+		{
+			SKU:        "H7126",
+			Device:     "0E:72:D0:C9:07:A6:11:5B",
+			DeviceName: "Office Air Purifier",
+			Type:       "devices.types.air_purifier",
+			Capabilities: []Capability{
+				onOff(),
+				property("airQuality"),
+				property("filterLifeTime"),
+			},
+			State: map[string]interface{}{
+				"online":         true,
+				"powerSwitch":    1,
+				"airQuality":     12,
+				"filterLifeTime": 64,
+			},
+		},
+	}
+}
@@ -0,0 +1,476 @@
+// Package fakecloud implements the parts of the Govee cloud API that go-vee uses, backed by
+// fixture devices, so the service can be tested and demonstrated without the network.
+package fakecloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	// Devices served by the API; nil uses Fixtures
+	Devices []Device
+	// Accepted Govee-API-Key values; empty accepts any non-empty key
+	APIKeys []string
+	// Delay before every response, plus a random extra of up to Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+	// Probability (0-1) that a request fails with 500 or 503
+	ErrorRate float64
+	// Requests allowed per API key in each RateWindow; 0 disables rate limiting
+	RateLimit  int
+	RateWindow time.Duration
+	Logger     *slog.Logger
+}
+
+type rateCounter struct {
+	used    int
+	resetAt time.Time
+}
+
+type Server struct {
+	opts Options
+	mux  *http.ServeMux
+
+	mu      sync.Mutex
+	devices map[string]*Device
+	order   []string
+	rates   map[string]*rateCounter
+	failing int
+}
+
+func New(opts Options) *Server {
+	if opts.Devices == nil {
+		opts.Devices = Fixtures()
+	}
+
+	if opts.RateWindow <= 0 {
+		opts.RateWindow = 24 * time.Hour
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	s := &Server{
+		opts:    opts,
+		mux:     http.NewServeMux(),
+		devices: make(map[string]*Device),
+		rates:   make(map[string]*rateCounter),
+	}
+
+	for i := range opts.Devices {
+		device := opts.Devices[i]
+		s.devices[device.Device] = &device
+		s.order = append(s.order, device.Device)
+	}
+
+	s.mux.HandleFunc("GET /router/api/v1/user/devices", s.handleDevices)
+	s.mux.HandleFunc("POST /router/api/v1/device/control", s.handleControl)
+	s.mux.HandleFunc("POST /router/api/v1/device/state", s.handleState)
+	s.mux.HandleFunc("POST /router/api/v1/device/scenes", s.handleScenes("lightScene"))
+	s.mux.HandleFunc("POST /router/api/v1/device/diy-scenes", s.handleScenes("diyScene"))
+
+	return s
+}
+
+// Makes every following request fail with the given HTTP status until it is called with 0
+func (s *Server) SetFailure(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failing = status
+}
+
+// Returns the current value of an instance of a device, such as "powerSwitch" or "colorRgb"
+func (s *Server) State(deviceID string, instance string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[deviceID]
+	if !ok {
+		return nil, false
+	}
+
+	value, ok := device.State[instance]
+	return value, ok
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	delay := s.opts.Latency
+	if s.opts.Jitter > 0 {
+		delay += rand.N(s.opts.Jitter)
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.opts.Logger.Info("Fake cloud request", "method", r.Method, "path", r.URL.Path)
+
+	apiKey := r.Header.Get("Govee-API-Key")
+	if !s.authorized(apiKey) {
+		writeError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	if !s.allow(w, apiKey) {
+		writeError(w, http.StatusTooManyRequests, "Too many requests, please try again later")
+		return
+	}
+
+	s.mu.Lock()
+	failing := s.failing
+	s.mu.Unlock()
+
+	if failing == 0 && s.opts.ErrorRate > 0 && rand.Float64() < s.opts.ErrorRate {
+		failing = http.StatusInternalServerError
+		if rand.IntN(2) == 0 {
+			failing = http.StatusServiceUnavailable
+		}
+	}
+
+	if failing != 0 {
+		writeError(w, failing, http.StatusText(failing))
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(apiKey string) bool {
+	if apiKey == "" {
+		return false
+	}
+
+	if len(s.opts.APIKeys) == 0 {
+		return true
+	}
+
+	for _, key := range s.opts.APIKeys {
+		if key == apiKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Counts the request against the key's quota and sets the rate limit headers Govee sends
+func (s *Server) allow(w http.ResponseWriter, apiKey string) bool {
+	if s.opts.RateLimit <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	counter, ok := s.rates[apiKey]
+	if !ok || now.After(counter.resetAt) {
+		counter = &rateCounter{resetAt: now.Add(s.opts.RateWindow)}
+		s.rates[apiKey] = counter
+	}
+
+	allowed := counter.used < s.opts.RateLimit
+	if allowed {
+		counter.used++
+	}
+
+	remaining := strconv.Itoa(s.opts.RateLimit - counter.used)
+	reset := strconv.FormatInt(counter.resetAt.Unix(), 10)
+
+	h := w.Header()
+	h.Set("API-RateLimit-Limit", strconv.Itoa(s.opts.RateLimit))
+	h.Set("API-RateLimit-Remaining", remaining)
+	h.Set("API-RateLimit-Reset", reset)
+	h.Set("X-RateLimit-Limit", strconv.Itoa(s.opts.RateLimit))
+	h.Set("X-RateLimit-Remaining", remaining)
+	h.Set("X-RateLimit-Reset", reset)
+
+	if !allowed {
+		h.Set("Retry-After", strconv.Itoa(int(time.Until(counter.resetAt).Seconds())+1))
+	}
+
+	return allowed
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"code":    status,
+		"message": message,
+	})
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	devices := make([]Device, 0, len(s.order))
+	for _, id := range s.order {
+		devices = append(devices, *s.devices[id])
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data":    devices,
+	})
+}
+
+type deviceRequest struct {
+	RequestID string `json:"requestId"`
+	Payload   struct {
+		SKU        string `json:"sku"`
+		Device     string `json:"device"`
+		Capability struct {
+			Type     string          `json:"type"`
+			Instance string          `json:"instance"`
+			Value    json.RawMessage `json:"value"`
+		} `json:"capability"`
+	} `json:"payload"`
+}
+
+// Decodes a request body and looks up its device. Writes the error response on failure.
+func (s *Server) decode(w http.ResponseWriter, r *http.Request) (*deviceRequest, *Device, bool) {
+	var req deviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, nil, false
+	}
+
+	if req.Payload.SKU == "" || req.Payload.Device == "" {
+		writeError(w, http.StatusBadRequest, "Parameter sku and device cannot be empty")
+		return nil, nil, false
+	}
+
+	device, ok := s.devices[req.Payload.Device]
+	if !ok || device.SKU != req.Payload.SKU {
+		writeError(w, http.StatusBadRequest, "devices not exist")
+		return nil, nil, false
+	}
+
+	return &req, device, true
+}
+
+func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, device, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+
+	capability := req.Payload.Capability
+	supported := false
+	for _, c := range device.Capabilities {
+		if c.Type == capability.Type && c.Instance == capability.Instance {
+			supported = true
+			break
+		}
+	}
+
+	if !supported {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Capability %s/%s is not supported by %s", capability.Type, capability.Instance, device.SKU))
+		return
+	}
+
+	value, err := s.applyControl(device, capability.Instance, capability.Value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"requestId": req.RequestID,
+		"msg":       "success",
+		"code":      200,
+		"capability": map[string]interface{}{
+			"type":     capability.Type,
+			"instance": capability.Instance,
+			"state":    map[string]interface{}{"status": "success"},
+			"value":    value,
+		},
+	})
+}
+
+// Validates a control value against the device's capability and stores it
+func (s *Server) applyControl(device *Device, instance string, raw json.RawMessage) (interface{}, error) {
+	if instance == "lightScene" || instance == "diyScene" {
+		var scene struct {
+			ID      int `json:"id"`
+			ParamID int `json:"paramId"`
+		}
+		if err := json.Unmarshal(raw, &scene); err != nil {
+			return nil, fmt.Errorf("Parameter value must be an object with id and paramId")
+		}
+
+		scenes := device.Scenes
+		if instance == "diyScene" {
+			scenes = device.DIYScenes
+		}
+
+		for _, candidate := range scenes {
+			if candidate.ID == scene.ID {
+				device.State[instance] = scene
+				device.State["powerSwitch"] = 1
+				return scene, nil
+			}
+		}
+
+		return nil, fmt.Errorf("Scene %d does not exist", scene.ID)
+	}
+
+	var value int
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("Parameter value must be an integer")
+	}
+
+	min, max := 0, 0
+	switch instance {
+	case "powerSwitch":
+		min, max = 0, 1
+	case "brightness":
+		min, max = 1, 100
+	case "colorRgb":
+		min, max = 0, 0xFFFFFF
+	case "colorTemperatureK":
+		min, max = 2000, 9000
+		for _, c := range device.Capabilities {
+			if limits, ok := c.Parameters["range"].(map[string]interface{}); ok && c.Instance == instance {
+				min, max = toInt(limits["min"], min), toInt(limits["max"], max)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Capability %s cannot be controlled", instance)
+	}
+
+	if value < min || value > max {
+		return nil, fmt.Errorf("Parameter value out of range [%d, %d]", min, max)
+	}
+
+	device.State[instance] = value
+
+	// Devices switch on when their light is changed; RGB and color temperature exclude each other
+	switch instance {
+	case "brightness":
+		device.State["powerSwitch"] = 1
+	case "colorRgb":
+		device.State["powerSwitch"] = 1
+		device.State["colorTemperatureK"] = 0
+	case "colorTemperatureK":
+		device.State["powerSwitch"] = 1
+		device.State["colorRgb"] = 0
+	}
+
+	return value, nil
+}
+
+func toInt(value interface{}, fallback int) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+
+	return fallback
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, device, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+
+	capabilities := []map[string]interface{}{{
+		"type":     "devices.capabilities.online",
+		"instance": "online",
+		"state":    map[string]interface{}{"value": device.State["online"]},
+	}}
+
+	for _, c := range device.Capabilities {
+		value, ok := device.State[c.Instance]
+		if !ok {
+			value = ""
+		}
+
+		capabilities = append(capabilities, map[string]interface{}{
+			"type":     c.Type,
+			"instance": c.Instance,
+			"state":    map[string]interface{}{"value": value},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"requestId": req.RequestID,
+		"msg":       "success",
+		"code":      200,
+		"payload": map[string]interface{}{
+			"sku":          device.SKU,
+			"device":       device.Device,
+			"capabilities": capabilities,
+		},
+	})
+}
+
+func (s *Server) handleScenes(instance string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		req, device, ok := s.decode(w, r)
+		if !ok {
+			return
+		}
+
+		scenes := device.Scenes
+		if instance == "diyScene" {
+			scenes = device.DIYScenes
+		}
+
+		options := make([]map[string]interface{}, 0, len(scenes))
+		for _, scene := range scenes {
+			options = append(options, map[string]interface{}{
+				"name":  scene.Name,
+				"value": map[string]interface{}{"id": scene.ID, "paramId": scene.ParamID},
+			})
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"requestId": req.RequestID,
+			"msg":       "success",
+			"code":      200,
+			"payload": map[string]interface{}{
+				"sku":    device.SKU,
+				"device": device.Device,
+				"capabilities": []map[string]interface{}{{
+					"type":     "devices.capabilities.dynamic_scene",
+					"instance": instance,
+					"parameters": map[string]interface{}{
+						"dataType": "ENUM",
+						"options":  options,
+					},
+				}},
+			},
+		})
+	}
+}
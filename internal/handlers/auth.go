@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/EternityX/go-vee/internal/config"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+type identityContextKey struct{}
+
+// Checks bearer tokens and API keys against the configured credentials
+type Authenticator struct {
+	tokens          []config.Token
+	publicPaths     map[string]bool
+	queryTokenPaths map[string]bool
+}
+
+func NewAuthenticator(tokens []config.Token) *Authenticator {
+	return &Authenticator{
+		tokens:          tokens,
+		publicPaths:     make(map[string]bool),
+		queryTokenPaths: make(map[string]bool),
+	}
+}
+
+// Exempts a path from authentication
+func (a *Authenticator) AllowPublic(path string) {
+	a.publicPaths[path] = true
+}
+
+// Also accepts the credential as an access_token query parameter on GET requests to the path.
+// Browsers cannot set headers on an EventSource, so event streams need this.
+func (a *Authenticator) AllowQueryToken(path string) {
+	a.queryTokenPaths[path] = true
+}
+
+// Reports whether any credentials are configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.tokens) > 0
+}
+
+// Returns the name of the credential that authenticated the request, if any
+func IdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityContextKey{}).(string)
+	return identity
+}
+
+func credentialFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	return r.Header.Get("X-API-Key")
+}
+
+func (a *Authenticator) lookup(credential string) (config.Token, bool) {
+	for _, token := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Token), []byte(credential)) == 1 {
+			return token, true
+		}
+	}
+
+	return config.Token{}, false
+}
+
+// Safe methods need the read scope, everything else needs the control scope
+func requiredScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return config.ScopeRead
+	}
+
+	return config.ScopeControl
+}
+
+// Rejects requests without a valid credential (401) or without the required scope (403)
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() || a.publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		credential := credentialFromRequest(r)
+		if credential == "" && r.Method == http.MethodGet && a.queryTokenPaths[r.URL.Path] {
+			credential = r.URL.Query().Get("access_token")
+		}
+
+		if credential == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-vee"`)
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, "Missing bearer token or X-API-Key header")
+			return
+		}
+
+		token, ok := a.lookup(credential)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-vee", error="invalid_token"`)
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+
+		scope := requiredScope(r)
+		if !token.HasScope(scope) {
+			logging.FromContext(r.Context()).Warn("Request denied: insufficient scope", "identity", token.Name, "scope", scope)
+			sendErrorResponse(w, "Forbidden", http.StatusForbidden, "Credential does not grant the "+scope+" scope")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identityContextKey{}, token.Name)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/EternityX/go-vee/internal/apidocs"
+)
+
+// Serves the OpenAPI document of the API
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(apidocs.OpenAPI)
+}
+
+// Serves a browsable page of the OpenAPI document
+func HandleDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(apidocs.Page)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// HTTP statuses of the error codes of service.DescribeError
+var serviceErrorStatus = map[string]int{
+	"invalid_transport":      http.StatusBadRequest,
+	"invalid_value":          http.StatusBadRequest,
+	"missing_api_key":        http.StatusUnauthorized,
+	"govee_unauthorized":     http.StatusUnauthorized,
+	"rate_limited":           http.StatusTooManyRequests,
+	"device_offline":         http.StatusGatewayTimeout,
+	"lan_timeout":            http.StatusGatewayTimeout,
+	"device_not_found":       http.StatusNotFound,
+	"unsupported_capability": http.StatusUnprocessableEntity,
+	"circuit_open":           http.StatusServiceUnavailable,
+	"upstream_unavailable":   http.StatusBadGateway,
+	"lan_control_failed":     http.StatusBadGateway,
+}
+
+// Returns the errorCode of a plain HTTP error, such as "method_not_allowed"
+func defaultErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+func writeErrorResponse(w http.ResponseWriter, response ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Sends the status, errorCode and description matching a service error and logs the error
+// with the request ID. The description comes from service.DescribeError, so nothing from a
+// Govee response reaches the client. Anything else is a 500 with the generic description.
+func sendServiceError(w http.ResponseWriter, r *http.Request, err error, description string) {
+	logger := logging.FromContext(r.Context())
+
+	code, message := service.DescribeError(err)
+	status, known := serviceErrorStatus[code]
+	if !known {
+		logger.Error(description, "error", err)
+		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, description)
+		return
+	}
+
+	logger.Warn(description, "error_code", code, "error", err)
+
+	var apiErr *service.APIError
+	if status == http.StatusTooManyRequests && errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(apiErr.RetryAfter.Seconds())))
+	}
+
+	// Titles follow the sentence case used by the other error responses
+	title := strings.ToLower(http.StatusText(status))
+
+	writeErrorResponse(w, ErrorResponse{
+		Error:       strings.ToUpper(title[:1]) + title[1:],
+		Description: message,
+		Code:        status,
+		ErrorCode:   code,
+	})
+}
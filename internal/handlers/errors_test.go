@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+func TestSendServiceError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		status      int
+		errorCode   string
+		description string
+	}{
+		{"unknown device", &service.APIError{Status: 400, Message: "devices not exist, key=secret"}, 404, "device_not_found", "Device not found"},
+		{"rejected value", &service.APIError{Status: 400, Message: "parameter value out of range"}, 400, "invalid_value", "The Govee API rejected a value of the request"},
+		{"own validation", fmt.Errorf("%w: capability type and instance are required", service.ErrInvalidValue), 400, "invalid_value", "invalid capability value: capability type and instance are required"},
+		{"wrapped upstream error", fmt.Errorf("cloud control: %w", &service.APIError{Status: 503, Message: "backend db-3 down"}), 502, "upstream_unavailable", "The Govee API is unavailable"},
+		{"unknown error", errors.New("disk full at /var/lib/go-vee"), 500, "internal_server_error", "Failed"},
+	}
+
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		sendServiceError(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil), tt.err, "Failed")
+
+		var response ErrorResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Errorf("%s: decoding the response: %v", tt.name, err)
+			continue
+		}
+
+		if recorder.Code != tt.status || response.ErrorCode != tt.errorCode || response.Description != tt.description {
+			t.Errorf("%s: got %d %s %q, want %d %s %q", tt.name, recorder.Code, response.ErrorCode, response.Description, tt.status, tt.errorCode, tt.description)
+		}
+		if strings.Contains(response.Description, "govee api") {
+			t.Errorf("%s: description %q echoes the Govee response", tt.name, response.Description)
+		}
+	}
+}
+
+func TestSendServiceErrorRetryAfter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	err := &service.APIError{Status: 429, Message: "too many requests", RetryAfter: 30 * time.Second}
+	sendServiceError(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil), err, "Failed")
+
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "30" {
+		t.Errorf("got %d with Retry-After %q, want 429 with 30", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+const streamKeepAlive = 15 * time.Second
+
+// Ends open event streams so the server can shut down without waiting for clients to disconnect
+func (h *GoveeHandler) CloseStreams() {
+	h.closeStreams.Do(func() {
+		close(h.streamsDone)
+	})
+}
+
+func splitFilter(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+
+	filter := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			filter[item] = true
+		}
+	}
+
+	return filter
+}
+
+// Streams events as Server-Sent Events, optionally filtered by the device and type query parameters
+func (h *GoveeHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Streaming is not supported by this connection")
+		return
+	}
+
+	deviceFilter := splitFilter(r.URL.Query().Get("device"))
+	typeFilter := splitFilter(r.URL.Query().Get("type"))
+
+	sub := h.service.Events().Subscribe(64)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	logger := logging.FromContext(r.Context())
+	logger.Debug("Event stream opened")
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Debug("Event stream closed by client")
+			return
+		case <-h.streamsDone:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			if !matchesFilter(event, deviceFilter, typeFilter) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Error("Error encoding event", "error", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func matchesFilter(event events.Event, devices map[string]bool, types map[string]bool) bool {
+	if devices != nil && !devices[event.Device] {
+		return false
+	}
+
+	if types != nil && !types[event.Type] {
+		return false
+	}
+
+	return true
+}
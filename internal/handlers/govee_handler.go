@@ -1,40 +1,47 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"io"
-	"log"
 	"net/http"
-	"strings"
-	"time"
+	"sync"
 
+	"github.com/EternityX/go-vee/internal/jobs"
+	"github.com/EternityX/go-vee/internal/logging"
 	"github.com/EternityX/go-vee/internal/service"
 	"github.com/EternityX/go-vee/internal/service/lan"
 )
 
 type GoveeHandler struct {
 	service *service.GoveeService
+	jobs    *jobs.Manager
+
+	streamsDone  chan struct{}
+	closeStreams sync.Once
 }
 
 type ErrorResponse struct {
 	Error       string `json:"error"`
 	Description string `json:"description,omitempty"`
 	Code        int    `json:"code"`
+	ErrorCode   string `json:"errorCode"`
 }
 
-func NewGoveeHandler(service *service.GoveeService) *GoveeHandler {
+func NewGoveeHandler(service *service.GoveeService, jobManager *jobs.Manager) *GoveeHandler {
 	return &GoveeHandler{
-		service: service,
+		service:     service,
+		jobs:        jobManager,
+		streamsDone: make(chan struct{}),
 	}
 }
 
 func sendErrorResponse(w http.ResponseWriter, message string, code int, description string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(ErrorResponse{
+	writeErrorResponse(w, ErrorResponse{
 		Error:       message,
 		Description: description,
 		Code:        code,
+		ErrorCode:   defaultErrorCode(code),
 	})
 }
 
@@ -46,14 +53,7 @@ func (h *GoveeHandler) HandleDevices(w http.ResponseWriter, r *http.Request) {
 
 	devices, err := h.service.GetDevices(r.Context())
 	if err != nil {
-		log.Printf("Error fetching devices: %v", err)
-
-		description := "Failed to fetch devices from Govee API"
-		if strings.Contains(err.Error(), "Govee API returned status") {
-			description = err.Error()
-		}
-
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, description)
+		sendServiceError(w, r, err, "Failed to fetch devices from Govee API")
 		return
 	}
 
@@ -67,7 +67,7 @@ func (h *GoveeHandler) HandleDevices(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		logging.FromContext(r.Context()).Error("Error encoding response", "error", err)
 		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
@@ -79,24 +79,29 @@ func (h *GoveeHandler) HandleControl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	async, err := asyncRequested(r)
+	if err != nil {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "async must be true or false")
+		return
+	}
+
 	// Parse the request body
 	var controlRequest struct {
 		SKU        string                    `json:"sku"`
 		Device     string                    `json:"device"`
 		Capability service.ControlCapability `json:"capability"`
+		Transport  string                    `json:"transport"`
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		logging.FromContext(r.Context()).Warn("Error reading request body", "error", err)
 		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 
-	log.Printf("Received control request: %s", string(body))
-
 	if err := json.Unmarshal(body, &controlRequest); err != nil {
-		log.Printf("Error decoding control request: %v", err)
+		logging.FromContext(r.Context()).Warn("Error decoding control request", "error", err)
 		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
 		return
 	}
@@ -111,44 +116,95 @@ func (h *GoveeHandler) HandleControl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Call the service to control the device
-	err = h.service.ControlDevice(r.Context(), controlRequest.SKU, controlRequest.Device, controlRequest.Capability)
-	if err != nil {
-		log.Printf("Error controlling device: %v", err)
-		description := "Failed to control device"
-		if strings.Contains(err.Error(), "govee api") {
-			description = err.Error()
+	logging.FromContext(r.Context()).Debug("Received control request",
+		"sku", controlRequest.SKU,
+		"device", controlRequest.Device,
+		"capability", controlRequest.Capability.Type,
+		"instance", controlRequest.Capability.Instance,
+	)
+
+	if async {
+		// Reject what would fail anyway before queueing, so clients get a 400 right away
+		if err := validateControl(controlRequest.Capability, controlRequest.Transport); err != nil {
+			sendServiceError(w, r, err, "Invalid control request")
+			return
 		}
 
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, description)
+		sku, device, capability, transport := controlRequest.SKU, controlRequest.Device, controlRequest.Capability, controlRequest.Transport
+		submitJob(w, r, h.jobs, "control", func(ctx context.Context) []jobs.Result {
+			jobResult := jobs.Result{SKU: sku, Device: device}
+
+			result, err := h.service.ControlDevice(ctx, sku, device, capability, transport)
+			if err != nil {
+				logging.FromContext(ctx).Warn("Control job failed", "device", device, "error", err)
+				jobResult.ErrorCode, jobResult.Error = service.DescribeError(err)
+				if ctx.Err() != nil {
+					jobResult.ErrorCode, jobResult.Error = "cancelled", "The job was cancelled"
+				}
+				return []jobs.Result{jobResult}
+			}
+
+			jobResult.Success = true
+			jobResult.Transport = result.Transport
+			jobResult.LANError = result.LANError
+			jobResult.LatencyMs = float64(result.Latency.Microseconds()) / 1000
+			return []jobs.Result{jobResult}
+		})
+		return
+	}
+
+	// Call the service to control the device
+	result, err := h.service.ControlDevice(r.Context(), controlRequest.SKU, controlRequest.Device, controlRequest.Capability, controlRequest.Transport)
+	if err != nil {
+		sendServiceError(w, r, err, "Failed to control device")
 		return
 	}
 
 	response := struct {
-		Success bool   `json:"success"`
-		Message string `json:"message"`
+		Success   bool    `json:"success"`
+		Message   string  `json:"message"`
+		Transport string  `json:"transport"`
+		LANError  string  `json:"lanError,omitempty"`
+		LatencyMs float64 `json:"latencyMs"`
+		Coalesced int     `json:"coalesced,omitempty"`
 	}{
-		Success: true,
-		Message: "Device control command sent successfully",
+		Success:   true,
+		Message:   "Device control command sent successfully",
+		Transport: result.Transport,
+		LANError:  result.LANError,
+		LatencyMs: float64(result.Latency.Microseconds()) / 1000,
+		Coalesced: result.Coalesced,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		logging.FromContext(r.Context()).Error("Error encoding response", "error", err)
 		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
 }
 
+// Checks the parts of a control request that do not depend on the device
+func validateControl(capability service.ControlCapability, transport string) error {
+	switch transport {
+	case "", service.TransportAuto, service.TransportLAN, service.TransportCloud:
+	default:
+		return service.ErrInvalidTransport
+	}
+
+	_, err := service.NormalizeCapability(capability)
+	return err
+}
+
 func (h *GoveeHandler) HandleLANDevices(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
 		return
 	}
 
-	devices, err := lan.DiscoverDevices(2 * time.Second)
+	devices, err := h.service.DiscoverLANDevices(r.Context())
 	if err != nil {
-		log.Printf("Error discovering LAN devices: %v", err)
+		logging.FromContext(r.Context()).Error("Error discovering LAN devices", "error", err)
 		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Failed to discover LAN devices")
 		return
 	}
@@ -163,7 +219,7 @@ func (h *GoveeHandler) HandleLANDevices(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		logging.FromContext(r.Context()).Error("Error encoding response", "error", err)
 		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// Default and maximum number of history entries returned by one query
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+type HistoryHandler struct {
+	store *history.Store
+}
+
+func NewHistoryHandler(store *history.Store) *HistoryHandler {
+	return &HistoryHandler{store: store}
+}
+
+// Queries the recorded control commands and state changes, newest first
+func (h *HistoryHandler) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := history.Filter{
+		Kind:  query.Get("kind"),
+		Limit: defaultHistoryLimit,
+	}
+
+	if filter.Kind != "" && filter.Kind != history.KindControl && filter.Kind != history.KindState {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "kind must be control or state")
+		return
+	}
+
+	if devices := query.Get("device"); devices != "" {
+		filter.Devices = strings.Split(devices, ",")
+	}
+
+	var err error
+	if filter.Since, err = parseSince(query.Get("since")); err != nil {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "until must be an RFC 3339 time")
+			return
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxHistoryLimit {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxHistoryLimit))
+			return
+		}
+	}
+
+	entries, err := h.store.Query(filter)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error querying history", "error", err)
+		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Failed to read the history")
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool            `json:"success"`
+		Data    []history.Entry `json:"data"`
+	}{
+		Success: true,
+		Data:    entries,
+	})
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/hue"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+type HueHandler struct {
+	bridge *hue.Bridge
+}
+
+func NewHueHandler(bridge *hue.Bridge) *HueHandler {
+	return &HueHandler{
+		bridge: bridge,
+	}
+}
+
+// Opens the pairing window of the emulated Hue bridge, like pressing its link button
+func (h *HueHandler) HandleLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only POST method is allowed for this endpoint")
+		return
+	}
+
+	until := h.bridge.OpenPairing()
+	logging.FromContext(r.Context()).Info("Hue pairing window opened", "until", until, "identity", IdentityFromContext(r.Context()))
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool      `json:"success"`
+		Message string    `json:"message"`
+		Until   time.Time `json:"until"`
+	}{
+		Success: true,
+		Message: "Hue pairing window opened",
+		Until:   until,
+	})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// A device of the inventory with its last observed state, if any
+type inventoryEntry struct {
+	service.InventoryDevice
+	State *service.DeviceState `json:"state,omitempty"`
+}
+
+// Lists the devices of the cloud accounts and the LAN, merged, with their last observed state
+func (h *GoveeHandler) HandleInventory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	inventory := h.service.Inventory(r.Context())
+
+	entries := make([]inventoryEntry, len(inventory))
+	for i, device := range inventory {
+		entries[i].InventoryDevice = device
+		if state, ok := h.service.CachedState(device.Device); ok {
+			entries[i].State = &state
+		}
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool             `json:"success"`
+		Data    []inventoryEntry `json:"data"`
+	}{
+		Success: true,
+		Data:    entries,
+	})
+}
+
+// Queries the current state of a device, over the LAN when possible and otherwise through the
+// cloud API
+func (h *GoveeHandler) HandleDeviceState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	sku := r.URL.Query().Get("sku")
+	device := r.URL.Query().Get("device")
+	if sku == "" || device == "" {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Missing required query parameters: sku and device")
+		return
+	}
+
+	state, transport, err := h.service.QueryState(r.Context(), sku, device)
+	if err != nil {
+		sendServiceError(w, r, err, "Failed to query device state")
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success   bool                `json:"success"`
+		Transport string              `json:"transport"`
+		Data      service.DeviceState `json:"data"`
+	}{
+		Success:   true,
+		Transport: transport,
+		Data:      state,
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/EternityX/go-vee/internal/jobs"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+type JobHandler struct {
+	manager *jobs.Manager
+}
+
+func NewJobHandler(manager *jobs.Manager) *JobHandler {
+	return &JobHandler{
+		manager: manager,
+	}
+}
+
+// Reports whether the client asked for the request to run as a background job with ?async=true
+func asyncRequested(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("async")
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// Returns who a job belongs to: the access token and, when the client sent its own Govee API
+// key, a hash of the key. Jobs are only shown to the caller that submitted them, so results of
+// one token or Govee account never reach another. Without tokens and client keys every caller
+// is the same owner.
+func jobOwner(r *http.Request) string {
+	owner := "token:" + IdentityFromContext(r.Context())
+	if apiKey := service.APIKeyFromContext(r.Context()); apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		owner += " key:" + hex.EncodeToString(sum[:])
+	}
+
+	return owner
+}
+
+// Queues a job and answers 202 with the job and its status URL
+func submitJob(w http.ResponseWriter, r *http.Request, manager *jobs.Manager, kind string, run jobs.Func) {
+	job, err := manager.Submit(r.Context(), jobOwner(r), kind, run)
+	if err != nil {
+		sendErrorResponse(w, "Service unavailable", http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Queued job", "job", job.ID, "kind", kind, "identity", IdentityFromContext(r.Context()))
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	sendJSON(w, r, http.StatusAccepted, struct {
+		Success bool     `json:"success"`
+		Data    jobs.Job `json:"data"`
+	}{
+		Success: true,
+		Data:    job,
+	})
+}
+
+// Lists the kept jobs of the caller, newest first
+func (h *JobHandler) HandleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool       `json:"success"`
+		Data    []jobs.Job `json:"data"`
+	}{
+		Success: true,
+		Data:    h.manager.List(jobOwner(r)),
+	})
+}
+
+// Returns the status of a job, with per-device results once it is done
+func (h *JobHandler) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	job, err := h.manager.Get(r.PathValue("id"), jobOwner(r))
+	if err != nil {
+		sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool     `json:"success"`
+		Data    jobs.Job `json:"data"`
+	}{
+		Success: true,
+		Data:    job,
+	})
+}
+
+// Cancels a queued or running job
+func (h *JobHandler) HandleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only POST method is allowed for this endpoint")
+		return
+	}
+
+	job, err := h.manager.Cancel(r.PathValue("id"), jobOwner(r))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		sendErrorResponse(w, "Conflict", http.StatusConflict, err.Error())
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Cancelled job", "job", job.ID, "identity", IdentityFromContext(r.Context()))
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool     `json:"success"`
+		Data    jobs.Job `json:"data"`
+	}{
+		Success: true,
+		Data:    job,
+	})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    string
+	}{
+		{"no origins configured", nil, "https://example.com", ""},
+		{"empty entries", []string{"", " "}, "https://example.com", ""},
+		{"allowed origin", []string{"https://example.com/"}, "https://example.com", "https://example.com"},
+		{"other origin", []string{"https://example.com"}, "https://evil.example", ""},
+		{"wildcard", []string{"*"}, "https://evil.example", "*"},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, tt := range tests {
+		handler := CORSMiddleware(tt.origins)(next)
+
+		for _, method := range []string{http.MethodGet, http.MethodOptions} {
+			req := httptest.NewRequest(method, "/api/v1/devices", nil)
+			req.Header.Set("Origin", tt.origin)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Errorf("%s: %s Access-Control-Allow-Origin %q, want %q", tt.name, method, got, tt.want)
+			}
+
+			// Only preflights from allowed origins are answered here, the rest reach the handler
+			preflight := method == http.MethodOptions && tt.want != ""
+			if got := recorder.Code == http.StatusOK; got != preflight {
+				t.Errorf("%s: %s answered %d", tt.name, method, recorder.Code)
+			}
+			if tt.want == "" && recorder.Header().Get("Access-Control-Allow-Methods") != "" {
+				t.Errorf("%s: %s sent CORS headers for a disallowed origin", tt.name, method)
+			}
+		}
+	}
+}
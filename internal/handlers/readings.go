@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// A single value of a metric, used for charting
+type readingPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Parses the since query parameter, either an RFC 3339 time or a duration back from now such as 24h
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be an RFC 3339 time or a duration such as 24h")
+	}
+
+	return t, nil
+}
+
+// Lists the latest sensor reading of every device
+func (h *GoveeHandler) HandleReadings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool              `json:"success"`
+		Data    []service.Reading `json:"data"`
+	}{
+		Success: true,
+		Data:    h.service.LatestReadings(),
+	})
+}
+
+// Returns the reading history of a device. With a metric query parameter only the values of
+// that metric are returned, as time and value pairs.
+func (h *GoveeHandler) HandleReadingHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	query := r.URL.Query()
+
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+
+	metric := query.Get("metric")
+	if metric != "" && !service.ValidMetric(metric) {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, fmt.Sprintf("Unknown metric %q", metric))
+		return
+	}
+
+	readings := h.service.ReadingHistory(r.PathValue("device"), since)
+	if limit > 0 && len(readings) > limit {
+		readings = readings[len(readings)-limit:]
+	}
+
+	if metric == "" {
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool              `json:"success"`
+			Data    []service.Reading `json:"data"`
+		}{
+			Success: true,
+			Data:    readings,
+		})
+		return
+	}
+
+	points := []readingPoint{}
+	for _, reading := range readings {
+		if value, ok := reading.Metric(metric); ok {
+			points = append(points, readingPoint{Time: reading.Time, Value: value})
+		}
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool           `json:"success"`
+		Metric  string         `json:"metric"`
+		Data    []readingPoint `json:"data"`
+	}{
+		Success: true,
+		Metric:  metric,
+		Data:    points,
+	})
+}
+
+// Reads the current sensor values of a device through the cloud API and adds them to its history
+func (h *GoveeHandler) HandleRefreshReadings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only POST method is allowed for this endpoint")
+		return
+	}
+
+	deviceID := r.PathValue("device")
+
+	sku := r.URL.Query().Get("sku")
+	if sku == "" {
+		if device, ok := h.service.CloudDevice(r.Context(), deviceID); ok {
+			sku = device.SKU
+		}
+	}
+
+	if sku == "" {
+		sendServiceError(w, r, fmt.Errorf("%w in the cloud device list: %s", service.ErrDeviceNotFound, deviceID), "Failed to read device sensors")
+		return
+	}
+
+	reading, err := h.service.QueryReadings(r.Context(), sku, deviceID)
+	if err != nil {
+		sendServiceError(w, r, err, "Failed to read device sensors")
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool            `json:"success"`
+		Data    service.Reading `json:"data"`
+	}{
+		Success: true,
+		Data:    reading,
+	})
+}
+
+// Lists the configured alert rules and whether they are firing for each device
+func (h *GoveeHandler) HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	rules, states := h.service.Alerts()
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool                 `json:"success"`
+		Rules   []service.AlertRule  `json:"rules"`
+		Data    []service.AlertState `json:"data"`
+	}{
+		Success: true,
+		Rules:   rules,
+		Data:    states,
+	})
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/jobs"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/scenes"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// Longest transition accepted when activating a scene
+const maxSceneTransition = 10 * time.Minute
+
+type SceneHandler struct {
+	store   *scenes.Store
+	service *service.GoveeService
+	jobs    *jobs.Manager
+}
+
+func NewSceneHandler(store *scenes.Store, service *service.GoveeService, jobManager *jobs.Manager) *SceneHandler {
+	return &SceneHandler{
+		store:   store,
+		service: service,
+		jobs:    jobManager,
+	}
+}
+
+func sendScene(w http.ResponseWriter, r *http.Request, code int, scene scenes.Scene) {
+	sendJSON(w, r, code, struct {
+		Success bool         `json:"success"`
+		Data    scenes.Scene `json:"data"`
+	}{
+		Success: true,
+		Data:    scene,
+	})
+}
+
+// Lists scenes (GET) or creates one (POST)
+func (h *SceneHandler) HandleScenes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool           `json:"success"`
+			Data    []scenes.Scene `json:"data"`
+		}{
+			Success: true,
+			Data:    h.store.List(),
+		})
+	case http.MethodPost:
+		var scene scenes.Scene
+		if err := json.NewDecoder(r.Body).Decode(&scene); err != nil {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
+			return
+		}
+
+		created, err := h.store.Put(scene, false)
+		if err != nil {
+			h.sendStoreError(w, r, err)
+			return
+		}
+
+		logging.FromContext(r.Context()).Info("Created scene", "scene", created.Name, "identity", IdentityFromContext(r.Context()))
+		sendScene(w, r, http.StatusCreated, created)
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET and POST methods are allowed for this endpoint")
+	}
+}
+
+// Returns (GET), replaces (PUT) or deletes (DELETE) a single scene
+func (h *SceneHandler) HandleScene(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		scene, err := h.store.Get(name)
+		if err != nil {
+			h.sendStoreError(w, r, err)
+			return
+		}
+
+		sendScene(w, r, http.StatusOK, scene)
+	case http.MethodPut:
+		var scene scenes.Scene
+		if err := json.NewDecoder(r.Body).Decode(&scene); err != nil {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
+			return
+		}
+
+		// The name in the path wins over the one in the body
+		scene.Name = name
+
+		saved, err := h.store.Put(scene, true)
+		if err != nil {
+			h.sendStoreError(w, r, err)
+			return
+		}
+
+		logging.FromContext(r.Context()).Info("Saved scene", "scene", saved.Name, "identity", IdentityFromContext(r.Context()))
+		sendScene(w, r, http.StatusOK, saved)
+	case http.MethodDelete:
+		if err := h.store.Delete(name); err != nil {
+			h.sendStoreError(w, r, err)
+			return
+		}
+
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}{
+			Success: true,
+			Message: "Scene deleted",
+		})
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET, PUT and DELETE methods are allowed for this endpoint")
+	}
+}
+
+// Activates a scene on all of its devices and reports the outcome per device
+func (h *SceneHandler) HandleActivate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only POST method is allowed for this endpoint")
+		return
+	}
+
+	async, err := asyncRequested(r)
+	if err != nil {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "async must be true or false")
+		return
+	}
+
+	scene, err := h.store.Get(r.PathValue("name"))
+	if err != nil {
+		h.sendStoreError(w, r, err)
+		return
+	}
+
+	// The body is optional
+	var request struct {
+		Transport    string `json:"transport"`
+		TransitionMs int    `json:"transitionMs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
+		return
+	}
+
+	switch request.Transport {
+	case "", service.TransportAuto, service.TransportLAN, service.TransportCloud:
+	default:
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, service.ErrInvalidTransport.Error())
+		return
+	}
+
+	transition := time.Duration(request.TransitionMs) * time.Millisecond
+	if transition < 0 || transition > maxSceneTransition {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "transitionMs must be between 0 and 600000")
+		return
+	}
+
+	if async {
+		submitJob(w, r, h.jobs, "scene", func(ctx context.Context) []jobs.Result {
+			results := scenes.Activate(ctx, h.service, scene, request.Transport, transition)
+
+			jobResults := make([]jobs.Result, len(results))
+			for i, result := range results {
+				jobResults[i] = jobs.Result{
+					SKU:       result.SKU,
+					Device:    result.Device,
+					Success:   result.Success,
+					Transport: result.Transport,
+					Error:     result.Error,
+					ErrorCode: result.ErrorCode,
+				}
+			}
+			return jobResults
+		})
+		return
+	}
+
+	results := scenes.Activate(r.Context(), h.service, scene, request.Transport, transition)
+
+	success := true
+	for _, result := range results {
+		success = success && result.Success
+	}
+
+	logging.FromContext(r.Context()).Info("Activated scene", "scene", scene.Name, "success", success, "identity", IdentityFromContext(r.Context()))
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool            `json:"success"`
+		Data    []scenes.Result `json:"data"`
+	}{
+		Success: success,
+		Data:    results,
+	})
+}
+
+func (h *SceneHandler) sendStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, scenes.ErrNotFound):
+		sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+	case errors.Is(err, scenes.ErrExists):
+		sendErrorResponse(w, "Conflict", http.StatusConflict, err.Error())
+	case errors.Is(err, scenes.ErrInvalid):
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("Error saving scenes", "error", err)
+		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, "Failed to save scenes")
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// Lists snapshots (GET) or captures a new one (POST)
+func (h *GoveeHandler) HandleSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool               `json:"success"`
+			Data    []service.Snapshot `json:"data"`
+		}{
+			Success: true,
+			Data:    h.service.Snapshots(),
+		})
+	case http.MethodPost:
+		var request struct {
+			Name    string              `json:"name"`
+			Devices []service.DeviceRef `json:"devices"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
+			return
+		}
+
+		if len(request.Devices) == 0 {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Missing required field: devices")
+			return
+		}
+
+		for _, device := range request.Devices {
+			if device.SKU == "" || device.Device == "" {
+				sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Every device needs sku and device")
+				return
+			}
+		}
+
+		snapshot, err := h.service.TakeSnapshot(r.Context(), request.Name, request.Devices)
+		if err != nil {
+			sendServiceError(w, r, err, "Failed to read the state of the devices")
+			return
+		}
+
+		sendJSON(w, r, http.StatusCreated, struct {
+			Success bool             `json:"success"`
+			Data    service.Snapshot `json:"data"`
+		}{
+			Success: true,
+			Data:    snapshot,
+		})
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET and POST methods are allowed for this endpoint")
+	}
+}
+
+// Returns (GET) or deletes (DELETE) a single snapshot
+func (h *GoveeHandler) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		snapshot, err := h.service.Snapshot(id)
+		if err != nil {
+			sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+			return
+		}
+
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool             `json:"success"`
+			Data    service.Snapshot `json:"data"`
+		}{
+			Success: true,
+			Data:    snapshot,
+		})
+	case http.MethodDelete:
+		if err := h.service.DeleteSnapshot(id); err != nil {
+			sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+			return
+		}
+
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}{
+			Success: true,
+			Message: "Snapshot deleted",
+		})
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET and DELETE methods are allowed for this endpoint")
+	}
+}
+
+// Replays a snapshot and reports the outcome per device
+func (h *GoveeHandler) HandleRestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only POST method is allowed for this endpoint")
+		return
+	}
+
+	// The body is optional
+	var request struct {
+		Transport string `json:"transport"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
+		return
+	}
+
+	switch request.Transport {
+	case "", service.TransportAuto, service.TransportLAN, service.TransportCloud:
+	default:
+		sendErrorResponse(w, "Bad request", http.StatusBadRequest, service.ErrInvalidTransport.Error())
+		return
+	}
+
+	results, err := h.service.RestoreSnapshot(r.Context(), r.PathValue("id"), request.Transport)
+	if err != nil {
+		sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+		return
+	}
+
+	success := true
+	for _, result := range results {
+		success = success && result.Success
+	}
+
+	logging.FromContext(r.Context()).Info("Restored snapshot", "snapshot", r.PathValue("id"), "success", success, "identity", IdentityFromContext(r.Context()))
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool                    `json:"success"`
+		Data    []service.RestoreResult `json:"data"`
+	}{
+		Success: success,
+		Data:    results,
+	})
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/webhooks"
+)
+
+type WebhookHandler struct {
+	manager *webhooks.Manager
+}
+
+func NewWebhookHandler(manager *webhooks.Manager) *WebhookHandler {
+	return &WebhookHandler{
+		manager: manager,
+	}
+}
+
+func sendJSON(w http.ResponseWriter, r *http.Request, code int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logging.FromContext(r.Context()).Error("Error encoding response", "error", err)
+	}
+}
+
+// Lists webhook targets (GET) or creates one (POST)
+func (h *WebhookHandler) HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool              `json:"success"`
+			Data    []webhooks.Target `json:"data"`
+		}{
+			Success: true,
+			Data:    h.manager.List(),
+		})
+	case http.MethodPost:
+		var target webhooks.Target
+		if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, "Invalid request body format")
+			return
+		}
+
+		// IDs and sources are assigned by the server
+		target.ID = ""
+		target.Source = webhooks.SourceAPI
+
+		created, err := h.manager.Add(target)
+		if err != nil {
+			sendErrorResponse(w, "Bad request", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		logging.FromContext(r.Context()).Info("Created webhook", "webhook", created.ID, "identity", IdentityFromContext(r.Context()))
+
+		// The secret is only returned once, when the webhook is created
+		sendJSON(w, r, http.StatusCreated, struct {
+			Success bool            `json:"success"`
+			Data    webhooks.Target `json:"data"`
+		}{
+			Success: true,
+			Data:    created,
+		})
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET and POST methods are allowed for this endpoint")
+	}
+}
+
+// Returns (GET) or deletes (DELETE) a single webhook target
+func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		target, err := h.manager.Get(id)
+		if err != nil {
+			sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+			return
+		}
+
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool            `json:"success"`
+			Data    webhooks.Target `json:"data"`
+		}{
+			Success: true,
+			Data:    target,
+		})
+	case http.MethodDelete:
+		err := h.manager.Remove(id)
+		switch {
+		case errors.Is(err, webhooks.ErrNotFound):
+			sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, webhooks.ErrReadOnly):
+			sendErrorResponse(w, "Conflict", http.StatusConflict, "Webhooks defined in the config file cannot be deleted through the API")
+			return
+		}
+
+		sendJSON(w, r, http.StatusOK, struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}{
+			Success: true,
+			Message: "Webhook deleted",
+		})
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET and DELETE methods are allowed for this endpoint")
+	}
+}
+
+// Returns the delivery log of a webhook target, newest first
+func (h *WebhookHandler) HandleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, "Only GET method is allowed for this endpoint")
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := h.manager.Get(id); err != nil {
+		sendErrorResponse(w, "Not found", http.StatusNotFound, err.Error())
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, struct {
+		Success bool                `json:"success"`
+		Data    []webhooks.Delivery `json:"data"`
+	}{
+		Success: true,
+		Data:    h.manager.Deliveries(id),
+	})
+}
@@ -0,0 +1,402 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Returned by Append after the store was closed
+var ErrClosed = errors.New("history closed")
+
+// Kinds of history entries
+const (
+	KindControl = "control"
+	KindState   = "state"
+)
+
+// History files are named after the UTC day they cover, e.g. history-2024-05-01.jsonl
+const (
+	filePrefix = "history-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// History files are read backwards in blocks of this size. Longer lines are rejected.
+const (
+	readBlockSize = 64 * 1024
+	maxLineSize   = 1024 * 1024
+)
+
+// Who issued a control command. It travels in the context of the command.
+type Actor struct {
+	Origin   string // api, hue or mqtt
+	SourceIP string
+	Identity string // name of the access token or Hue user
+}
+
+type actorContextKey struct{}
+
+// Returns a copy of ctx that carries the actor of the commands sent with it
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// Returns the actor carried by ctx, or the zero Actor
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorContextKey{}).(Actor)
+	return actor
+}
+
+// The capability of a control command as it was requested
+type Capability struct {
+	Type     string      `json:"type"`
+	Instance string      `json:"instance"`
+	Value    interface{} `json:"value"`
+}
+
+// A control command or an observed state change
+type Entry struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	SKU    string    `json:"sku,omitempty"`
+	Device string    `json:"device"`
+
+	// Control commands
+	Origin             string      `json:"origin,omitempty"`
+	SourceIP           string      `json:"sourceIp,omitempty"`
+	Identity           string      `json:"identity,omitempty"`
+	Capability         *Capability `json:"capability,omitempty"`
+	RequestedTransport string      `json:"requestedTransport,omitempty"`
+	Transport          string      `json:"transport,omitempty"`
+	Success            *bool       `json:"success,omitempty"`
+	Error              string      `json:"error,omitempty"`
+	LANError           string      `json:"lanError,omitempty"`
+	LatencyMs          float64     `json:"latencyMs,omitempty"`
+	Coalesced          int         `json:"coalesced,omitempty"`
+
+	// State changes: where the state was observed, and the previous and current state
+	Source string          `json:"source,omitempty"`
+	State  json.RawMessage `json:"state,omitempty"`
+}
+
+// Selects history entries. Zero fields match everything.
+type Filter struct {
+	Devices []string
+	Kind    string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+func (f Filter) matches(entry Entry) bool {
+	if f.Kind != "" && entry.Kind != f.Kind {
+		return false
+	}
+
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+
+	if len(f.Devices) == 0 {
+		return true
+	}
+
+	for _, device := range f.Devices {
+		if device == entry.Device {
+			return true
+		}
+	}
+
+	return false
+}
+
+// An append-only log of control commands and state changes, kept as JSON lines in one file
+// per UTC day. Files older than the retention period are deleted.
+type Store struct {
+	dir       string
+	retention time.Duration
+
+	mu     sync.Mutex
+	file   *os.File
+	day    string
+	closed bool
+}
+
+// Opens the history in dir, creating the directory when needed. Files are deleted a whole
+// day at a time, so the retention must be a whole number of days; 0 keeps entries forever.
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if retention < 0 || retention%(24*time.Hour) != 0 {
+		return nil, fmt.Errorf("history retention must be 0 or a whole number of days, got %s", retention)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+
+	return &Store{dir: dir, retention: retention}, nil
+}
+
+// Appends an entry to the file of its day, filling in the ID and time when missing
+func (s *Store) Append(entry Entry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding history entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	day := entry.Time.UTC().Format(dayLayout)
+	if s.file == nil || s.day != day {
+		if s.file != nil {
+			s.file.Close()
+			s.file = nil
+		}
+
+		file, err := os.OpenFile(s.path(day), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening history file: %w", err)
+		}
+		s.file, s.day = file, day
+	}
+
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("writing history entry: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) path(day string) string {
+	return filepath.Join(s.dir, filePrefix+day+fileSuffix)
+}
+
+// Returns the days that have a history file, oldest first
+func (s *Store) days() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for _, name := range names {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), filePrefix), fileSuffix)
+		if _, err := time.Parse(dayLayout, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+
+	return days, nil
+}
+
+// Returns the entries that match the filter, newest first. Files of days outside the time
+// range are not read, and reading stops as soon as the limit is reached.
+func (s *Store) Query(filter Filter) ([]Entry, error) {
+	days, err := s.days()
+	if err != nil {
+		return nil, fmt.Errorf("listing history files: %w", err)
+	}
+
+	entries := []Entry{}
+	for i := len(days) - 1; i >= 0; i-- {
+		start, _ := time.Parse(dayLayout, days[i])
+
+		// Days are visited newest first, so the remaining days all ended before Since
+		if !filter.Since.IsZero() && !start.Add(24*time.Hour).After(filter.Since) {
+			break
+		}
+		if !filter.Until.IsZero() && start.After(filter.Until) {
+			continue
+		}
+
+		limit := 0
+		if filter.Limit > 0 {
+			limit = filter.Limit - len(entries)
+		}
+
+		matched, err := s.readDay(days[i], filter, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, matched...)
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Reads the matching entries of one day, newest first, stopping after limit entries when
+// limit is positive. The file is read backwards one block at a time, so the latest entries
+// are found without reading the whole day. Lines that cannot be decoded, such as a line cut
+// short by a crash, are skipped.
+func (s *Store) readDay(day string, filter Filter, limit int) ([]Entry, error) {
+	file, err := os.Open(s.path(day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading history file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("reading history file: %w", err)
+	}
+
+	var entries []Entry
+
+	// Adds the entry of a line when it matches and reports whether the limit is reached
+	add := func(line []byte) bool {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return false
+		}
+
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return limit > 0 && len(entries) >= limit
+	}
+
+	// The start of a line whose beginning is in a block that is not read yet
+	var partial []byte
+
+	offset := info.Size()
+	for offset > 0 {
+		size := int64(readBlockSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		block := make([]byte, size, size+int64(len(partial)))
+		if _, err := file.ReadAt(block, offset); err != nil {
+			return nil, fmt.Errorf("reading history file: %w", err)
+		}
+		block = append(block, partial...)
+
+		// Every line but the first is complete; the first may continue in the previous block
+		lines := bytes.Split(block, []byte{'\n'})
+		for j := len(lines) - 1; j > 0; j-- {
+			if add(lines[j]) {
+				return entries, nil
+			}
+		}
+
+		partial = lines[0]
+		if len(partial) > maxLineSize {
+			return nil, fmt.Errorf("reading history file: line longer than %d bytes", maxLineSize)
+		}
+	}
+
+	add(partial)
+
+	return entries, nil
+}
+
+// Deletes the files of days that ended before the retention period and returns how many
+func (s *Store) Prune(now time.Time) (int, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+
+	days, err := s.days()
+	if err != nil {
+		return 0, fmt.Errorf("listing history files: %w", err)
+	}
+
+	cutoff := now.Add(-s.retention)
+	removed := 0
+	for _, day := range days {
+		start, _ := time.Parse(dayLayout, day)
+		if !start.Add(24 * time.Hour).Before(cutoff) {
+			break
+		}
+
+		if err := os.Remove(s.path(day)); err != nil {
+			return removed, fmt.Errorf("removing history file: %w", err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Prunes old files at startup and every hour until ctx is cancelled. Entries are appended by
+// the service as commands complete and state changes are observed.
+func (s *Store) Run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	prune := func() {
+		removed, err := s.Prune(time.Now())
+		if err != nil {
+			logger.Warn("Failed to prune history", "error", err)
+		} else if removed > 0 {
+			logger.Info("Pruned history", "files", removed)
+		}
+	}
+
+	prune()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prune()
+		}
+	}
+}
+
+// Closes the current history file. Close it once nothing appends any more; a later Append
+// fails with ErrClosed.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,177 @@
+package history
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var day1 = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// Appends n entries per day for days days, one minute apart, with IDs day-index
+func appendEntries(t *testing.T, store *Store, days, n int) {
+	t.Helper()
+
+	for d := 0; d < days; d++ {
+		for i := 0; i < n; i++ {
+			entry := Entry{
+				ID:     strconv.Itoa(d) + "-" + strconv.Itoa(i),
+				Time:   day1.AddDate(0, 0, d).Add(time.Duration(i) * time.Minute),
+				Kind:   KindControl,
+				Device: "AA",
+			}
+			if err := store.Append(entry); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+		}
+	}
+}
+
+// Returns the IDs of the entries separated by spaces
+func joinIDs(entries []Entry) string {
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestQuery(t *testing.T) {
+	store := openStore(t)
+	appendEntries(t, store, 3, 3)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"everything", Filter{}, "2-2 2-1 2-0 1-2 1-1 1-0 0-2 0-1 0-0"},
+		{"limit", Filter{Limit: 2}, "2-2 2-1"},
+		{"limit across days", Filter{Limit: 4}, "2-2 2-1 2-0 1-2"},
+		{"since", Filter{Since: day1.AddDate(0, 0, 1).Add(time.Minute)}, "2-2 2-1 2-0 1-2 1-1"},
+		{"since the start of a day", Filter{Since: day1.AddDate(0, 0, 2)}, "2-2 2-1 2-0"},
+		{"until", Filter{Until: day1.AddDate(0, 0, 1).Add(time.Minute)}, "1-1 1-0 0-2 0-1 0-0"},
+		{"since and until", Filter{Since: day1.Add(2 * time.Minute), Until: day1.AddDate(0, 0, 1)}, "1-0 0-2"},
+		{"other device", Filter{Devices: []string{"BB"}}, ""},
+		{"other kind", Filter{Kind: KindState}, ""},
+	}
+
+	for _, tt := range tests {
+		entries, err := store.Query(tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := joinIDs(entries); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueryReadsAcrossBlocks(t *testing.T) {
+	store := openStore(t)
+
+	// Entries are 70 to 80 bytes, so the day spans more than two blocks
+	n := 3 * readBlockSize / 100
+	for i := 0; i < n; i++ {
+		store.Append(Entry{ID: strconv.Itoa(i), Time: day1.Add(time.Duration(i) * time.Second), Kind: KindControl, Device: "AA"})
+	}
+
+	entries, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	if len(entries) != n {
+		t.Fatalf("got %d entries, want %d", len(entries), n)
+	}
+	for i, entry := range entries {
+		if want := strconv.Itoa(n - 1 - i); entry.ID != want {
+			t.Fatalf("entry %d has ID %s, want %s", i, entry.ID, want)
+		}
+	}
+}
+
+func TestQuerySkipsBrokenLines(t *testing.T) {
+	store := openStore(t)
+	appendEntries(t, store, 1, 2)
+	store.Close()
+
+	// A line cut short by a crash, followed by an entry appended after the restart
+	file, err := os.OpenFile(store.path("2025-01-01"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("opening the history file: %v", err)
+	}
+	file.WriteString(`{"id":"cut","time":"2025-01-01T00:05:00Z","ki` + "\n" + `{"id":"after","time":"2025-01-01T00:06:00Z","kind":"control","device":"AA"}` + "\n")
+	file.Close()
+
+	entries, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got := joinIDs(entries); got != "after 0-1 0-0" {
+		t.Errorf("got %s, want after 0-1 0-0", got)
+	}
+}
+
+func TestOpenRetention(t *testing.T) {
+	tests := []struct {
+		retention time.Duration
+		ok        bool
+	}{
+		{0, true},
+		{24 * time.Hour, true},
+		{30 * 24 * time.Hour, true},
+		{time.Hour, false},
+		{36 * time.Hour, false},
+		{-24 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		store, err := Open(t.TempDir(), tt.retention)
+		if (err == nil) != tt.ok {
+			t.Errorf("Open with retention %s: error %v, want ok %v", tt.retention, err, tt.ok)
+		}
+		if store != nil {
+			store.Close()
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store, err := Open(t.TempDir(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+	appendEntries(t, store, 3, 1)
+
+	// On the third day, the first day ended more than a day ago and the second did not
+	removed, err := store.Prune(day1.AddDate(0, 0, 2).Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d files, want 1", removed)
+	}
+
+	entries, _ := store.Query(Filter{})
+	if got := joinIDs(entries); got != "2-0 1-0" {
+		t.Errorf("kept %s, want 2-0 1-0", got)
+	}
+}
@@ -0,0 +1,444 @@
+// Package homeassistant publishes go-vee devices to an MQTT broker as Home Assistant
+// light entities using MQTT discovery, and turns their commands into control calls.
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/mqtt"
+	"github.com/EternityX/go-vee/internal/service"
+	"github.com/google/uuid"
+)
+
+const (
+	minKelvin = 2000
+	maxKelvin = 9000
+
+	// How long a command's state report may wait for the broker to acknowledge it
+	publishTimeout = 10 * time.Second
+)
+
+type Options struct {
+	Broker   string
+	Username string
+	Password string
+	ClientID string
+
+	// Prefix Home Assistant watches for discovery messages, usually "homeassistant"
+	DiscoveryPrefix string
+	// Prefix of the state, command and availability topics owned by go-vee
+	TopicPrefix string
+	// How often the device list is refreshed and discovery messages are republished
+	RefreshInterval time.Duration
+}
+
+type device struct {
+	SKU  string
+	ID   string
+	Name string
+}
+
+// JSON schema light command and state (https://www.home-assistant.io/integrations/light.mqtt/#json-schema)
+type lightColor struct {
+	R int `json:"r"`
+	G int `json:"g"`
+	B int `json:"b"`
+}
+
+type lightCommand struct {
+	State      string      `json:"state"`
+	Brightness *int        `json:"brightness,omitempty"`
+	Color      *lightColor `json:"color,omitempty"`
+	ColorTemp  *int        `json:"color_temp,omitempty"`
+}
+
+type lightState struct {
+	State      string      `json:"state"`
+	Brightness int         `json:"brightness,omitempty"`
+	ColorMode  string      `json:"color_mode,omitempty"`
+	Color      *lightColor `json:"color,omitempty"`
+	ColorTemp  int         `json:"color_temp,omitempty"`
+}
+
+type Bridge struct {
+	service *service.GoveeService
+	opts    Options
+
+	mu      sync.Mutex
+	client  *mqtt.Client
+	devices map[string]device // object ID -> device
+	states  map[string]lightState
+}
+
+func New(svc *service.GoveeService, opts Options) *Bridge {
+	if opts.DiscoveryPrefix == "" {
+		opts.DiscoveryPrefix = "homeassistant"
+	}
+	if opts.TopicPrefix == "" {
+		opts.TopicPrefix = "go-vee"
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "go-vee-" + uuid.New().String()
+	}
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = 5 * time.Minute
+	}
+
+	return &Bridge{
+		service: svc,
+		opts:    opts,
+		devices: make(map[string]device),
+		states:  make(map[string]lightState),
+	}
+}
+
+// Turns a device ID like AA:BB:CC:DD:EE:FF:00:11 into a topic and entity safe ID
+func objectID(deviceID string) string {
+	return strings.ToLower(strings.ReplaceAll(deviceID, ":", ""))
+}
+
+func (b *Bridge) availabilityTopic() string {
+	return b.opts.TopicPrefix + "/status"
+}
+
+func (b *Bridge) stateTopic(id string) string {
+	return b.opts.TopicPrefix + "/" + id + "/state"
+}
+
+func (b *Bridge) commandTopic(id string) string {
+	return b.opts.TopicPrefix + "/" + id + "/set"
+}
+
+func (b *Bridge) discoveryTopic(id string) string {
+	return b.opts.DiscoveryPrefix + "/light/govee_" + id + "/config"
+}
+
+// Connects to the broker and bridges devices until ctx is cancelled
+func (b *Bridge) Run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	opts := mqtt.Options{
+		Broker:   b.opts.Broker,
+		ClientID: b.opts.ClientID,
+		Username: b.opts.Username,
+		Password: b.opts.Password,
+		Will: &mqtt.Message{
+			Topic:   b.availabilityTopic(),
+			Payload: []byte("offline"),
+			QoS:     1,
+			Retain:  true,
+		},
+		OnMessage: func(msg mqtt.Message) {
+			b.handleMessage(ctx, msg)
+		},
+		BeforeDisconnect: func(ctx context.Context, c *mqtt.Client) {
+			c.Publish(ctx, mqtt.Message{Topic: b.availabilityTopic(), Payload: []byte("offline"), QoS: 1, Retain: true})
+		},
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.forwardEvents(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		b.refreshLoop(ctx)
+	}()
+
+	mqtt.Run(ctx, opts, func(ctx context.Context, c *mqtt.Client) error {
+		if err := c.Subscribe(ctx, b.opts.TopicPrefix+"/+/set", 1); err != nil {
+			return err
+		}
+
+		// Home Assistant announces itself on this topic after a restart and expects discovery to be repeated
+		if err := c.Subscribe(ctx, b.opts.DiscoveryPrefix+"/status", 1); err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		b.client = c
+		b.mu.Unlock()
+
+		if err := c.Publish(ctx, mqtt.Message{Topic: b.availabilityTopic(), Payload: []byte("online"), QoS: 1, Retain: true}); err != nil {
+			return err
+		}
+
+		go b.refresh(ctx)
+		return nil
+	})
+
+	logger.Info("Home Assistant bridge stopped")
+}
+
+func (b *Bridge) publish(ctx context.Context, topic string, payload interface{}, retain bool) error {
+	b.mu.Lock()
+	c := b.client
+	b.mu.Unlock()
+
+	if c == nil {
+		return mqtt.ErrClosed
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling payload for %s: %w", topic, err)
+	}
+
+	return c.Publish(ctx, mqtt.Message{Topic: topic, Payload: data, QoS: 1, Retain: retain})
+}
+
+func (b *Bridge) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.opts.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.refresh(ctx)
+		}
+	}
+}
+
+// Collects lights from the device inventory and publishes their discovery messages
+func (b *Bridge) refresh(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	published := 0
+
+	for _, d := range b.service.Inventory(ctx) {
+		if !d.IsLight() {
+			continue
+		}
+
+		if err := b.announce(ctx, objectID(d.Device), device{SKU: d.SKU, ID: d.Device, Name: d.Name}); err != nil {
+			logger.Warn("Failed to publish Home Assistant discovery", "device", d.Device, "error", err)
+			return
+		}
+		published++
+	}
+
+	logger.Debug("Published Home Assistant discovery", "devices", published)
+}
+
+func (b *Bridge) announce(ctx context.Context, id string, d device) error {
+	b.mu.Lock()
+	b.devices[id] = d
+	state, hasState := b.states[id]
+	b.mu.Unlock()
+
+	config := map[string]interface{}{
+		"name":                  nil,
+		"unique_id":             "govee_" + id,
+		"schema":                "json",
+		"command_topic":         b.commandTopic(id),
+		"state_topic":           b.stateTopic(id),
+		"availability_topic":    b.availabilityTopic(),
+		"brightness":            true,
+		"brightness_scale":      100,
+		"supported_color_modes": []string{"rgb", "color_temp"},
+		"color_temp_kelvin":     true,
+		"min_kelvin":            minKelvin,
+		"max_kelvin":            maxKelvin,
+		"device": map[string]interface{}{
+			"identifiers":  []string{"govee_" + id},
+			"name":         d.Name,
+			"model":        d.SKU,
+			"manufacturer": "Govee",
+		},
+	}
+
+	if err := b.publish(ctx, b.discoveryTopic(id), config, true); err != nil {
+		return err
+	}
+
+	if !hasState {
+		if observed, ok := b.service.CachedState(d.ID); ok {
+			state, hasState = stateFromService(observed), true
+		}
+	}
+
+	if hasState {
+		return b.publishState(ctx, id, state)
+	}
+
+	return nil
+}
+
+func stateFromService(st service.DeviceState) lightState {
+	state := lightState{State: "OFF"}
+	if st.On {
+		state.State = "ON"
+	}
+
+	state.Brightness = st.Brightness
+	if st.ColorTemperatureK > 0 {
+		state.ColorMode = "color_temp"
+		state.ColorTemp = st.ColorTemperatureK
+	} else {
+		state.ColorMode = "rgb"
+		state.Color = &lightColor{R: st.Color.R, G: st.Color.G, B: st.Color.B}
+	}
+
+	return state
+}
+
+func (b *Bridge) publishState(ctx context.Context, id string, state lightState) error {
+	b.mu.Lock()
+	b.states[id] = state
+	b.mu.Unlock()
+
+	return b.publish(ctx, b.stateTopic(id), state, true)
+}
+
+// Publishes observed state changes and announces devices as LAN discovery finds them
+func (b *Bridge) forwardEvents(ctx context.Context) {
+	sub := b.service.Events().Subscribe(64)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			id := objectID(event.Device)
+
+			switch event.Type {
+			case events.TypeDeviceStateChanged:
+				data, ok := event.Data.(service.StateChangeData)
+				if !ok {
+					continue
+				}
+				b.publishState(ctx, id, stateFromService(data.Current))
+			case events.TypeDeviceAppeared:
+				b.mu.Lock()
+				_, known := b.devices[id]
+				b.mu.Unlock()
+
+				if !known {
+					b.announce(ctx, id, device{SKU: event.SKU, ID: event.Device, Name: event.SKU + " " + event.Device})
+				}
+			}
+		}
+	}
+}
+
+func (b *Bridge) handleMessage(ctx context.Context, msg mqtt.Message) {
+	logger := logging.FromContext(ctx)
+
+	if msg.Topic == b.opts.DiscoveryPrefix+"/status" {
+		if string(msg.Payload) == "online" {
+			logger.Info("Home Assistant came online, republishing discovery")
+			go b.refresh(ctx)
+		}
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(msg.Topic, b.opts.TopicPrefix+"/"), "/set")
+
+	b.mu.Lock()
+	d, ok := b.devices[id]
+	state := b.states[id]
+	b.mu.Unlock()
+
+	if !ok {
+		logger.Warn("Ignoring Home Assistant command for unknown device", "topic", msg.Topic)
+		return
+	}
+
+	var cmd lightCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		logger.Warn("Ignoring malformed Home Assistant command", "topic", msg.Topic, "error", err)
+		return
+	}
+
+	ctx = logging.WithRequestID(ctx, uuid.New().String())
+	ctx = history.WithActor(ctx, history.Actor{Origin: "mqtt", Identity: "home-assistant"})
+	state, err := b.execute(ctx, d, cmd, state)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Home Assistant command failed", "device", d.ID, "error", err)
+	}
+
+	// Report what was applied, refreshed from the device when it is reachable over LAN
+	if observed, err := b.service.QueryLANState(ctx, d.ID); err == nil {
+		state = stateFromService(observed)
+	}
+	publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	if err := b.publishState(publishCtx, id, state); err != nil {
+		logging.FromContext(ctx).Warn("Error publishing Home Assistant state", "device", d.ID, "error", err)
+	}
+}
+
+// Translates a light command into control calls and returns the resulting optimistic state
+func (b *Bridge) execute(ctx context.Context, d device, cmd lightCommand, state lightState) (lightState, error) {
+	control := func(capType, instance string, value interface{}) error {
+		_, err := b.service.ControlDevice(ctx, d.SKU, d.ID, service.ControlCapability{
+			Type:     capType,
+			Instance: instance,
+			Value:    value,
+		}, service.TransportAuto)
+		return err
+	}
+
+	if cmd.State == "OFF" {
+		if err := control("devices.capabilities.on_off", "powerSwitch", float64(0)); err != nil {
+			return state, err
+		}
+		state.State = "OFF"
+		return state, nil
+	}
+
+	// The cached state can be stale, so an explicit ON is always sent
+	if cmd.State == "ON" {
+		if err := control("devices.capabilities.on_off", "powerSwitch", float64(1)); err != nil {
+			return state, err
+		}
+		state.State = "ON"
+	}
+
+	if cmd.Brightness != nil {
+		if err := control("devices.capabilities.range", "brightness", float64(*cmd.Brightness)); err != nil {
+			return state, err
+		}
+		state.Brightness = *cmd.Brightness
+	}
+
+	if cmd.Color != nil {
+		packed := cmd.Color.R<<16 | cmd.Color.G<<8 | cmd.Color.B
+		if err := control("devices.capabilities.color_setting", "colorRgb", float64(packed)); err != nil {
+			return state, err
+		}
+		state.ColorMode = "rgb"
+		state.Color = cmd.Color
+		state.ColorTemp = 0
+	}
+
+	if cmd.ColorTemp != nil {
+		kelvin := min(max(*cmd.ColorTemp, minKelvin), maxKelvin)
+		if err := control("devices.capabilities.color_setting", "colorTemperatureK", float64(kelvin)); err != nil {
+			return state, err
+		}
+		state.ColorMode = "color_temp"
+		state.ColorTemp = kelvin
+		state.Color = nil
+	}
+
+	return state, nil
+}
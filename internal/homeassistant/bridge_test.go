@@ -0,0 +1,507 @@
+package homeassistant
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/fakecloud"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// Fixture lights of the fake cloud
+const (
+	deskLamp    = "1F:80:C5:32:32:36:72:4E"
+	tvBacklight = "9A:52:D4:AD:FC:E8:7F:3B"
+	heaterPlug  = "3C:45:7A:52:B4:19:06:33"
+)
+
+// A message the bridge published to the broker
+type published struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// A local stand-in for an MQTT broker. It accepts the bridge's connection, acknowledges its
+// subscriptions and QoS 1 messages, records what it publishes and can send it messages.
+type broker struct {
+	t        *testing.T
+	listener net.Listener
+
+	mu         sync.Mutex
+	conn       net.Conn
+	subscribed []string
+	messages   []published
+}
+
+func startBroker(t *testing.T) *broker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	b := &broker{t: t, listener: listener}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+		b.mu.Lock()
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		b.mu.Unlock()
+		<-done
+	})
+
+	return b
+}
+
+func (b *broker) url() string {
+	return "mqtt://" + b.listener.Addr().String()
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	for {
+		kind, flags, body, err := readTestPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch kind {
+		case 1: // CONNECT
+			b.write(0x20, []byte{0, 0})
+		case 3: // PUBLISH
+			topic, rest := readTestString(body)
+			qos := flags >> 1 & 3
+			if qos > 0 {
+				b.write(0x40, rest[:2])
+				rest = rest[2:]
+			}
+
+			b.mu.Lock()
+			b.messages = append(b.messages, published{topic: topic, payload: rest, retain: flags&1 == 1})
+			b.mu.Unlock()
+		case 8: // SUBSCRIBE
+			filter, _ := readTestString(body[2:])
+			b.mu.Lock()
+			b.subscribed = append(b.subscribed, filter)
+			b.mu.Unlock()
+			b.write(0x90, append(append([]byte{}, body[:2]...), 1))
+		case 12: // PINGREQ
+			b.write(0xd0, nil)
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (b *broker) write(header byte, body []byte) {
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+
+	conn.Write(append(packet, body...))
+}
+
+// Sends a QoS 0 message to the bridge
+func (b *broker) send(topic string, payload string) {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(body, topic...)
+	b.write(0x30, append(body, payload...))
+}
+
+// Waits for a message on the topic and returns the latest one
+func (b *broker) waitFor(topic string) published {
+	b.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		for i := len(b.messages) - 1; i >= 0; i-- {
+			if b.messages[i].topic == topic {
+				msg := b.messages[i]
+				b.mu.Unlock()
+				return msg
+			}
+		}
+		b.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			b.t.Fatalf("nothing published on %s", topic)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Returns the payload of the latest message on the topic, or nil
+func (b *broker) latest(topic string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := len(b.messages) - 1; i >= 0; i-- {
+		if b.messages[i].topic == topic {
+			return b.messages[i].payload
+		}
+	}
+	return nil
+}
+
+// Waits until the latest message on the topic has a JSON payload equal to want
+func (b *broker) waitForJSON(topic string, want string) {
+	b.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := b.latest(topic)
+		if got != nil && jsonEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			b.t.Fatalf("%s is %s, want %s", topic, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readTestPacket(r *bufio.Reader) (kind byte, flags byte, body []byte, err error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body = make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func readTestString(body []byte) (string, []byte) {
+	length := int(binary.BigEndian.Uint16(body))
+	return string(body[2 : 2+length]), body[2+length:]
+}
+
+func jsonEqual(data []byte, want string) bool {
+	var got, expected interface{}
+	if json.Unmarshal(data, &got) != nil || json.Unmarshal([]byte(want), &expected) != nil {
+		return false
+	}
+	return fmt.Sprint(got) == fmt.Sprint(expected)
+}
+
+// The fake Govee cloud, recording the control commands it receives as "instance=value"
+type cloud struct {
+	mu       sync.Mutex
+	controls []string
+}
+
+func newService(t *testing.T) (*cloud, *service.GoveeService) {
+	t.Helper()
+
+	c := &cloud{}
+	fake := fakecloud.New(fakecloud.Options{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/router/api/v1/device/control" {
+			body, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			var request service.ControlRequest
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			decoder.Decode(&request)
+
+			c.mu.Lock()
+			c.controls = append(c.controls, fmt.Sprintf("%s=%v", request.Payload.Capability.Instance, request.Payload.Capability.Value))
+			c.mu.Unlock()
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "key"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	return c, svc
+}
+
+// Returns and forgets the recorded control commands
+func (c *cloud) take() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	controls := strings.Join(c.controls, " ")
+	c.controls = nil
+	return controls
+}
+
+// Runs a bridge against the broker until the test ends and waits until it is online
+func startBridge(t *testing.T, svc *service.GoveeService, b *broker) *Bridge {
+	t.Helper()
+
+	bridge := New(svc, Options{Broker: b.url(), ClientID: "test", RefreshInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bridge.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if got := b.waitFor("go-vee/status"); string(got.payload) != "online" || !got.retain {
+		t.Fatalf("availability %q retained %v, want a retained online", got.payload, got.retain)
+	}
+
+	return bridge
+}
+
+func TestDiscovery(t *testing.T) {
+	_, svc := newService(t)
+	b := startBroker(t)
+	startBridge(t, svc, b)
+
+	for _, id := range []string{deskLamp, tvBacklight} {
+		object := objectID(id)
+		msg := b.waitFor("homeassistant/light/govee_" + object + "/config")
+		if !msg.retain {
+			t.Errorf("%s: discovery message is not retained", id)
+		}
+
+		var config struct {
+			UniqueID     string `json:"unique_id"`
+			Schema       string `json:"schema"`
+			CommandTopic string `json:"command_topic"`
+			StateTopic   string `json:"state_topic"`
+			MinKelvin    int    `json:"min_kelvin"`
+			MaxKelvin    int    `json:"max_kelvin"`
+			Device       struct {
+				Identifiers []string `json:"identifiers"`
+				Model       string   `json:"model"`
+			} `json:"device"`
+		}
+		if err := json.Unmarshal(msg.payload, &config); err != nil {
+			t.Fatalf("%s: decoding discovery: %v", id, err)
+		}
+
+		if config.UniqueID != "govee_"+object || config.Schema != "json" || config.CommandTopic != "go-vee/"+object+"/set" || config.StateTopic != "go-vee/"+object+"/state" {
+			t.Errorf("%s: discovery %+v", id, config)
+		}
+		if config.MinKelvin != minKelvin || config.MaxKelvin != maxKelvin || config.Device.Model == "" || len(config.Device.Identifiers) != 1 {
+			t.Errorf("%s: discovery %+v", id, config)
+		}
+	}
+
+	// Only lights are announced
+	if b.latest("homeassistant/light/govee_"+objectID(heaterPlug)+"/config") != nil {
+		t.Error("the smart plug was announced as a light")
+	}
+
+	b.mu.Lock()
+	subscribed := strings.Join(b.subscribed, " ")
+	b.mu.Unlock()
+	if subscribed != "go-vee/+/set homeassistant/status" {
+		t.Errorf("subscribed to %s", subscribed)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	c, svc := newService(t)
+	b := startBroker(t)
+	startBridge(t, svc, b)
+
+	object := objectID(deskLamp)
+	b.waitFor("homeassistant/light/govee_" + object + "/config")
+
+	// A command for an unknown device is dropped; messages are handled in order
+	b.send("go-vee/0000000000000000/set", `{"state":"ON"}`)
+	b.send("go-vee/"+object+"/set", `{"state":"ON","brightness":40,"color":{"r":1,"g":2,"b":3}}`)
+
+	b.waitForJSON("go-vee/"+object+"/state", `{"state":"ON","brightness":40,"color_mode":"rgb","color":{"r":1,"g":2,"b":3}}`)
+	if got := c.take(); got != "powerSwitch=1 brightness=40 colorRgb=66051" {
+		t.Errorf("sent %s", got)
+	}
+	if b.latest("go-vee/0000000000000000/state") != nil {
+		t.Error("published a state for an unknown device")
+	}
+
+	b.send("go-vee/"+object+"/set", `{"state":"OFF"}`)
+	b.waitForJSON("go-vee/"+object+"/state", `{"state":"OFF","brightness":40,"color_mode":"rgb","color":{"r":1,"g":2,"b":3}}`)
+	if got := c.take(); got != "powerSwitch=0" {
+		t.Errorf("sent %s", got)
+	}
+}
+
+func TestStateAfterEvent(t *testing.T) {
+	_, svc := newService(t)
+	b := startBroker(t)
+	startBridge(t, svc, b)
+
+	topic := "go-vee/" + objectID(deskLamp) + "/state"
+	want := `{"state":"ON","brightness":70,"color_mode":"color_temp","color_temp":3000}`
+	event := events.Event{
+		Type:   events.TypeDeviceStateChanged,
+		Device: deskLamp,
+		Data:   service.StateChangeData{Current: service.DeviceState{On: true, Brightness: 70, ColorTemperatureK: 3000}},
+	}
+
+	// The bridge subscribes to events while it connects, so the event is repeated until it arrives
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		svc.Events().Publish(event)
+		time.Sleep(20 * time.Millisecond)
+
+		got := b.latest(topic)
+		if got != nil && jsonEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is %s, want %s", topic, got, want)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	c, svc := newService(t)
+	bridge := New(svc, Options{})
+	d := device{SKU: "H6199", ID: tvBacklight}
+	brightness, warm, cold := 30, 1000, 12000
+
+	start := lightState{State: "ON", Brightness: 80, ColorMode: "rgb", Color: &lightColor{R: 255}}
+
+	tests := []struct {
+		name  string
+		cmd   lightCommand
+		sent  string
+		state string
+	}{
+		{
+			"off skips the other fields",
+			lightCommand{State: "OFF", Brightness: &brightness, ColorTemp: &warm},
+			"powerSwitch=0",
+			`{"state":"OFF","brightness":80,"color_mode":"rgb","color":{"r":255,"g":0,"b":0}}`,
+		},
+		{
+			"on is always sent",
+			lightCommand{State: "ON", Brightness: &brightness},
+			"powerSwitch=1 brightness=30",
+			`{"state":"ON","brightness":30,"color_mode":"rgb","color":{"r":255,"g":0,"b":0}}`,
+		},
+		{
+			"rgb is packed",
+			lightCommand{Color: &lightColor{R: 255, G: 136, B: 0}},
+			"colorRgb=16746496",
+			`{"state":"ON","brightness":80,"color_mode":"rgb","color":{"r":255,"g":136,"b":0}}`,
+		},
+		{
+			"kelvin below the range",
+			lightCommand{ColorTemp: &warm},
+			"colorTemperatureK=2000",
+			`{"state":"ON","brightness":80,"color_mode":"color_temp","color_temp":2000}`,
+		},
+		{
+			"kelvin above the range",
+			lightCommand{ColorTemp: &cold},
+			"colorTemperatureK=9000",
+			`{"state":"ON","brightness":80,"color_mode":"color_temp","color_temp":9000}`,
+		},
+	}
+
+	for _, tt := range tests {
+		state, err := bridge.execute(context.Background(), d, tt.cmd, start)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := c.take(); got != tt.sent {
+			t.Errorf("%s: sent %s, want %s", tt.name, got, tt.sent)
+		}
+
+		data, _ := json.Marshal(state)
+		if !jsonEqual(data, tt.state) {
+			t.Errorf("%s: state %s, want %s", tt.name, data, tt.state)
+		}
+	}
+}
+
+func TestExecuteFailure(t *testing.T) {
+	c, svc := newService(t)
+	bridge := New(svc, Options{})
+	brightness := 30
+
+	// The fake cloud does not know the device, so the first command fails and the rest is not sent
+	start := lightState{State: "OFF"}
+	state, err := bridge.execute(context.Background(), device{SKU: "H6199", ID: "00:00:00:00:00:00:00:00"}, lightCommand{State: "ON", Brightness: &brightness}, start)
+	if err == nil {
+		t.Fatal("expected an error for an unknown device")
+	}
+	if state.State != "OFF" || state.Brightness != 0 {
+		t.Errorf("state %+v changed after a failure", state)
+	}
+	if got := c.take(); got != "powerSwitch=1" {
+		t.Errorf("sent %s, want only powerSwitch=1", got)
+	}
+}
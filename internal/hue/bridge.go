@@ -0,0 +1,590 @@
+// Package hue emulates the REST API of a Philips Hue bridge so Hue compatible apps and
+// voice assistants can control go-vee lights.
+package hue
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/color"
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// Hue API error types (https://developers.meethue.com/develop/hue-api/error-messages/)
+const (
+	errUnauthorizedUser  = 1
+	errInvalidJSON       = 2
+	errResourceNotFound  = 3
+	errLinkButton        = 101
+	errDeviceUnreachable = 201
+)
+
+const (
+	minMired = 111 // 9000 K
+	maxMired = 500 // 2000 K
+
+	pairingWindow = 30 * time.Second
+)
+
+type Options struct {
+	// Address announced to clients in SSDP replies and the description document
+	AdvertiseIP string
+	Port        int
+	// Accept new users without opening the pairing window first
+	OpenPairing bool
+	// JSON file that keeps registered users and light IDs across restarts; empty keeps them in memory
+	StateFile string
+}
+
+type lightState struct {
+	On        bool       `json:"on"`
+	Bri       int        `json:"bri"`
+	Hue       int        `json:"hue"`
+	Sat       int        `json:"sat"`
+	Effect    string     `json:"effect"`
+	XY        [2]float64 `json:"xy"`
+	CT        int        `json:"ct"`
+	Alert     string     `json:"alert"`
+	ColorMode string     `json:"colormode"`
+	Mode      string     `json:"mode"`
+	Reachable bool       `json:"reachable"`
+}
+
+type light struct {
+	State            lightState `json:"state"`
+	Type             string     `json:"type"`
+	Name             string     `json:"name"`
+	ModelID          string     `json:"modelid"`
+	ManufacturerName string     `json:"manufacturername"`
+	ProductName      string     `json:"productname"`
+	UniqueID         string     `json:"uniqueid"`
+	SWVersion        string     `json:"swversion"`
+
+	sku    string
+	device string
+}
+
+// Body of PUT /api/<user>/lights/<id>/state
+type stateUpdate struct {
+	On  *bool     `json:"on"`
+	Bri *int      `json:"bri"`
+	Hue *int      `json:"hue"`
+	Sat *int      `json:"sat"`
+	XY  []float64 `json:"xy"`
+	CT  *int      `json:"ct"`
+}
+
+type Bridge struct {
+	service *service.GoveeService
+	opts    Options
+
+	bridgeID string
+	serial   string
+
+	mu          sync.Mutex
+	users       map[string]string // username -> device type
+	lights      map[string]*light // light ID -> light
+	ids         map[string]string // Govee device ID -> light ID
+	nextID      int
+	pairUntil   time.Time
+	refreshedAt time.Time
+}
+
+func New(svc *service.GoveeService, opts Options) (*Bridge, error) {
+	sum := sha1.Sum([]byte(opts.AdvertiseIP + ":" + strconv.Itoa(opts.Port)))
+	serial := hex.EncodeToString(sum[:6])
+
+	b := &Bridge{
+		service:  svc,
+		opts:     opts,
+		serial:   serial,
+		bridgeID: strings.ToUpper(serial[:6] + "FFFE" + serial[6:]),
+		users:    make(map[string]string),
+		lights:   make(map[string]*light),
+		ids:      make(map[string]string),
+		nextID:   1,
+	}
+
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Allows new users to register for a short time, like pressing the link button on a real bridge
+func (b *Bridge) OpenPairing() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pairUntil = time.Now().Add(pairingWindow)
+	return b.pairUntil
+}
+
+// Reports whether the pairing window is open
+func (b *Bridge) pairing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return time.Now().Before(b.pairUntil)
+}
+
+func (b *Bridge) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /description.xml", b.handleDescription)
+	mux.HandleFunc("POST /api", b.handleCreateUser)
+	mux.HandleFunc("POST /api/{$}", b.handleCreateUser)
+	mux.HandleFunc("GET /api/config", b.handlePublicConfig)
+	mux.HandleFunc("GET /api/{user}", b.handleFullState)
+	mux.HandleFunc("GET /api/{user}/config", b.handleConfig)
+	mux.HandleFunc("GET /api/{user}/lights", b.handleLights)
+	mux.HandleFunc("GET /api/{user}/lights/{id}", b.handleLight)
+	mux.HandleFunc("PUT /api/{user}/lights/{id}/state", b.handleSetState)
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Hue reports errors with status 200 and an error object in a list
+func writeHueError(w http.ResponseWriter, errType int, address string, description string) {
+	writeJSON(w, []map[string]interface{}{{
+		"error": map[string]interface{}{
+			"type":        errType,
+			"address":     address,
+			"description": description,
+		},
+	}})
+}
+
+func (b *Bridge) authorized(w http.ResponseWriter, r *http.Request) bool {
+	b.mu.Lock()
+	_, ok := b.users[r.PathValue("user")]
+	b.mu.Unlock()
+
+	if !ok {
+		writeHueError(w, errUnauthorizedUser, strings.TrimPrefix(r.URL.Path, "/api/"+r.PathValue("user")), "unauthorized user")
+	}
+
+	return ok
+}
+
+func (b *Bridge) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceType string `json:"devicetype"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeviceType == "" {
+		writeHueError(w, errInvalidJSON, "/", "body contains invalid json")
+		return
+	}
+
+	if !b.opts.OpenPairing && !b.pairing() {
+		writeHueError(w, errLinkButton, "", "link button not pressed")
+		return
+	}
+
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	username := hex.EncodeToString(buf)
+
+	b.mu.Lock()
+	b.users[username] = req.DeviceType
+	err := b.save()
+	b.mu.Unlock()
+
+	logger := logging.FromContext(r.Context())
+	if err != nil {
+		logger.Error("Error saving Hue users", "error", err)
+	}
+	logger.Info("Registered Hue user", "devicetype", req.DeviceType)
+
+	writeJSON(w, []map[string]interface{}{{
+		"success": map[string]string{"username": username},
+	}})
+}
+
+func (b *Bridge) publicConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"name":             "go-vee",
+		"datastoreversion": "98",
+		"swversion":        "1948086000",
+		"apiversion":       "1.48.0",
+		"mac":              macFromSerial(b.serial),
+		"bridgeid":         b.bridgeID,
+		"factorynew":       false,
+		"replacesbridgeid": nil,
+		"modelid":          "BSB002",
+		"starterkitid":     "",
+	}
+}
+
+func (b *Bridge) handlePublicConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, b.publicConfig())
+}
+
+func (b *Bridge) config() map[string]interface{} {
+	config := b.publicConfig()
+	config["ipaddress"] = b.opts.AdvertiseIP
+	config["netmask"] = "255.255.255.0"
+	config["dhcp"] = true
+	config["UTC"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+	config["localtime"] = time.Now().Format("2006-01-02T15:04:05")
+	config["zigbeechannel"] = 25
+	config["linkbutton"] = b.pairing()
+	config["portalservices"] = false
+
+	return config
+}
+
+func (b *Bridge) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+
+	writeJSON(w, b.config())
+}
+
+func (b *Bridge) handleFullState(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"lights":        b.currentLights(r.Context()),
+		"groups":        map[string]interface{}{},
+		"config":        b.config(),
+		"schedules":     map[string]interface{}{},
+		"scenes":        map[string]interface{}{},
+		"rules":         map[string]interface{}{},
+		"sensors":       map[string]interface{}{},
+		"resourcelinks": map[string]interface{}{},
+	})
+}
+
+func (b *Bridge) handleLights(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+
+	writeJSON(w, b.currentLights(r.Context()))
+}
+
+func (b *Bridge) handleLight(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+
+	lights := b.currentLights(r.Context())
+	l, ok := lights[r.PathValue("id")]
+	if !ok {
+		writeHueError(w, errResourceNotFound, "/lights/"+r.PathValue("id"), "resource, /lights/"+r.PathValue("id")+", not available")
+		return
+	}
+
+	writeJSON(w, l)
+}
+
+func macFromSerial(serial string) string {
+	parts := make([]string, 0, 6)
+	for i := 0; i+2 <= len(serial) && len(parts) < 6; i += 2 {
+		parts = append(parts, serial[i:i+2])
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// Builds a Zigbee style unique ID from a Govee device ID
+func uniqueID(deviceID string) string {
+	return strings.ToLower(deviceID) + "-0b"
+}
+
+func newLightState() lightState {
+	return lightState{
+		Bri:       254,
+		Effect:    "none",
+		XY:        [2]float64{0.3127, 0.3290},
+		CT:        366,
+		Alert:     "none",
+		ColorMode: "xy",
+		Mode:      "homeautomation",
+		Reachable: true,
+	}
+}
+
+// Overwrites a light's state with the last state observed by go-vee
+func applyObserved(state *lightState, observed service.DeviceState) {
+	state.On = observed.On
+	if observed.Brightness > 0 {
+		state.Bri = max(1, int(math.Round(float64(observed.Brightness)*254/100)))
+	}
+
+	if observed.ColorTemperatureK > 0 {
+		state.ColorMode = "ct"
+		state.CT = min(max(color.KelvinToMired(observed.ColorTemperatureK), minMired), maxMired)
+		return
+	}
+
+	rgb := color.RGB{R: observed.Color.R, G: observed.Color.G, B: observed.Color.B}
+	x, y := rgb.XY()
+	h, s, _ := rgb.HSV()
+	state.ColorMode = "xy"
+	state.XY = [2]float64{math.Round(x*10000) / 10000, math.Round(y*10000) / 10000}
+	state.Hue = int(math.Round(h / 360 * 65535))
+	state.Sat = int(math.Round(s * 254))
+}
+
+// Returns every light keyed by its Hue light ID, refreshing the list from the inventory once a minute
+func (b *Bridge) currentLights(ctx context.Context) map[string]*light {
+	b.mu.Lock()
+	stale := time.Since(b.refreshedAt) > time.Minute
+	b.mu.Unlock()
+
+	if stale {
+		inventory := b.service.Inventory(ctx)
+		sort.Slice(inventory, func(i, j int) bool { return inventory[i].Device < inventory[j].Device })
+
+		b.mu.Lock()
+		added := false
+		for _, d := range inventory {
+			if !d.IsLight() {
+				continue
+			}
+
+			// IDs loaded from the state file are kept so apps find the same lights after a restart
+			id, ok := b.ids[d.Device]
+			if !ok {
+				id = strconv.Itoa(b.nextID)
+				b.nextID++
+				b.ids[d.Device] = id
+				added = true
+			}
+
+			if _, ok := b.lights[id]; !ok {
+				b.lights[id] = &light{
+					State:            newLightState(),
+					Type:             "Extended color light",
+					ModelID:          "LCT015",
+					ManufacturerName: "Signify Netherlands B.V.",
+					ProductName:      "Hue color lamp",
+					UniqueID:         uniqueID(d.Device),
+					SWVersion:        "1.50.2_r30933",
+					sku:              d.SKU,
+					device:           d.Device,
+				}
+			}
+
+			b.lights[id].Name = d.Name
+		}
+		b.refreshedAt = time.Now()
+
+		var err error
+		if added {
+			err = b.save()
+		}
+		b.mu.Unlock()
+
+		if err != nil {
+			logging.FromContext(ctx).Error("Error saving Hue light IDs", "error", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make(map[string]*light, len(b.lights))
+	for id, l := range b.lights {
+		if observed, ok := b.service.CachedState(l.device); ok {
+			applyObserved(&l.State, observed)
+		}
+
+		copied := *l
+		result[id] = &copied
+	}
+
+	return result
+}
+
+func (b *Bridge) handleSetState(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	address := "/lights/" + id + "/state/"
+
+	b.mu.Lock()
+	l, ok := b.lights[id]
+	var sku, deviceID string
+	if ok {
+		sku, deviceID = l.sku, l.device
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		writeHueError(w, errResourceNotFound, "/lights/"+id, "resource, /lights/"+id+", not available")
+		return
+	}
+
+	var update stateUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeHueError(w, errInvalidJSON, address, "body contains invalid json")
+		return
+	}
+
+	// The user name is a credential, so the history records the device type it registered with
+	b.mu.Lock()
+	deviceType := b.users[r.PathValue("user")]
+	b.mu.Unlock()
+
+	sourceIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	ctx := history.WithActor(r.Context(), history.Actor{Origin: "hue", SourceIP: sourceIP, Identity: deviceType})
+	control := func(capType, instance string, value int) error {
+		_, err := b.service.ControlDevice(ctx, sku, deviceID, service.ControlCapability{
+			Type:     capType,
+			Instance: instance,
+			Value:    float64(value),
+		}, service.TransportAuto)
+		return err
+	}
+
+	var results []map[string]interface{}
+	success := func(key string, value interface{}) {
+		results = append(results, map[string]interface{}{"success": map[string]interface{}{address + key: value}})
+	}
+	failure := func(key string, err error) {
+		logging.FromContext(ctx).Warn("Hue state update failed", "device", deviceID, "attribute", key, "error", err)
+		_, description := service.DescribeError(err)
+		results = append(results, map[string]interface{}{"error": map[string]interface{}{
+			"type":        errDeviceUnreachable,
+			"address":     address + key,
+			"description": fmt.Sprintf("parameter, %s, could not be set: %s", key, description),
+		}})
+	}
+
+	b.mu.Lock()
+	state := l.State
+	b.mu.Unlock()
+
+	if update.On != nil {
+		value := 0
+		if *update.On {
+			value = 1
+		}
+
+		if err := control("devices.capabilities.on_off", "powerSwitch", value); err != nil {
+			failure("on", err)
+		} else {
+			state.On = *update.On
+			success("on", *update.On)
+		}
+	}
+
+	if update.Bri != nil {
+		bri := min(max(*update.Bri, 1), 254)
+		percent := max(1, int(math.Round(float64(bri)*100/254)))
+
+		if err := control("devices.capabilities.range", "brightness", percent); err != nil {
+			failure("bri", err)
+		} else {
+			state.Bri = bri
+			success("bri", bri)
+		}
+	}
+
+	switch {
+	case len(update.XY) == 2:
+		rgb := color.FromXY(update.XY[0], update.XY[1])
+		if err := control("devices.capabilities.color_setting", "colorRgb", rgb.Packed()); err != nil {
+			failure("xy", err)
+		} else {
+			state.XY = [2]float64{update.XY[0], update.XY[1]}
+			state.ColorMode = "xy"
+			success("xy", update.XY)
+		}
+	case update.Hue != nil || update.Sat != nil:
+		hue, sat := state.Hue, state.Sat
+		if update.Hue != nil {
+			hue = min(max(*update.Hue, 0), 65535)
+		}
+		if update.Sat != nil {
+			sat = min(max(*update.Sat, 0), 254)
+		}
+
+		rgb := color.FromHSV(float64(hue)/65535*360, float64(sat)/254, 1)
+		if err := control("devices.capabilities.color_setting", "colorRgb", rgb.Packed()); err != nil {
+			failure("hue", err)
+		} else {
+			state.Hue, state.Sat = hue, sat
+			state.ColorMode = "hs"
+			if update.Hue != nil {
+				success("hue", hue)
+			}
+			if update.Sat != nil {
+				success("sat", sat)
+			}
+		}
+	case update.CT != nil:
+		ct := min(max(*update.CT, minMired), maxMired)
+		if err := control("devices.capabilities.color_setting", "colorTemperatureK", color.MiredToKelvin(ct)); err != nil {
+			failure("ct", err)
+		} else {
+			state.CT = ct
+			state.ColorMode = "ct"
+			success("ct", ct)
+		}
+	}
+
+	b.mu.Lock()
+	l.State = state
+	b.mu.Unlock()
+
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+	writeJSON(w, results)
+}
+
+func (b *Bridge) handleDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8" ?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <URLBase>http://%[1]s:%[2]d/</URLBase>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+    <friendlyName>go-vee (%[1]s)</friendlyName>
+    <manufacturer>Signify</manufacturer>
+    <manufacturerURL>http://www.philips-hue.com</manufacturerURL>
+    <modelDescription>Philips hue Personal Wireless Lighting</modelDescription>
+    <modelName>Philips hue bridge 2015</modelName>
+    <modelNumber>BSB002</modelNumber>
+    <modelURL>http://www.philips-hue.com</modelURL>
+    <serialNumber>%[3]s</serialNumber>
+    <UDN>uuid:%[4]s</UDN>
+    <presentationURL>index.html</presentationURL>
+  </device>
+</root>
+`, b.opts.AdvertiseIP, b.opts.Port, b.serial, b.uuid())
+}
+
+// Returns the UPnP UUID of the bridge, which by convention ends in the bridge serial number
+func (b *Bridge) uuid() string {
+	return "2f402f80-da50-11e1-9b23-" + b.serial
+}
@@ -0,0 +1,99 @@
+package hue
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+func testBridge(t *testing.T) *Bridge {
+	t.Helper()
+
+	b, err := New(service.NewGoveeService(nil, false), Options{AdvertiseIP: "127.0.0.1", Port: 80})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return b
+}
+
+// Registers a user through the pairing window and returns its username
+func createUser(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"devicetype":"test#go-vee"}`)))
+
+	var response []struct {
+		Success struct {
+			Username string `json:"username"`
+		} `json:"success"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response) != 1 || response[0].Success.Username == "" {
+		t.Fatalf("creating a user: %s", recorder.Body)
+	}
+	return response[0].Success.Username
+}
+
+func TestPairingWindow(t *testing.T) {
+	b := testBridge(t)
+	handler := b.Handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"devicetype":"test#go-vee"}`)))
+	if !strings.Contains(recorder.Body.String(), "link button not pressed") {
+		t.Fatalf("user created with the pairing window closed: %s", recorder.Body)
+	}
+
+	b.OpenPairing()
+	username := createUser(t, handler)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/"+username+"/config", nil))
+
+	var config struct {
+		LinkButton bool `json:"linkbutton"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &config); err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+	if !config.LinkButton {
+		t.Error("linkbutton is false while the pairing window is open")
+	}
+}
+
+// Run with -race: the config reads the pairing window while it is being opened
+func TestOpenPairingDuringConfig(t *testing.T) {
+	b := testBridge(t)
+	handler := b.Handler()
+
+	b.OpenPairing()
+	username := createUser(t, handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				b.OpenPairing()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/"+username+"/config", nil))
+				if recorder.Code != http.StatusOK {
+					t.Errorf("GET config: status %d", recorder.Code)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
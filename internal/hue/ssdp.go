@@ -0,0 +1,132 @@
+package hue
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// SSDP shares the multicast group used by Govee LAN discovery but listens on its own port,
+// so both can run on the same host
+const ssdpAddr = "239.255.255.250:1900"
+
+// Search targets a Hue bridge answers to
+var ssdpTargets = []string{"ssdp:all", "upnp:rootdevice", "urn:schemas-upnp-org:device:basic:1"}
+
+// Answers SSDP M-SEARCH requests so Hue apps can find the bridge, until ctx is cancelled
+func (b *Bridge) RunSSDP(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve SSDP address: %w", err)
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("failed to join SSDP multicast group: %w", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	logger.Info("SSDP responder started", "location", b.location())
+
+	buffer := make([]byte, 2048)
+	for ctx.Err() == nil {
+		n, src, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Warn("Error reading SSDP request", "error", err)
+			continue
+		}
+
+		target, ok := parseSearch(string(buffer[:n]))
+		if !ok {
+			continue
+		}
+
+		if err := b.replySearch(src, target); err != nil {
+			logger.Debug("Failed to answer SSDP search", "to", src.String(), "error", err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the search target of an M-SEARCH request the bridge should answer
+func parseSearch(request string) (string, bool) {
+	lines := strings.Split(request, "\r\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "M-SEARCH") {
+		return "", false
+	}
+
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "ST") {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		for _, target := range ssdpTargets {
+			if strings.EqualFold(value, target) {
+				return value, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (b *Bridge) location() string {
+	return fmt.Sprintf("http://%s:%d/description.xml", b.opts.AdvertiseIP, b.opts.Port)
+}
+
+func (b *Bridge) replySearch(to *net.UDPAddr, target string) error {
+	conn, err := net.DialUDP("udp4", nil, to)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	st := target
+	if strings.EqualFold(target, "ssdp:all") {
+		st = "upnp:rootdevice"
+	}
+	usn := "uuid:" + b.uuid() + "::" + st
+
+	response := "HTTP/1.1 200 OK\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"EXT:\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		"LOCATION: " + b.location() + "\r\n" +
+		"SERVER: Linux/3.14.0 UPnP/1.0 IpBridge/1.48.0\r\n" +
+		"hue-bridgeid: " + b.bridgeID + "\r\n" +
+		"ST: " + st + "\r\n" +
+		"USN: " + usn + "\r\n" +
+		"\r\n"
+
+	_, err = conn.Write([]byte(response))
+	return err
+}
+
+// Returns the address of the interface used for outbound traffic, which is what clients on the LAN can reach.
+// Dialing UDP does not send any packets.
+func DetectIP() (string, error) {
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect local IP address: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
@@ -0,0 +1,87 @@
+package hue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Registered users and light IDs, kept on disk so apps stay paired and see the same lights after a restart
+type savedState struct {
+	Users  map[string]string `json:"users"`  // username -> device type
+	Lights map[string]string `json:"lights"` // Govee device ID -> light ID
+}
+
+// Loads the users and light IDs from the state file. A missing file is created on the first change.
+func (b *Bridge) load() error {
+	if b.opts.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(b.opts.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading Hue state file: %w", err)
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing Hue state file %s: %w", b.opts.StateFile, err)
+	}
+
+	for username, deviceType := range state.Users {
+		b.users[username] = deviceType
+	}
+
+	for device, id := range state.Lights {
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid light ID %q in %s", id, b.opts.StateFile)
+		}
+
+		b.ids[device] = id
+		if n >= b.nextID {
+			b.nextID = n + 1
+		}
+	}
+
+	return nil
+}
+
+// Writes the users and light IDs to a temporary file and renames it over the state file. Callers hold mu.
+func (b *Bridge) save() error {
+	if b.opts.StateFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(savedState{Users: b.users, Lights: b.ids}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding Hue state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.opts.StateFile), ".hue-*.json")
+	if err != nil {
+		return fmt.Errorf("writing Hue state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing Hue state file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing Hue state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), b.opts.StateFile); err != nil {
+		return fmt.Errorf("writing Hue state file: %w", err)
+	}
+
+	return nil
+}
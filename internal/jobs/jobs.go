@@ -0,0 +1,254 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/google/uuid"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+)
+
+var (
+	ErrNotFound  = errors.New("job not found")
+	ErrFinished  = errors.New("job already finished")
+	ErrQueueFull = errors.New("job queue is full")
+)
+
+// The outcome of a job for one device
+type Result struct {
+	SKU       string  `json:"sku"`
+	Device    string  `json:"device"`
+	Success   bool    `json:"success"`
+	Transport string  `json:"transport,omitempty"`
+	LANError  string  `json:"lanError,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	ErrorCode string  `json:"errorCode,omitempty"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+// A unit of work run in the background. Results are empty until the job is done.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	Success    bool       `json:"success"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Results    []Result   `json:"results"`
+}
+
+// Does the work of a job and reports the outcome per device. The context is cancelled when
+// the job is cancelled or the server shuts down.
+type Func func(ctx context.Context) []Result
+
+type entry struct {
+	job    Job
+	owner  string
+	ctx    context.Context
+	run    Func
+	cancel context.CancelFunc
+}
+
+// Runs jobs on a fixed number of workers and keeps the most recent ones for status polling
+type Manager struct {
+	workers int
+	keep    int
+	queue   chan *entry
+
+	mu    sync.Mutex
+	jobs  map[string]*entry
+	order []string // job IDs, oldest first
+}
+
+func NewManager(workers int, queueSize int, keep int) *Manager {
+	return &Manager{
+		workers: workers,
+		keep:    keep,
+		queue:   make(chan *entry, queueSize),
+		jobs:    make(map[string]*entry),
+	}
+}
+
+// Queues a job for owner, who alone can see and cancel it. The job keeps the values of ctx,
+// such as the request ID and a client's Govee API key, but not its cancellation, so it
+// outlives the request that submitted it.
+func (m *Manager) Submit(ctx context.Context, owner string, kind string, run Func) (Job, error) {
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	e := &entry{
+		owner: owner,
+		job: Job{
+			ID:        uuid.New().String(),
+			Kind:      kind,
+			Status:    StatusQueued,
+			CreatedAt: time.Now(),
+			Results:   []Result{},
+		},
+		ctx:    jobCtx,
+		run:    run,
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case m.queue <- e:
+	default:
+		cancel()
+		return Job{}, ErrQueueFull
+	}
+
+	m.jobs[e.job.ID] = e
+	m.order = append(m.order, e.job.ID)
+	m.prune()
+
+	return e.job, nil
+}
+
+// Drops the oldest finished jobs beyond the retention limit. Queued and running jobs are
+// always kept. The caller must hold m.mu.
+func (m *Manager) prune() {
+	excess := len(m.order) - m.keep
+	if excess <= 0 {
+		return
+	}
+
+	kept := m.order[:0]
+	for _, id := range m.order {
+		status := m.jobs[id].job.Status
+		if excess > 0 && status != StatusQueued && status != StatusRunning {
+			delete(m.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+// Returns a job of owner. Jobs of other owners are reported as not found.
+func (m *Manager) Get(id string, owner string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok || e.owner != owner {
+		return Job{}, ErrNotFound
+	}
+
+	return e.job, nil
+}
+
+// Returns the kept jobs of owner, newest first
+func (m *Manager) List(owner string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []Job{}
+	for i := len(m.order) - 1; i >= 0; i-- {
+		if e := m.jobs[m.order[i]]; e.owner == owner {
+			jobs = append(jobs, e.job)
+		}
+	}
+
+	return jobs
+}
+
+// Cancels a queued or running job of owner. A queued job never starts; a running job has its
+// context cancelled and finishes with the results of the devices it already reached.
+func (m *Manager) Cancel(id string, owner string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok || e.owner != owner {
+		return Job{}, ErrNotFound
+	}
+
+	switch e.job.Status {
+	case StatusQueued:
+		now := time.Now()
+		e.job.Status = StatusCancelled
+		e.job.FinishedAt = &now
+	case StatusRunning:
+		e.job.Status = StatusCancelled
+	default:
+		return e.job, ErrFinished
+	}
+
+	e.cancel()
+	return e.job, nil
+}
+
+// Runs the workers until ctx is done. Running jobs are cancelled on shutdown.
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-m.queue:
+					m.runJob(ctx, e)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *Manager) runJob(ctx context.Context, e *entry) {
+	m.mu.Lock()
+	if e.job.Status != StatusQueued {
+		m.mu.Unlock()
+		return
+	}
+	started := time.Now()
+	e.job.Status = StatusRunning
+	e.job.StartedAt = &started
+	m.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, e.cancel)
+	defer stop()
+	defer e.cancel()
+
+	logger := logging.FromContext(e.ctx).With("job", e.job.ID, "kind", e.job.Kind)
+	logger.Debug("Running job")
+
+	results := e.run(e.ctx)
+	if results == nil {
+		results = []Result{}
+	}
+
+	success := len(results) > 0
+	for _, result := range results {
+		success = success && result.Success
+	}
+
+	m.mu.Lock()
+	finished := time.Now()
+	e.job.FinishedAt = &finished
+	e.job.Results = results
+	e.job.Success = success
+	if e.job.Status == StatusRunning {
+		e.job.Status = StatusDone
+	}
+	status := e.job.Status
+	m.mu.Unlock()
+
+	logger.Info("Finished job", "status", status, "success", success, "duration", finished.Sub(started))
+}
@@ -0,0 +1,242 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a job function that reports the given outcomes
+func reporting(success ...bool) Func {
+	return func(ctx context.Context) []Result {
+		var results []Result
+		for _, ok := range success {
+			results = append(results, Result{SKU: "H6022", Device: "AA", Success: ok})
+		}
+		return results
+	}
+}
+
+func submit(t *testing.T, m *Manager, owner string, run Func) Job {
+	t.Helper()
+
+	job, err := m.Submit(context.Background(), owner, "control", run)
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	return job
+}
+
+// Runs a queued job on the calling goroutine, as a worker would
+func runNow(m *Manager, id string) {
+	m.mu.Lock()
+	e := m.jobs[id]
+	m.mu.Unlock()
+
+	m.runJob(context.Background(), e)
+}
+
+// Waits until the job has finished
+func waitFinished(t *testing.T, m *Manager, id string, owner string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(id, owner)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+// Returns the IDs of the jobs separated by spaces
+func joinIDs(jobs []Job, names map[string]string) string {
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, names[job.ID])
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestSubmitOutlivesRequest(t *testing.T) {
+	m := NewManager(1, 10, 10)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go m.Run(ctx)
+
+	// The request that submits the job is over before the job runs
+	request, cancel := context.WithCancel(context.Background())
+	var jobErr error
+	job, err := m.Submit(request, "alice", "control", func(ctx context.Context) []Result {
+		jobErr = ctx.Err()
+		return []Result{{Device: "AA", Success: ctx.Err() == nil}}
+	})
+	cancel()
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	job = waitFinished(t, m, job.ID, "alice")
+	if jobErr != nil || job.Status != StatusDone || !job.Success {
+		t.Errorf("job %+v with context error %v, want done and successful", job, jobErr)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     Func
+		results int
+		success bool
+	}{
+		{"all devices succeed", reporting(true, true), 2, true},
+		{"one device fails", reporting(true, false), 2, false},
+		{"no devices", reporting(), 0, false},
+	}
+
+	for _, tt := range tests {
+		m := NewManager(1, 10, 10)
+
+		var job, during Job
+		job = submit(t, m, "alice", func(ctx context.Context) []Result {
+			during, _ = m.Get(job.ID, "alice")
+			return tt.run(ctx)
+		})
+		runNow(m, job.ID)
+
+		if during.Status != StatusRunning || during.StartedAt == nil || during.FinishedAt != nil {
+			t.Errorf("%s: job while running %+v, want running with a start time", tt.name, during)
+		}
+
+		job, _ = m.Get(job.ID, "alice")
+		if job.Status != StatusDone || job.FinishedAt == nil || len(job.Results) != tt.results || job.Success != tt.success {
+			t.Errorf("%s: got %s with %d results and success %v, want done with %d results and success %v",
+				tt.name, job.Status, len(job.Results), job.Success, tt.results, tt.success)
+		}
+		if job.Results == nil {
+			t.Errorf("%s: results are nil, want an empty list", tt.name)
+		}
+	}
+}
+
+func TestCancelQueued(t *testing.T) {
+	m := NewManager(1, 10, 10)
+
+	ran := false
+	job := submit(t, m, "alice", func(ctx context.Context) []Result {
+		ran = true
+		return nil
+	})
+
+	job, err := m.Cancel(job.ID, "alice")
+	if err != nil || job.Status != StatusCancelled || job.FinishedAt == nil {
+		t.Fatalf("Cancel: job %+v, error %v, want cancelled and finished", job, err)
+	}
+
+	// A worker picking up the cancelled job skips it
+	runNow(m, job.ID)
+	if ran {
+		t.Error("cancelled job ran")
+	}
+
+	if _, err := m.Cancel(job.ID, "alice"); !errors.Is(err, ErrFinished) {
+		t.Errorf("cancelling again: %v, want %v", err, ErrFinished)
+	}
+}
+
+func TestCancelRunning(t *testing.T) {
+	m := NewManager(1, 10, 10)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go m.Run(ctx)
+
+	started := make(chan struct{})
+	job := submit(t, m, "alice", func(ctx context.Context) []Result {
+		close(started)
+		<-ctx.Done()
+		return []Result{{Device: "AA", Success: true}}
+	})
+	<-started
+
+	job, err := m.Cancel(job.ID, "alice")
+	if err != nil || job.Status != StatusCancelled {
+		t.Fatalf("Cancel: job %+v, error %v, want cancelled", job, err)
+	}
+
+	// The job stays cancelled and keeps the results of the devices it reached
+	job = waitFinished(t, m, job.ID, "alice")
+	if job.Status != StatusCancelled || len(job.Results) != 1 {
+		t.Errorf("finished job %s with %d results, want cancelled with 1 result", job.Status, len(job.Results))
+	}
+
+	if _, err := m.Cancel(job.ID, "alice"); !errors.Is(err, ErrFinished) {
+		t.Errorf("cancelling a finished job: %v, want %v", err, ErrFinished)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	m := NewManager(1, 10, 2)
+	names := make(map[string]string)
+
+	// The oldest job stays queued, the next two finish, the last one is submitted after them
+	names[submit(t, m, "alice", reporting(true)).ID] = "queued"
+	for _, name := range []string{"first", "second"} {
+		job := submit(t, m, "alice", reporting(true))
+		names[job.ID] = name
+		runNow(m, job.ID)
+	}
+	names[submit(t, m, "alice", reporting(true)).ID] = "last"
+
+	// Finished jobs beyond the limit are dropped, oldest first; queued jobs are kept
+	if got := joinIDs(m.List("alice"), names); got != "last queued" {
+		t.Errorf("kept %s, want last queued", got)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	m := NewManager(1, 10, 10)
+	names := make(map[string]string)
+
+	alice := submit(t, m, "alice", reporting(true))
+	names[alice.ID] = "alice-1"
+	names[submit(t, m, "bob", reporting(true)).ID] = "bob-1"
+	names[submit(t, m, "alice", reporting(true)).ID] = "alice-2"
+
+	if got := joinIDs(m.List("alice"), names); got != "alice-2 alice-1" {
+		t.Errorf("alice lists %s, want alice-2 alice-1", got)
+	}
+	if got := m.List("carol"); got == nil || len(got) != 0 {
+		t.Errorf("carol lists %v, want an empty list", got)
+	}
+
+	if _, err := m.Get(alice.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("bob getting a job of alice: %v, want %v", err, ErrNotFound)
+	}
+	if _, err := m.Cancel(alice.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("bob cancelling a job of alice: %v, want %v", err, ErrNotFound)
+	}
+	if job, _ := m.Get(alice.ID, "alice"); job.Status != StatusQueued {
+		t.Errorf("job of alice is %s after bob tried to cancel it, want %s", job.Status, StatusQueued)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	m := NewManager(1, 1, 10)
+	submit(t, m, "alice", reporting(true))
+
+	if _, err := m.Submit(context.Background(), "alice", "control", reporting(true)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit with a full queue: %v, want %v", err, ErrQueueFull)
+	}
+	if got := len(m.List("alice")); got != 1 {
+		t.Errorf("%d jobs listed, want 1", got)
+	}
+}
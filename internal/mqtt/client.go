@@ -0,0 +1,558 @@
+// Package mqtt is a minimal MQTT 3.1.1 client supporting QoS 0 and 1, which is all
+// the Govee event broker and Home Assistant need.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// Received messages held for OnMessage by default
+const DefaultMaxQueued = 1000
+
+var ErrClosed = errors.New("mqtt: connection closed")
+
+// CONNACK return codes (MQTT 3.1.1, section 3.2.2.3)
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+type Options struct {
+	// Broker URL, mqtt://host:port for plain TCP or mqtts://host:port for TLS
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	TLSConfig *tls.Config
+
+	// Published by the broker if the connection is lost without a DISCONNECT
+	Will *Message
+
+	// Called for every message received on a subscribed topic, one at a time. Messages queue
+	// up while it runs, so a slow callback never holds up acknowledgements from the broker.
+	OnMessage func(Message)
+
+	// Received messages held while OnMessage runs, 0 for DefaultMaxQueued. Messages that arrive
+	// while the queue is full are dropped with a warning, and dropped QoS 1 messages are not
+	// acknowledged.
+	MaxQueued int
+
+	// Called by Run before it closes the connection on shutdown, for example to publish an offline status
+	BeforeDisconnect func(ctx context.Context, c *Client)
+}
+
+type Client struct {
+	opts   Options
+	conn   net.Conn
+	logger *slog.Logger
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	nextID   uint16
+	pending  map[uint16]chan packet
+	closed   bool
+	closeErr error
+
+	done     chan struct{}
+	lastRead time.Time
+
+	// Received messages waiting for OnMessage. The read loop never waits for the callback.
+	queueMu     sync.Mutex
+	queue       []Message
+	queueClosed bool
+	dropped     int // messages dropped since the queue last had room
+	queued      chan struct{}
+}
+
+func dialBroker(ctx context.Context, opts Options) (net.Conn, error) {
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		return dialer.DialContext(ctx, "tcp", host)
+	case "mqtts", "ssl", "tls":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+
+		tlsConfig := opts.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+		}
+
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		return tlsDialer.DialContext(ctx, "tcp", host)
+	}
+
+	return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+}
+
+// Connects to the broker and completes the CONNECT handshake
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 60 * time.Second
+	}
+
+	if opts.MaxQueued <= 0 {
+		opts.MaxQueued = DefaultMaxQueued
+	}
+
+	conn, err := dialBroker(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker: %w", err)
+	}
+
+	c := &Client{
+		opts:    opts,
+		conn:    conn,
+		logger:  logging.FromContext(ctx).With("broker", opts.Broker, "client_id", opts.ClientID),
+		pending: make(map[uint16]chan packet),
+		done:    make(chan struct{}),
+		queued:  make(chan struct{}, 1),
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	reader := bufio.NewReader(conn)
+	if err := c.connect(reader); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.lastRead = time.Now()
+	go c.readLoop(reader)
+	go c.dispatchLoop()
+	go c.keepAliveLoop()
+
+	return c, nil
+}
+
+func (c *Client) connect(reader *bufio.Reader) error {
+	var flags byte = 0x02 // clean session
+	if c.opts.Username != "" {
+		flags |= 0x80
+	}
+	if c.opts.Password != "" {
+		flags |= 0x40
+	}
+	if will := c.opts.Will; will != nil {
+		flags |= 0x04 | (will.QoS&0x03)<<3
+		if will.Retain {
+			flags |= 0x20
+		}
+	}
+
+	body := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, flags}
+	body = binary.BigEndian.AppendUint16(body, uint16(c.opts.KeepAlive/time.Second))
+
+	body, err := appendString(body, c.opts.ClientID)
+	if err != nil {
+		return fmt.Errorf("invalid client ID: %w", err)
+	}
+	if will := c.opts.Will; will != nil {
+		if body, err = appendString(body, will.Topic); err != nil {
+			return fmt.Errorf("invalid will topic: %w", err)
+		}
+		if body, err = appendBytes(body, will.Payload); err != nil {
+			return fmt.Errorf("invalid will payload: %w", err)
+		}
+	}
+	if c.opts.Username != "" {
+		if body, err = appendString(body, c.opts.Username); err != nil {
+			return fmt.Errorf("invalid user name: %w", err)
+		}
+	}
+	if c.opts.Password != "" {
+		if body, err = appendString(body, c.opts.Password); err != nil {
+			return fmt.Errorf("invalid password: %w", err)
+		}
+	}
+
+	if err := c.write(packet{kind: packetConnect, body: body}); err != nil {
+		return fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	ack, err := readPacket(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+
+	if ack.kind != packetConnack || len(ack.body) != 2 {
+		return fmt.Errorf("unexpected packet type %d while waiting for CONNACK", ack.kind)
+	}
+
+	if code := ack.body[1]; code != 0 {
+		reason, ok := connackErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("code %d", code)
+		}
+		return fmt.Errorf("broker refused connection: %s", reason)
+	}
+
+	return nil
+}
+
+func (c *Client) write(p packet) error {
+	data, err := encodePacket(p)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.conn.Write(data)
+	return err
+}
+
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	c.closeErr = err
+	c.conn.Close()
+	close(c.done)
+
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) readLoop(reader *bufio.Reader) {
+	defer c.closeQueue()
+
+	for {
+		p, err := readPacket(reader)
+		if err != nil {
+			c.shutdown(fmt.Errorf("mqtt: read failed: %w", err))
+			return
+		}
+
+		c.mu.Lock()
+		c.lastRead = time.Now()
+		c.mu.Unlock()
+
+		switch p.kind {
+		case packetPublish:
+			msg, id, err := decodePublish(p)
+			if err != nil {
+				c.shutdown(fmt.Errorf("mqtt: malformed PUBLISH: %w", err))
+				return
+			}
+
+			// Acknowledged only once the message is queued, so the broker never sees a PUBACK
+			// for a message that was dropped
+			if c.enqueue(msg) && msg.QoS == 1 {
+				c.write(packet{kind: packetPuback, body: binary.BigEndian.AppendUint16(nil, id)})
+			}
+		case packetPuback, packetSuback, packetUnsuback:
+			if len(p.body) < 2 {
+				continue
+			}
+
+			id := binary.BigEndian.Uint16(p.body)
+			c.mu.Lock()
+			ch, ok := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+
+			if ok {
+				ch <- p
+			}
+		case packetPingresp:
+		}
+	}
+}
+
+// Hands a received message to the dispatch loop without waiting for it. Reports false when the
+// queue is full and the message was dropped.
+func (c *Client) enqueue(msg Message) bool {
+	c.queueMu.Lock()
+	if len(c.queue) >= c.opts.MaxQueued {
+		c.dropped++
+		dropped := c.dropped
+		c.queueMu.Unlock()
+
+		// Warn once per overflow rather than for every message
+		if dropped == 1 {
+			c.logger.Warn("MQTT message queue full, dropping messages", "topic", msg.Topic, "queued", c.opts.MaxQueued)
+		}
+		return false
+	}
+
+	c.queue = append(c.queue, msg)
+	c.queueMu.Unlock()
+
+	select {
+	case c.queued <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// Lets the dispatch loop finish once it has delivered the queued messages
+func (c *Client) closeQueue() {
+	c.queueMu.Lock()
+	c.queueClosed = true
+	c.queueMu.Unlock()
+
+	select {
+	case c.queued <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) dispatchLoop() {
+	for range c.queued {
+		c.queueMu.Lock()
+		batch, closed, dropped := c.queue, c.queueClosed, c.dropped
+		c.queue = nil
+		c.dropped = 0
+		c.queueMu.Unlock()
+
+		if dropped > 0 {
+			c.logger.Warn("Dropped MQTT messages while the queue was full", "dropped", dropped)
+		}
+
+		for _, msg := range batch {
+			if c.opts.OnMessage != nil {
+				c.opts.OnMessage(msg)
+			}
+		}
+
+		if closed {
+			return
+		}
+	}
+}
+
+func (c *Client) keepAliveLoop() {
+	ticker := time.NewTicker(c.opts.KeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			silent := time.Since(c.lastRead)
+			c.mu.Unlock()
+
+			if silent > c.opts.KeepAlive*3/2 {
+				c.shutdown(errors.New("mqtt: keep alive timeout"))
+				return
+			}
+
+			if err := c.write(packet{kind: packetPingreq}); err != nil {
+				c.shutdown(fmt.Errorf("mqtt: ping failed: %w", err))
+				return
+			}
+		}
+	}
+}
+
+func decodePublish(p packet) (Message, uint16, error) {
+	msg := Message{
+		QoS:    (p.flags >> 1) & 0x03,
+		Retain: p.flags&0x01 != 0,
+	}
+
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	msg.Topic = topic
+
+	var id uint16
+	if msg.QoS > 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, errors.New("missing packet identifier")
+		}
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+
+	msg.Payload = rest
+	return msg, id, nil
+}
+
+// Registers a wait for the acknowledgement of a new packet identifier
+func (c *Client) allocateID() (uint16, chan packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, nil, ErrClosed
+	}
+
+	for {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		if _, used := c.pending[c.nextID]; !used {
+			break
+		}
+	}
+
+	ch := make(chan packet, 1)
+	c.pending[c.nextID] = ch
+	return c.nextID, ch, nil
+}
+
+func (c *Client) release(id uint16) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) await(ctx context.Context, id uint16, ch chan packet) (packet, error) {
+	select {
+	case p, ok := <-ch:
+		if !ok {
+			return packet{}, ErrClosed
+		}
+		return p, nil
+	case <-ctx.Done():
+		c.release(id)
+		return packet{}, ctx.Err()
+	}
+}
+
+// Subscribes to a topic filter with the given maximum QoS and waits for the SUBACK
+func (c *Client) Subscribe(ctx context.Context, filter string, qos byte) error {
+	body, err := appendString(nil, filter)
+	if err != nil {
+		return fmt.Errorf("invalid topic filter: %w", err)
+	}
+
+	id, ch, err := c.allocateID()
+	if err != nil {
+		return err
+	}
+
+	body = append(binary.BigEndian.AppendUint16(nil, id), body...)
+	body = append(body, qos&0x03)
+
+	if err := c.write(packet{kind: packetSubscribe, flags: 0x02, body: body}); err != nil {
+		c.release(id)
+		return fmt.Errorf("failed to send SUBSCRIBE: %w", err)
+	}
+
+	ack, err := c.await(ctx, id, ch)
+	if err != nil {
+		return err
+	}
+
+	if len(ack.body) < 3 || ack.body[2] == 0x80 {
+		return fmt.Errorf("broker rejected subscription to %s", filter)
+	}
+
+	return nil
+}
+
+// Publishes a message. QoS 1 messages wait for the broker's PUBACK.
+func (c *Client) Publish(ctx context.Context, msg Message) error {
+	var flags byte
+	if msg.Retain {
+		flags |= 0x01
+	}
+
+	body, err := appendString(nil, msg.Topic)
+	if err != nil {
+		return fmt.Errorf("invalid topic: %w", err)
+	}
+
+	if msg.QoS == 0 {
+		body = append(body, msg.Payload...)
+		return c.write(packet{kind: packetPublish, flags: flags, body: body})
+	}
+
+	id, ch, err := c.allocateID()
+	if err != nil {
+		return err
+	}
+
+	flags |= 1 << 1
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = append(body, msg.Payload...)
+
+	if err := c.write(packet{kind: packetPublish, flags: flags, body: body}); err != nil {
+		c.release(id)
+		return fmt.Errorf("failed to send PUBLISH: %w", err)
+	}
+
+	_, err = c.await(ctx, id, ch)
+	return err
+}
+
+// Closed when the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Returns the reason the connection ended
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closeErr
+}
+
+// Sends DISCONNECT and closes the connection
+func (c *Client) Close() error {
+	c.write(packet{kind: packetDisconnect})
+	c.shutdown(ErrClosed)
+	return nil
+}
@@ -0,0 +1,422 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A broker connection as seen by a test: the packets the client sent and a way to answer them
+type brokerConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (b *brokerConn) read() packet {
+	b.t.Helper()
+
+	b.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := readPacket(b.reader)
+	if err != nil {
+		b.t.Fatalf("broker: reading packet: %v", err)
+	}
+	return p
+}
+
+// Reads packets until one of the given kind arrives, skipping keep alive pings
+func (b *brokerConn) expect(kind byte) packet {
+	b.t.Helper()
+
+	for {
+		p := b.read()
+		if p.kind == kind {
+			return p
+		}
+		if p.kind != packetPingreq {
+			b.t.Fatalf("broker: got packet type %d, want %d", p.kind, kind)
+		}
+	}
+}
+
+// Reads and discards packets until the client hangs up
+func (b *brokerConn) drain() {
+	b.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := readPacket(b.reader); err != nil {
+			return
+		}
+	}
+}
+
+func (b *brokerConn) send(p packet) {
+	b.t.Helper()
+
+	data, err := encodePacket(p)
+	if err != nil {
+		b.t.Fatalf("broker: encoding packet: %v", err)
+	}
+	if _, err := b.conn.Write(data); err != nil {
+		b.t.Fatalf("broker: writing packet: %v", err)
+	}
+}
+
+// Starts a broker on a loopback port that accepts one connection, answers its CONNECT with
+// the given return code and hands the connection to handle
+func startBroker(t *testing.T, code byte, handle func(b *brokerConn)) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	done := make(chan struct{})
+	t.Cleanup(func() { <-done })
+
+	go func() {
+		defer close(done)
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := &brokerConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+		// A client that fails before sending CONNECT just hangs up
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		connect, err := readPacket(b.reader)
+		if err != nil {
+			return
+		}
+		if connect.kind != packetConnect || !strings.HasPrefix(string(connect.body), "\x00\x04MQTT\x04") {
+			t.Errorf("broker: unexpected CONNECT body %q", connect.body)
+		}
+
+		b.send(packet{kind: packetConnack, body: []byte{0, code}})
+		if code == 0 && handle != nil {
+			handle(b)
+		}
+	}()
+
+	return "mqtt://" + listener.Addr().String()
+}
+
+func dialTest(t *testing.T, broker string, onMessage func(Message)) *Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, Options{Broker: broker, ClientID: "test", OnMessage: onMessage})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestDialRefused(t *testing.T) {
+	tests := []struct {
+		code byte
+		want string
+	}{
+		{4, "bad user name or password"},
+		{5, "not authorized"},
+		{9, "code 9"},
+	}
+
+	for _, tt := range tests {
+		broker := startBroker(t, tt.code, nil)
+
+		_, err := Dial(context.Background(), Options{Broker: broker, ClientID: "test"})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("code %d: got error %v, want %q", tt.code, err, tt.want)
+		}
+	}
+}
+
+func TestDialInvalidClientID(t *testing.T) {
+	broker := startBroker(t, 0, nil)
+
+	_, err := Dial(context.Background(), Options{Broker: broker, ClientID: strings.Repeat("a", maxFieldLength+1)})
+	if err == nil || !strings.Contains(err.Error(), "client ID") {
+		t.Errorf("got error %v, want an invalid client ID error", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		for _, code := range []byte{1, 0x80} {
+			sub := b.expect(packetSubscribe)
+			if sub.flags != 0x02 {
+				b.t.Errorf("SUBSCRIBE flags %#x, want 0x02", sub.flags)
+			}
+
+			filter, rest, err := readString(sub.body[2:])
+			if err != nil || filter != "devices/#" || len(rest) != 1 || rest[0] != 1 {
+				b.t.Errorf("SUBSCRIBE for %q with %v, err %v", filter, rest, err)
+			}
+
+			b.send(packet{kind: packetSuback, body: append(append([]byte{}, sub.body[:2]...), code)})
+		}
+		b.drain()
+	})
+
+	client := dialTest(t, broker, nil)
+
+	if err := client.Subscribe(context.Background(), "devices/#", 1); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if err := client.Subscribe(context.Background(), "devices/#", 1); err == nil {
+		t.Error("expected an error when the broker rejects the subscription")
+	}
+}
+
+func TestPublishMatchesPuback(t *testing.T) {
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		first := b.expect(packetPublish)
+		second := b.expect(packetPublish)
+
+		firstID := first.body[len(first.body)-len("one")-2:][:2]
+		secondID := second.body[len(second.body)-len("two")-2:][:2]
+
+		// An acknowledgement nobody waits for is ignored, the others are answered out of order
+		b.send(packet{kind: packetPuback, body: []byte{0xff, 0xff}})
+		b.send(packet{kind: packetPuback, body: secondID})
+		b.send(packet{kind: packetPuback, body: firstID})
+		b.drain()
+	})
+
+	client := dialTest(t, broker, nil)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- client.Publish(context.Background(), Message{Topic: "a", Payload: []byte("one"), QoS: 1})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		errs <- client.Publish(context.Background(), Message{Topic: "b", Payload: []byte("two"), QoS: 1})
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("Publish: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Publish did not return after its PUBACK")
+		}
+	}
+}
+
+func TestReceivePublish(t *testing.T) {
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		topic, _ := appendString(nil, "go-vee/light/set")
+		body := append(append(append([]byte{}, topic...), 0x00, 0x07), "ON"...)
+		b.send(packet{kind: packetPublish, flags: 0x02, body: body})
+
+		ack := b.expect(packetPuback)
+		if id := binary.BigEndian.Uint16(ack.body); id != 7 {
+			b.t.Errorf("PUBACK for packet %d, want 7", id)
+		}
+		b.drain()
+	})
+
+	messages := make(chan Message, 1)
+	dialTest(t, broker, func(msg Message) {
+		messages <- msg
+	})
+
+	select {
+	case msg := <-messages:
+		if msg.Topic != "go-vee/light/set" || string(msg.Payload) != "ON" || msg.QoS != 1 {
+			t.Errorf("got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnMessage was not called")
+	}
+}
+
+func TestShutdownReleasesWaiters(t *testing.T) {
+	published := make(chan struct{})
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		b.expect(packetPublish)
+		close(published)
+
+		// Never acknowledge, and drop the connection
+		b.conn.Close()
+	})
+
+	client := dialTest(t, broker, nil)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Publish(context.Background(), Message{Topic: "a", Payload: []byte("x"), QoS: 1})
+	}()
+
+	<-published
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Publish returned %v, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish kept waiting after the connection was lost")
+	}
+
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done was not closed")
+	}
+
+	if err := client.Publish(context.Background(), Message{Topic: "a", QoS: 1}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish after shutdown returned %v, want ErrClosed", err)
+	}
+}
+
+func TestCloseReleasesWaiters(t *testing.T) {
+	published := make(chan struct{})
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		b.expect(packetPublish)
+		close(published)
+		b.expect(packetDisconnect)
+	})
+
+	client := dialTest(t, broker, nil)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Publish(context.Background(), Message{Topic: "a", Payload: []byte("x"), QoS: 1})
+	}()
+
+	<-published
+	client.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Publish returned %v, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish kept waiting after Close")
+	}
+}
+
+func TestSlowCallbackDoesNotBlockAcks(t *testing.T) {
+	topic, _ := appendString(nil, "go-vee/light/set")
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		// More messages than the client could ever hold while the callback waits
+		for i := 0; i < 200; i++ {
+			b.send(packet{kind: packetPublish, body: append(append([]byte{}, topic...), "ON"...)})
+		}
+
+		publish := b.expect(packetPublish)
+		b.send(packet{kind: packetPuback, body: publish.body[len(publish.body)-len("state")-2:][:2]})
+		b.drain()
+	})
+
+	var client *Client
+	ready := make(chan struct{})
+	errs := make(chan error, 1)
+	received := 0
+	client = dialTest(t, broker, func(msg Message) {
+		received++
+		if received == 1 {
+			<-ready
+			errs <- client.Publish(context.Background(), Message{Topic: "a", Payload: []byte("state"), QoS: 1})
+		}
+	})
+	close(ready)
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("Publish: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish from the callback never saw its PUBACK")
+	}
+}
+
+func TestFullQueueDropsWithoutAck(t *testing.T) {
+	topic, _ := appendString(nil, "go-vee/light/set")
+	publish := func(id uint16) packet {
+		body := binary.BigEndian.AppendUint16(append([]byte{}, topic...), id)
+		return packet{kind: packetPublish, flags: 0x02, body: append(body, "ON"...)}
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	broker := startBroker(t, 0, func(b *brokerConn) {
+		expectAck := func(want uint16) {
+			b.t.Helper()
+			if id := binary.BigEndian.Uint16(b.expect(packetPuback).body); id != want {
+				b.t.Errorf("PUBACK for packet %d, want %d", id, want)
+			}
+		}
+
+		// The first message is taken by the callback, which then blocks
+		b.send(publish(1))
+		expectAck(1)
+		<-started
+
+		// Two fit in the queue, the other two are dropped and never acknowledged
+		for id := uint16(2); id <= 5; id++ {
+			b.send(publish(id))
+		}
+		expectAck(2)
+		expectAck(3)
+
+		close(release)
+		b.send(publish(6))
+		expectAck(6)
+		b.drain()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	calls := 0
+	messages := make(chan struct{}, 10)
+	client, err := Dial(ctx, Options{Broker: broker, ClientID: "test", MaxQueued: 2, OnMessage: func(msg Message) {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+		}
+		messages <- struct{}{}
+	}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// Messages 1, 2, 3 and 6
+	for i := 0; i < 4; i++ {
+		select {
+		case <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnMessage called %d times, want 4", i)
+		}
+	}
+
+	select {
+	case <-messages:
+		t.Error("OnMessage called for a dropped message")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
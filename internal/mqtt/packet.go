@@ -0,0 +1,125 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types (MQTT 3.1.1, section 2.2.1)
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+const maxRemainingLength = 268435455
+
+// Strings and binary fields carry a two byte length prefix
+const maxFieldLength = 65535
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func appendString(buf []byte, s string) ([]byte, error) {
+	if len(s) > maxFieldLength {
+		return nil, fmt.Errorf("string too long: %d bytes", len(s))
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...), nil
+}
+
+func appendBytes(buf []byte, b []byte) ([]byte, error) {
+	if len(b) > maxFieldLength {
+		return nil, fmt.Errorf("field too long: %d bytes", len(b))
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(b)))
+	return append(buf, b...), nil
+}
+
+func appendRemainingLength(buf []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		buf = append(buf, digit)
+		if n == 0 {
+			return buf
+		}
+	}
+}
+
+func encodePacket(p packet) ([]byte, error) {
+	if len(p.body) > maxRemainingLength {
+		return nil, fmt.Errorf("packet too large: %d bytes", len(p.body))
+	}
+
+	buf := make([]byte, 0, len(p.body)+5)
+	buf = append(buf, p.kind<<4|p.flags&0x0f)
+	buf = appendRemainingLength(buf, len(p.body))
+	return append(buf, p.body...), nil
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errors.New("malformed remaining length")
+		}
+
+		digit, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// Reads a length prefixed string from the start of b and returns it with the remaining bytes
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("truncated string")
+	}
+
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("truncated string")
+	}
+
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
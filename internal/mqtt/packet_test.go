@@ -0,0 +1,140 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeReadPacket(t *testing.T) {
+	tests := []struct {
+		length       int
+		headerLength int
+	}{
+		{0, 2},
+		{127, 2},
+		{128, 3},
+		{16383, 3},
+		{16384, 4},
+		{2097152, 5},
+	}
+
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte{0xab}, tt.length)
+		data, err := encodePacket(packet{kind: packetPublish, flags: 0x03, body: body})
+		if err != nil {
+			t.Fatalf("length %d: encodePacket: %v", tt.length, err)
+		}
+
+		if got := len(data) - tt.length; got != tt.headerLength {
+			t.Errorf("length %d: header is %d bytes, want %d", tt.length, got, tt.headerLength)
+		}
+
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("length %d: readPacket: %v", tt.length, err)
+		}
+
+		if p.kind != packetPublish || p.flags != 0x03 || !bytes.Equal(p.body, body) {
+			t.Errorf("length %d: read kind %d flags %#x with %d bytes", tt.length, p.kind, p.flags, len(p.body))
+		}
+	}
+}
+
+func TestReadPacketMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"five byte remaining length", []byte{packetPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"truncated remaining length", []byte{packetPublish << 4, 0x80}},
+		{"truncated body", []byte{packetPublish << 4, 0x05, 0x00}},
+	}
+
+	for _, tt := range tests {
+		if _, err := readPacket(bufio.NewReader(bytes.NewReader(tt.data))); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestEncodePacketTooLarge(t *testing.T) {
+	if _, err := encodePacket(packet{kind: packetPublish, body: make([]byte, maxRemainingLength+1)}); err == nil {
+		t.Error("expected an error for a body over the maximum remaining length")
+	}
+}
+
+func TestAppendStringTooLong(t *testing.T) {
+	if _, err := appendString(nil, strings.Repeat("a", maxFieldLength)); err != nil {
+		t.Errorf("string of %d bytes: %v", maxFieldLength, err)
+	}
+
+	if _, err := appendString(nil, strings.Repeat("a", maxFieldLength+1)); err == nil {
+		t.Error("expected an error for a string over 65535 bytes")
+	}
+
+	if _, err := appendBytes(nil, make([]byte, maxFieldLength+1)); err == nil {
+		t.Error("expected an error for a field over 65535 bytes")
+	}
+}
+
+func TestDecodePublish(t *testing.T) {
+	topic, _ := appendString(nil, "go-vee/light/set")
+
+	tests := []struct {
+		name    string
+		flags   byte
+		body    []byte
+		want    Message
+		wantID  uint16
+		wantErr bool
+	}{
+		{
+			name:  "qos 0",
+			flags: 0x00,
+			body:  append(append([]byte{}, topic...), "ON"...),
+			want:  Message{Topic: "go-vee/light/set", Payload: []byte("ON")},
+		},
+		{
+			name:   "qos 1 retained",
+			flags:  0x03,
+			body:   append(append(append([]byte{}, topic...), 0x12, 0x34), "OFF"...),
+			want:   Message{Topic: "go-vee/light/set", Payload: []byte("OFF"), QoS: 1, Retain: true},
+			wantID: 0x1234,
+		},
+		{
+			name:    "qos 1 without packet identifier",
+			flags:   0x02,
+			body:    append(append([]byte{}, topic...), 0x12),
+			wantErr: true,
+		},
+		{
+			name:    "truncated topic",
+			body:    []byte{0x00, 0x10, 'g'},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		msg, id, err := decodePublish(packet{kind: packetPublish, flags: tt.flags, body: tt.body})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if msg.Topic != tt.want.Topic || !bytes.Equal(msg.Payload, tt.want.Payload) || msg.QoS != tt.want.QoS || msg.Retain != tt.want.Retain {
+			t.Errorf("%s: got %+v, want %+v", tt.name, msg, tt.want)
+		}
+
+		if id != tt.wantID {
+			t.Errorf("%s: packet identifier %#x, want %#x", tt.name, id, tt.wantID)
+		}
+	}
+}
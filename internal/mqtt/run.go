@@ -0,0 +1,62 @@
+package mqtt
+
+import (
+	"context"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+// Keeps a connection to the broker open until ctx is cancelled, reconnecting with exponential backoff.
+// onConnect runs after every successful connect and typically subscribes to topics.
+func Run(ctx context.Context, opts Options, onConnect func(ctx context.Context, c *Client) error) {
+	logger := logging.FromContext(ctx).With("broker", opts.Broker, "client_id", opts.ClientID)
+	delay := minReconnectDelay
+
+	for {
+		c, err := Dial(ctx, opts)
+		if err == nil {
+			err = onConnect(ctx, c)
+			if err != nil {
+				c.Close()
+			}
+		}
+
+		if err == nil {
+			logger.Info("Connected to MQTT broker")
+			delay = minReconnectDelay
+
+			select {
+			case <-ctx.Done():
+				if opts.BeforeDisconnect != nil {
+					closeCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+					opts.BeforeDisconnect(closeCtx, c)
+					cancel()
+				}
+				c.Close()
+				return
+			case <-c.Done():
+				err = c.Err()
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Warn("MQTT connection failed, reconnecting", "error", err, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
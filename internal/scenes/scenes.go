@@ -0,0 +1,338 @@
+// Package scenes stores named multi-device scenes on disk and activates them through the
+// Govee service.
+package scenes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/color"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+const (
+	CapabilityDynamicScene = "devices.capabilities.dynamic_scene"
+	InstanceLightScene     = "lightScene"
+)
+
+var (
+	ErrNotFound = errors.New("scene not found")
+	ErrExists   = errors.New("scene already exists")
+	ErrInvalid  = errors.New("invalid scene")
+
+	validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+)
+
+// A Govee dynamic scene as listed in the lightScene capability of a device
+type DynamicScene struct {
+	ID      int `json:"id"`
+	ParamID int `json:"paramId"`
+}
+
+// Target state of one device in a scene. A device is switched on unless On is false; brightness,
+// color and color temperature are optional. A dynamic scene is applied last and only works
+// through the cloud API.
+type Device struct {
+	SKU               string        `json:"sku"`
+	Device            string        `json:"device"`
+	On                *bool         `json:"on,omitempty"`
+	Brightness        int           `json:"brightness,omitempty"`
+	Color             interface{}   `json:"color,omitempty"`
+	ColorTemperatureK int           `json:"colorTemperatureK,omitempty"`
+	DynamicScene      *DynamicScene `json:"dynamicScene,omitempty"`
+}
+
+type Scene struct {
+	Name      string    `json:"name"`
+	Devices   []Device  `json:"devices"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Outcome of activating a scene on one device
+type Result struct {
+	SKU       string `json:"sku"`
+	Device    string `json:"device"`
+	Success   bool   `json:"success"`
+	Transport string `json:"transport,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+// Checks a scene and rewrites its colors as #rrggbb, accepting every format of the colorRgb
+// capability
+func (sc *Scene) normalize() error {
+	if !validName.MatchString(sc.Name) {
+		return fmt.Errorf("invalid scene name %q: use up to 64 letters, digits, '.', '_' or '-'", sc.Name)
+	}
+
+	if len(sc.Devices) == 0 {
+		return errors.New("a scene needs at least one device")
+	}
+
+	for i := range sc.Devices {
+		d := &sc.Devices[i]
+		if d.SKU == "" || d.Device == "" {
+			return fmt.Errorf("device %d: sku and device are required", i)
+		}
+
+		if d.Brightness < 0 || d.Brightness > 100 {
+			return fmt.Errorf("device %s: brightness must be between 1 and 100, or 0 to keep the current brightness", d.Device)
+		}
+
+		if d.Color != nil && d.ColorTemperatureK != 0 {
+			return fmt.Errorf("device %s: set either color or colorTemperatureK, not both", d.Device)
+		}
+
+		if d.Color != nil {
+			capability, err := service.NormalizeCapability(service.ControlCapability{
+				Type:     service.CapabilityColorSetting,
+				Instance: service.InstanceColorRGB,
+				Value:    d.Color,
+			})
+			if err != nil {
+				return fmt.Errorf("device %s: %w", d.Device, err)
+			}
+
+			d.Color = color.FromPacked(int(capability.Value.(float64))).Hex()
+		}
+
+		if d.ColorTemperatureK != 0 && (d.ColorTemperatureK < 1000 || d.ColorTemperatureK > 40000) {
+			return fmt.Errorf("device %s: colorTemperatureK must be between 1000 and 40000", d.Device)
+		}
+	}
+
+	return nil
+}
+
+// Returns the target as a device state for ApplyState and Transition
+func (d Device) state() service.DeviceState {
+	state := service.DeviceState{
+		On:                d.On == nil || *d.On,
+		Brightness:        d.Brightness,
+		ColorTemperatureK: d.ColorTemperatureK,
+	}
+
+	if hex, ok := d.Color.(string); ok {
+		if rgb, err := color.ParseHex(hex); err == nil {
+			state.Color = service.RGBColor{R: rgb.R, G: rgb.G, B: rgb.B}
+		}
+	}
+
+	return state
+}
+
+// Keeps scenes in memory and writes every change to a JSON file
+type Store struct {
+	path string
+
+	mu     sync.RWMutex
+	scenes map[string]Scene
+}
+
+// Opens the scene file at path. A missing file is created on the first change.
+func NewStore(path string) (*Store, error) {
+	store := &Store{
+		path:   path,
+		scenes: make(map[string]Scene),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading scene file: %w", err)
+	}
+
+	var scenes []Scene
+	if err := json.Unmarshal(data, &scenes); err != nil {
+		return nil, fmt.Errorf("parsing scene file %s: %w", path, err)
+	}
+
+	for _, scene := range scenes {
+		if err := scene.normalize(); err != nil {
+			return nil, fmt.Errorf("invalid scene in %s: %w", path, err)
+		}
+		store.scenes[scene.Name] = scene
+	}
+
+	return store, nil
+}
+
+// Writes all scenes to a temporary file and renames it over the scene file. Callers hold mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding scenes: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".scenes-*.json")
+	if err != nil {
+		return fmt.Errorf("writing scene file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing scene file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing scene file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing scene file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) sorted() []Scene {
+	scenes := make([]Scene, 0, len(s.scenes))
+	for _, scene := range s.scenes {
+		scenes = append(scenes, scene)
+	}
+
+	sort.Slice(scenes, func(i, j int) bool {
+		return scenes[i].Name < scenes[j].Name
+	})
+
+	return scenes
+}
+
+// Returns all scenes sorted by name
+func (s *Store) List() []Scene {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sorted()
+}
+
+func (s *Store) Get(name string) (Scene, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scene, ok := s.scenes[name]
+	if !ok {
+		return Scene{}, ErrNotFound
+	}
+
+	return scene, nil
+}
+
+// Creates a scene, or replaces an existing one when replace is set
+func (s *Store) Put(scene Scene, replace bool) (Scene, error) {
+	if err := scene.normalize(); err != nil {
+		return Scene{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.scenes[scene.Name]
+	if exists && !replace {
+		return Scene{}, ErrExists
+	}
+
+	now := time.Now()
+	scene.CreatedAt = now
+	if exists {
+		scene.CreatedAt = existing.CreatedAt
+	}
+	scene.UpdatedAt = now
+
+	s.scenes[scene.Name] = scene
+	if err := s.save(); err != nil {
+		if exists {
+			s.scenes[scene.Name] = existing
+		} else {
+			delete(s.scenes, scene.Name)
+		}
+		return Scene{}, err
+	}
+
+	return scene, nil
+}
+
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.scenes[name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.scenes, name)
+	if err := s.save(); err != nil {
+		s.scenes[name] = existing
+		return err
+	}
+
+	return nil
+}
+
+// Applies a scene to all of its devices concurrently. With a positive transition the brightness
+// and color fade from the current state; see GoveeService.Transition.
+func Activate(ctx context.Context, svc *service.GoveeService, scene Scene, transport string, transition time.Duration) []Result {
+	results := make([]Result, len(scene.Devices))
+
+	var wg sync.WaitGroup
+	for i, device := range scene.Devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i] = activateDevice(ctx, svc, device, transport, transition)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func activateDevice(ctx context.Context, svc *service.GoveeService, device Device, transport string, transition time.Duration) Result {
+	result := Result{SKU: device.SKU, Device: device.Device}
+	state := device.state()
+
+	used, err := svc.Transition(ctx, device.SKU, device.Device, state, transition, transport)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to apply scene to device", "device", device.Device, "error", err)
+		result.Transport = used
+		result.ErrorCode, result.Error = service.DescribeError(err)
+		return result
+	}
+	result.Transport = used
+
+	if device.DynamicScene != nil && state.On {
+		control, err := svc.ControlDevice(ctx, device.SKU, device.Device, service.ControlCapability{
+			Type:     CapabilityDynamicScene,
+			Instance: InstanceLightScene,
+			Value: map[string]interface{}{
+				"id":      device.DynamicScene.ID,
+				"paramId": device.DynamicScene.ParamID,
+			},
+		}, transport)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Failed to set dynamic scene", "device", device.Device, "error", err)
+			code, description := service.DescribeError(err)
+			result.ErrorCode, result.Error = code, "dynamic scene: "+description
+			return result
+		}
+		result.Transport = control.Transport
+	}
+
+	result.Success = true
+	return result
+}
@@ -0,0 +1,269 @@
+package scenes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EternityX/go-vee/internal/fakecloud"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+const (
+	strip  = "1F:80:C5:32:32:36:72:4E"
+	bulb   = "D7:B4:C1:38:B3:A1:46:D6"
+	socket = "3C:45:7A:52:B4:19:06:33"
+)
+
+func scene(name string, devices ...Device) Scene {
+	return Scene{Name: name, Devices: devices}
+}
+
+func TestNormalize(t *testing.T) {
+	device := Device{SKU: "H6022", Device: strip}
+
+	tests := []struct {
+		name  string
+		scene Scene
+		ok    bool
+	}{
+		{"plain device", scene("evening", device), true},
+		{"name with punctuation", scene("Movie_night.v2-b", device), true},
+		{"longest name", scene(strings.Repeat("a", 64), device), true},
+		{"name too long", scene(strings.Repeat("a", 65), device), false},
+		{"empty name", scene("", device), false},
+		{"name starting with a dash", scene("-evening", device), false},
+		{"name with a space", scene("movie night", device), false},
+		{"name with a slash", scene("a/b", device), false},
+		{"no devices", scene("evening"), false},
+		{"device without sku", scene("evening", Device{Device: strip}), false},
+		{"brightness to keep", scene("evening", Device{SKU: "H6022", Device: strip, Brightness: 0}), true},
+		{"full brightness", scene("evening", Device{SKU: "H6022", Device: strip, Brightness: 100}), true},
+		{"brightness too high", scene("evening", Device{SKU: "H6022", Device: strip, Brightness: 101}), false},
+		{"negative brightness", scene("evening", Device{SKU: "H6022", Device: strip, Brightness: -1}), false},
+		{"color temperature", scene("evening", Device{SKU: "H6022", Device: strip, ColorTemperatureK: 2700}), true},
+		{"color temperature too low", scene("evening", Device{SKU: "H6022", Device: strip, ColorTemperatureK: 999}), false},
+		{"color temperature too high", scene("evening", Device{SKU: "H6022", Device: strip, ColorTemperatureK: 40001}), false},
+		{"color and color temperature", scene("evening", Device{SKU: "H6022", Device: strip, Color: "#ff0000", ColorTemperatureK: 2700}), false},
+		{"invalid color", scene("evening", Device{SKU: "H6022", Device: strip, Color: "purple-ish"}), false},
+	}
+
+	for _, tt := range tests {
+		err := tt.scene.normalize()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeRewritesColors(t *testing.T) {
+	tests := []struct {
+		name  string
+		color interface{}
+		want  string
+	}{
+		{"hex", "#FF8000", "#ff8000"},
+		{"packed", float64(0xff8000), "#ff8000"},
+		{"rgb object", map[string]interface{}{"r": 255.0, "g": 128.0, "b": 0.0}, "#ff8000"},
+		{"hsl object", map[string]interface{}{"h": 0.0, "s": 100.0, "l": 50.0}, "#ff0000"},
+	}
+
+	for _, tt := range tests {
+		sc := scene("evening", Device{SKU: "H6022", Device: strip, Color: tt.color})
+		if err := sc.normalize(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := sc.Devices[0].Color; got != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenes.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	created, err := store.Put(scene("evening", Device{SKU: "H6022", Device: strip, Color: float64(0xff8000)}), false)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := store.Put(scene("evening", Device{SKU: "H6022", Device: strip}), false); !errors.Is(err, ErrExists) {
+		t.Errorf("creating an existing scene: %v, want %v", err, ErrExists)
+	}
+	if _, err := store.Put(scene("bad name", Device{SKU: "H6022", Device: strip}), false); !errors.Is(err, ErrInvalid) {
+		t.Errorf("creating an invalid scene: %v, want %v", err, ErrInvalid)
+	}
+
+	replaced, err := store.Put(scene("evening", Device{SKU: "H6022", Device: strip, Brightness: 40}), true)
+	if err != nil {
+		t.Fatalf("replacing the scene: %v", err)
+	}
+	if !replaced.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("replacing changed the creation time from %s to %s", created.CreatedAt, replaced.CreatedAt)
+	}
+
+	if _, err := store.Put(scene("morning", Device{SKU: "H5080", Device: socket}), false); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Delete("morning"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete("morning"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a deleted scene: %v, want %v", err, ErrNotFound)
+	}
+
+	// A new store reads back what the first one wrote
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("reopening the store: %v", err)
+	}
+
+	scenes := reopened.List()
+	if len(scenes) != 1 {
+		t.Fatalf("reopened store has %d scenes, want 1", len(scenes))
+	}
+	if got := scenes[0]; got.Name != "evening" || got.Devices[0].Brightness != 40 || got.Devices[0].Color != nil {
+		t.Errorf("reopened scene %+v, want the replaced evening scene", got)
+	}
+}
+
+func TestStoreRollsBackFailedSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "scenes")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(filepath.Join(dir, "scenes.json"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := store.Put(scene("evening", Device{SKU: "H6022", Device: strip, Brightness: 40}), false); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Without the directory, every save fails
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Put(scene("morning", Device{SKU: "H6022", Device: strip}), false); err == nil {
+		t.Error("creating a scene without a scene file succeeded")
+	}
+	if _, err := store.Put(scene("evening", Device{SKU: "H6022", Device: strip, Brightness: 80}), true); err == nil {
+		t.Error("replacing a scene without a scene file succeeded")
+	}
+	if err := store.Delete("evening"); err == nil {
+		t.Error("deleting a scene without a scene file succeeded")
+	}
+
+	scenes := store.List()
+	if len(scenes) != 1 || scenes[0].Name != "evening" || scenes[0].Devices[0].Brightness != 40 {
+		t.Errorf("scenes after failed saves %+v, want the evening scene unchanged", scenes)
+	}
+}
+
+// Serves the fixture devices and records the capability instances sent to each device
+func startCloud(t *testing.T) (*service.GoveeService, func() map[string][]string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	sent := make(map[string][]string)
+
+	fake := fakecloud.New(fakecloud.Options{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/router/api/v1/device/control" {
+			body, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			var request service.ControlRequest
+			if err := json.Unmarshal(body, &request); err == nil {
+				mu.Lock()
+				sent[request.Payload.Device] = append(sent[request.Payload.Device], request.Payload.Capability.Instance)
+				mu.Unlock()
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "key"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	return svc, func() map[string][]string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, instances := range sent {
+			sort.Strings(instances)
+		}
+		return sent
+	}
+}
+
+func TestActivate(t *testing.T) {
+	svc, sent := startCloud(t)
+	off := false
+
+	sc := scene("evening",
+		Device{SKU: "H6022", Device: strip, Brightness: 40, Color: "#ff8000"},
+		Device{SKU: "H6008", Device: bulb, On: &off},
+		Device{SKU: "H6022", Device: "AA:BB:CC:DD:EE:FF:00:11"},
+	)
+	if err := sc.normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+
+	results := Activate(context.Background(), svc, sc, service.TransportCloud, 0)
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want one per device", len(results))
+	}
+
+	// Results are in the order of the scene's devices
+	tests := []struct {
+		device    string
+		success   bool
+		errorCode string
+	}{
+		{strip, true, ""},
+		{bulb, true, ""},
+		{"AA:BB:CC:DD:EE:FF:00:11", false, "device_not_found"},
+	}
+
+	for i, tt := range tests {
+		result := results[i]
+		if result.Device != tt.device || result.Success != tt.success || result.ErrorCode != tt.errorCode {
+			t.Errorf("result %d: got %s success %v code %q, want %s success %v code %q",
+				i, result.Device, result.Success, result.ErrorCode, tt.device, tt.success, tt.errorCode)
+		}
+		if tt.success && result.Transport != service.TransportCloud {
+			t.Errorf("result %d: transport %q, want %s", i, result.Transport, service.TransportCloud)
+		}
+	}
+	if results[2].Error != "Device not found" {
+		t.Errorf("error of the unknown device %q, want the fixed description", results[2].Error)
+	}
+
+	// A device switched off gets only the power command
+	commands := sent()
+	if got := strings.Join(commands[strip], " "); got != "brightness colorRgb powerSwitch" {
+		t.Errorf("commands to the strip: %s, want brightness colorRgb powerSwitch", got)
+	}
+	if got := strings.Join(commands[bulb], " "); got != "powerSwitch" {
+		t.Errorf("commands to the bulb: %s, want powerSwitch", got)
+	}
+}
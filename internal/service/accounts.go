@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrNoAccounts = errors.New("no Govee API key configured: configure an account or send a Govee-API-Key header")
+
+// A Govee account whose API key is used for cloud calls
+type Account struct {
+	Name   string
+	APIKey string
+}
+
+type apiKeyContextKey struct{}
+
+// Returns a copy of ctx carrying a client supplied Govee API key, which takes precedence over configured accounts
+func WithAPIKey(ctx context.Context, apiKey string) context.Context {
+	if apiKey == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, apiKeyContextKey{}, apiKey)
+}
+
+// Returns the client supplied Govee API key stored in ctx, or an empty string
+func APIKeyFromContext(ctx context.Context) string {
+	apiKey, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return apiKey
+}
+
+func (s *GoveeService) accountByName(name string) (Account, bool) {
+	for _, account := range s.accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+
+	return Account{}, false
+}
+
+// Picks the account whose API key should be used to control a device
+func (s *GoveeService) accountForDevice(ctx context.Context, deviceID string) (Account, error) {
+	if apiKey := APIKeyFromContext(ctx); apiKey != "" {
+		return Account{Name: "request", APIKey: apiKey}, nil
+	}
+
+	switch len(s.accounts) {
+	case 0:
+		return Account{}, ErrNoAccounts
+	case 1:
+		return s.accounts[0], nil
+	}
+
+	s.mu.RLock()
+	owner, ok := s.owners[deviceID]
+	s.mu.RUnlock()
+
+	// Refresh the device lists to learn which account owns the device
+	if !ok {
+		if _, err := s.GetDevices(ctx); err != nil {
+			return Account{}, fmt.Errorf("looking up owner of device %s: %w", deviceID, err)
+		}
+
+		s.mu.RLock()
+		owner, ok = s.owners[deviceID]
+		s.mu.RUnlock()
+	}
+
+	if !ok {
+		return Account{}, fmt.Errorf("%w in any configured account: %s", ErrDeviceNotFound, deviceID)
+	}
+
+	account, _ := s.accountByName(owner)
+	return account, nil
+}
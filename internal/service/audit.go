@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// Records every control command with its outcome and every state change in store. Call it before the service is used.
+func (s *GoveeService) SetHistory(store *history.Store) {
+	s.history = store
+}
+
+// Appends a control command to the history with the actor carried by ctx. Every caller gets
+// its own entry, including callers whose command was coalesced into a newer one.
+func (s *GoveeService) recordControl(ctx context.Context, sku string, deviceID string, capability ControlCapability, transport string, result *ControlResult, err error) {
+	if s.history == nil {
+		return
+	}
+
+	if transport == "" {
+		transport = TransportAuto
+	}
+
+	actor := history.ActorFromContext(ctx)
+	success := err == nil
+	entry := history.Entry{
+		Kind:     history.KindControl,
+		SKU:      sku,
+		Device:   deviceID,
+		Origin:   actor.Origin,
+		SourceIP: actor.SourceIP,
+		Identity: actor.Identity,
+		Capability: &history.Capability{
+			Type:     capability.Type,
+			Instance: capability.Instance,
+			Value:    capability.Value,
+		},
+		RequestedTransport: transport,
+		Success:            &success,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Transport = result.Transport
+		entry.LANError = result.LANError
+		entry.LatencyMs = float64(result.Latency.Microseconds()) / 1000
+		entry.Coalesced = result.Coalesced
+	}
+
+	if err := s.history.Append(entry); err != nil {
+		logging.FromContext(ctx).Warn("Failed to record control command", "device", deviceID, "error", err)
+	}
+}
+
+// Appends a device.state_changed event to the history under the ID and time of the event
+func (s *GoveeService) recordStateChange(ctx context.Context, event events.Event) {
+	if s.history == nil {
+		return
+	}
+
+	state, err := json.Marshal(event.Data)
+	if err != nil {
+		return
+	}
+
+	err = s.history.Append(history.Entry{
+		ID:     event.ID,
+		Time:   event.Time,
+		Kind:   history.KindState,
+		SKU:    event.SKU,
+		Device: event.Device,
+		Source: event.Source,
+		State:  state,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to record state change", "device", event.Device, "error", err)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Returned without contacting the Govee API while the circuit breaker is open
+var ErrCircuitOpen = errors.New("govee api circuit breaker open")
+
+// Stops calls to the Govee API after too many consecutive upstream failures. After the cooldown
+// one probe call is let through; it closes the breaker when it succeeds and reopens it when it
+// fails.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError error
+
+	// Returns the current time; nil uses time.Now. Tests replace it to move time forward.
+	now func() time.Time
+}
+
+// A point-in-time view of the circuit breaker
+type BreakerStatus struct {
+	State     string
+	Failures  int
+	OpenUntil time.Time
+	LastError error
+}
+
+func (b *circuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// Reports whether a call may go out and whether it is the probe of a half-open breaker. The
+// caller hands probe back to record or abandon, so only the probe itself lets the next one
+// through. A threshold of 0 disables the breaker.
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return false, nil
+	}
+
+	openUntil := b.openedAt.Add(b.cooldown)
+	if b.clock().Before(openUntil) || b.probing {
+		return false, fmt.Errorf("%w until %s: %v", ErrCircuitOpen, openUntil.Format(time.RFC3339), b.lastError)
+	}
+
+	b.probing = true
+	return true, nil
+}
+
+// Records the outcome of a call. Only upstream failures count against the breaker; client
+// errors such as a rejected key or an unknown device mean the API is reachable. A call that
+// started before the breaker opened is counted but leaves a probe in flight alone.
+func (b *circuitBreaker) record(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	if err == nil || !errors.Is(err, ErrUpstreamUnavailable) {
+		b.failures = 0
+		b.lastError = nil
+		return
+	}
+
+	b.failures++
+	b.lastError = err
+	if b.failures >= b.threshold {
+		b.openedAt = b.clock()
+	}
+}
+
+// Forgets a call whose caller gave up, so a cancelled probe does not keep the breaker waiting
+func (b *circuitBreaker) abandon(probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: BreakerClosed, Failures: b.failures, LastError: b.lastError}
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return status
+	}
+
+	status.OpenUntil = b.openedAt.Add(b.cooldown)
+	if b.clock().Before(status.OpenUntil) {
+		status.State = BreakerOpen
+	} else {
+		status.State = BreakerHalfOpen
+	}
+
+	return status
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// A clock that only moves when the test advances it
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*circuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: clock.Now}, clock
+}
+
+var errUpstream = fmt.Errorf("%w: connection refused", ErrUpstreamUnavailable)
+
+// Fails threshold calls in a row so the breaker opens
+func openBreaker(t *testing.T, b *circuitBreaker) {
+	t.Helper()
+
+	for i := 0; i < b.threshold; i++ {
+		if _, err := b.allow(); err != nil {
+			t.Fatalf("call %d before the threshold: %v", i+1, err)
+		}
+		b.record(false, errUpstream)
+	}
+
+	if got := b.status().State; got != BreakerOpen {
+		t.Fatalf("state after %d failures is %s, want %s", b.threshold, got, BreakerOpen)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(3, time.Minute)
+
+	// Failures below the threshold and other errors keep the breaker closed
+	b.record(false, errUpstream)
+	b.record(false, errUpstream)
+	b.record(false, ErrDeviceNotFound)
+	b.record(false, errUpstream)
+	if status := b.status(); status.State != BreakerClosed || status.Failures != 1 {
+		t.Fatalf("status %+v, want closed with 1 failure", status)
+	}
+
+	b.record(false, nil)
+	openBreaker(t, b)
+
+	_, err := b.allow()
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow while open: %v, want %v", err, ErrCircuitOpen)
+	}
+	if !errors.Is(b.status().LastError, ErrUpstreamUnavailable) {
+		t.Errorf("last error %v, want the upstream failure", b.status().LastError)
+	}
+}
+
+func TestBreakerProbeCloses(t *testing.T) {
+	b, clock := newTestBreaker(2, time.Minute)
+	openBreaker(t, b)
+
+	clock.advance(59 * time.Second)
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow before the cooldown ended: %v", err)
+	}
+
+	clock.advance(time.Second)
+	if got := b.status().State; got != BreakerHalfOpen {
+		t.Fatalf("state after the cooldown is %s, want %s", got, BreakerHalfOpen)
+	}
+
+	// Exactly one probe goes out; calls made while it is in flight are refused
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after the cooldown: probe %v, error %v, want the probe", probe, err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second call during the probe: %v, want %v", err, ErrCircuitOpen)
+	}
+
+	b.record(probe, nil)
+	if status := b.status(); status.State != BreakerClosed || status.Failures != 0 || status.LastError != nil {
+		t.Fatalf("status after a successful probe %+v, want closed", status)
+	}
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("allow after the probe succeeded: %v", err)
+	}
+}
+
+func TestBreakerProbeReopens(t *testing.T) {
+	b, clock := newTestBreaker(2, time.Minute)
+	openBreaker(t, b)
+
+	clock.advance(time.Minute)
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after the cooldown: probe %v, error %v, want the probe", probe, err)
+	}
+	b.record(probe, errUpstream)
+
+	// A failed probe starts a new cooldown
+	status := b.status()
+	if status.State != BreakerOpen || !status.OpenUntil.Equal(clock.now.Add(time.Minute)) {
+		t.Fatalf("status after a failed probe %+v, want open until %s", status, clock.now.Add(time.Minute))
+	}
+
+	clock.advance(30 * time.Second)
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow during the new cooldown: %v", err)
+	}
+
+	clock.advance(30 * time.Second)
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("second probe refused: %v", err)
+	}
+}
+
+func TestBreakerAbandonedProbe(t *testing.T) {
+	b, clock := newTestBreaker(1, time.Minute)
+	openBreaker(t, b)
+	clock.advance(time.Minute)
+
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after the cooldown: probe %v, error %v, want the probe", probe, err)
+	}
+
+	// A probe whose caller gave up lets the next call probe instead
+	b.abandon(probe)
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("probe after an abandoned probe refused: %v", err)
+	}
+}
+
+func TestBreakerOneProbeWhileHalfOpen(t *testing.T) {
+	b, clock := newTestBreaker(2, time.Minute)
+
+	// A call that goes out before the breaker opens and is still in flight after the cooldown
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("allow while closed: %v", err)
+	}
+	openBreaker(t, b)
+	clock.advance(time.Minute)
+
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after the cooldown: probe %v, error %v, want the probe", probe, err)
+	}
+
+	// The late call being given up does not let a second probe through
+	b.abandon(false)
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow while the probe is in flight: %v, want %v", err, ErrCircuitOpen)
+	}
+
+	b.record(probe, nil)
+	if got := b.status().State; got != BreakerClosed {
+		t.Errorf("state after the probe succeeded is %s, want %s", got, BreakerClosed)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b, _ := newTestBreaker(0, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		b.record(false, errUpstream)
+	}
+
+	if _, err := b.allow(); err != nil {
+		t.Errorf("allow with the breaker disabled: %v", err)
+	}
+	if got := b.status().State; got != BreakerClosed {
+		t.Errorf("state with the breaker disabled is %s, want %s", got, BreakerClosed)
+	}
+}
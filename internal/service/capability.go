@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/EternityX/go-vee/internal/color"
+)
+
+const (
+	CapabilityColorSetting = "devices.capabilities.color_setting"
+	InstanceColorRGB       = "colorRgb"
+	InstanceColorTemp      = "colorTemperatureK"
+)
+
+var ErrInvalidValue = errors.New("invalid capability value")
+
+// Rewrites the value of color capabilities into the form Govee devices understand: a packed
+// 0xRRGGBB integer for colorRgb and a Kelvin integer for colorTemperatureK. Other capabilities
+// are returned unchanged.
+//
+// colorRgb accepts a packed integer, any string understood by color.Parse (hex, CSS names,
+// rgb(), hsl(), hsv() and "2700K") or an object with r/g/b, h/s/v, h/s/l (saturation,
+// value and lightness in percent) or kelvin fields.
+func NormalizeCapability(capability ControlCapability) (ControlCapability, error) {
+	if capability.Type != CapabilityColorSetting {
+		return capability, nil
+	}
+
+	switch capability.Instance {
+	case InstanceColorRGB:
+		rgb, err := colorValue(capability.Value)
+		if err != nil {
+			return capability, fmt.Errorf("%w: %w", ErrInvalidValue, err)
+		}
+
+		capability.Value = float64(rgb.Packed())
+	case InstanceColorTemp:
+		kelvin, err := kelvinValue(capability.Value)
+		if err != nil {
+			return capability, fmt.Errorf("%w: %w", ErrInvalidValue, err)
+		}
+
+		capability.Value = float64(kelvin)
+	}
+
+	return capability, nil
+}
+
+func colorValue(value interface{}) (color.RGB, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 || v > 0xFFFFFF || v != math.Trunc(v) {
+			return color.RGB{}, fmt.Errorf("packed color %v is out of range 0-16777215", v)
+		}
+		return color.FromPacked(int(v)), nil
+	case int:
+		return colorValue(float64(v))
+	case string:
+		return color.Parse(v)
+	case color.RGB:
+		return v, validRGB(v)
+	case RGBColor:
+		rgb := color.RGB{R: v.R, G: v.G, B: v.B}
+		return rgb, validRGB(rgb)
+	case map[string]interface{}:
+		return colorObject(v)
+	}
+
+	return color.RGB{}, fmt.Errorf("unsupported color value %v", value)
+}
+
+// Reads a color object with r/g/b, h/s/v, h/s/l or kelvin fields
+func colorObject(object map[string]interface{}) (color.RGB, error) {
+	fields := make(map[string]float64, len(object))
+	for key, raw := range object {
+		number, ok := raw.(float64)
+		if !ok {
+			return color.RGB{}, fmt.Errorf("color field %q must be a number", key)
+		}
+		fields[strings.ToLower(key)] = number
+	}
+
+	has := func(keys ...string) bool {
+		if len(fields) != len(keys) {
+			return false
+		}
+		for _, key := range keys {
+			if _, ok := fields[key]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case has("r", "g", "b"):
+		rgb := color.RGB{R: int(fields["r"]), G: int(fields["g"]), B: int(fields["b"])}
+		return rgb, validRGB(rgb)
+	case has("h", "s", "v"):
+		return color.FromHSV(fields["h"], fields["s"]/100, fields["v"]/100), nil
+	case has("h", "s", "l"):
+		return color.FromHSL(fields["h"], fields["s"]/100, fields["l"]/100), nil
+	case has("kelvin"):
+		kelvin, err := kelvinValue(fields["kelvin"])
+		if err != nil {
+			return color.RGB{}, err
+		}
+		return color.FromKelvin(kelvin), nil
+	}
+
+	return color.RGB{}, errors.New("color object needs r/g/b, h/s/v, h/s/l or kelvin fields")
+}
+
+func validRGB(rgb color.RGB) error {
+	for _, channel := range []int{rgb.R, rgb.G, rgb.B} {
+		if channel < 0 || channel > 255 {
+			return fmt.Errorf("color channel %d is out of range 0-255", channel)
+		}
+	}
+
+	return nil
+}
+
+func kelvinValue(value interface{}) (int, error) {
+	var kelvin float64
+
+	switch v := value.(type) {
+	case float64:
+		kelvin = v
+	case int:
+		kelvin = float64(v)
+	case string:
+		parsed, err := strconv.Atoi(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "K"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid color temperature %q", v)
+		}
+		kelvin = float64(parsed)
+	default:
+		return 0, fmt.Errorf("unsupported color temperature %v", value)
+	}
+
+	if kelvin < 1000 || kelvin > 40000 {
+		return 0, fmt.Errorf("color temperature %vK is out of range 1000-40000", kelvin)
+	}
+
+	return int(math.Round(kelvin)), nil
+}
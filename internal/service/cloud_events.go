@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/mqtt"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultEventBroker = "mqtts://mqtt.openapi.govee.com:8883"
+
+	CapabilityEvent = "devices.capabilities.event"
+)
+
+type EventState struct {
+	Name    string      `json:"name"`
+	Value   interface{} `json:"value"`
+	Message string      `json:"message,omitempty"`
+}
+
+// Message pushed by the Govee event broker
+type EventPayload struct {
+	SKU          string `json:"sku"`
+	Device       string `json:"device"`
+	DeviceName   string `json:"deviceName"`
+	Capabilities []struct {
+		Type     string       `json:"type"`
+		Instance string       `json:"instance"`
+		State    []EventState `json:"state"`
+	} `json:"capabilities"`
+}
+
+// Data of a device.event published on the bus
+type DeviceEventData struct {
+	DeviceName string       `json:"deviceName,omitempty"`
+	Account    string       `json:"account,omitempty"`
+	Instance   string       `json:"instance"`
+	State      []EventState `json:"state"`
+}
+
+// Returns the bus that device events are published on
+func (s *GoveeService) Events() *events.Bus {
+	return s.events
+}
+
+// Converts a Govee event broker message into bus events, one per event capability
+func ParseCloudEvent(payload []byte) ([]events.Event, error) {
+	var msg EventPayload
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, fmt.Errorf("parsing event payload: %w", err)
+	}
+
+	var result []events.Event
+	for _, capability := range msg.Capabilities {
+		if capability.Type != CapabilityEvent {
+			continue
+		}
+
+		result = append(result, events.Event{
+			Type:       events.TypeDeviceEvent,
+			Source:     "cloud",
+			SKU:        msg.SKU,
+			Device:     msg.Device,
+			Capability: capability.Type,
+			Instance:   capability.Instance,
+			Data: DeviceEventData{
+				DeviceName: msg.DeviceName,
+				Instance:   capability.Instance,
+				State:      capability.State,
+			},
+		})
+	}
+
+	return result, nil
+}
+
+// Subscribes to the Govee event broker for every configured account and publishes the events
+// on the bus until ctx is cancelled
+func (s *GoveeService) RunCloudEvents(ctx context.Context, broker string) {
+	logger := logging.FromContext(ctx)
+
+	if len(s.accounts) == 0 {
+		logger.Warn("Cloud events enabled but no Govee accounts are configured")
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, account := range s.accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runAccountEvents(ctx, broker, account)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *GoveeService) runAccountEvents(ctx context.Context, broker string, account Account) {
+	logger := logging.FromContext(ctx).With("account", account.Name)
+	topic := "GA/" + account.APIKey
+
+	opts := mqtt.Options{
+		Broker:   broker,
+		ClientID: "go-vee-" + uuid.New().String(),
+		Username: account.APIKey,
+		Password: account.APIKey,
+		OnMessage: func(msg mqtt.Message) {
+			parsed, err := ParseCloudEvent(msg.Payload)
+			if err != nil {
+				logger.Warn("Ignoring malformed cloud event", "error", err)
+				return
+			}
+
+			for _, event := range parsed {
+				data := event.Data.(DeviceEventData)
+				data.Account = account.Name
+				event.Data = data
+
+				logger.Info("Received cloud event", "device", event.Device, "instance", data.Instance)
+				s.events.Publish(event)
+			}
+		},
+	}
+
+	mqtt.Run(ctx, opts, func(ctx context.Context, c *mqtt.Client) error {
+		return c.Subscribe(ctx, topic, 1)
+	})
+}
@@ -0,0 +1,113 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+func TestParseCloudEvent(t *testing.T) {
+	payload := `{
+		"sku": "H7172",
+		"device": "AA:00:00:00:00:00:00:01",
+		"deviceName": "Ice Maker",
+		"capabilities": [
+			{
+				"type": "devices.capabilities.event",
+				"instance": "lackWaterEvent",
+				"state": [{"name": "lack", "value": 1, "message": "Lack of Water"}]
+			},
+			{
+				"type": "devices.capabilities.online",
+				"instance": "online",
+				"state": [{"name": "online", "value": true}]
+			},
+			{
+				"type": "devices.capabilities.event",
+				"instance": "iceFullEvent",
+				"state": [{"name": "full", "value": 1}]
+			}
+		]
+	}`
+
+	parsed, err := service.ParseCloudEvent([]byte(payload))
+	if err != nil {
+		t.Fatalf("ParseCloudEvent: %v", err)
+	}
+
+	// Only event capabilities become events
+	if len(parsed) != 2 {
+		t.Fatalf("got %d events, want 2", len(parsed))
+	}
+
+	event := parsed[0]
+	if event.Type != events.TypeDeviceEvent || event.Source != "cloud" || event.SKU != "H7172" ||
+		event.Device != "AA:00:00:00:00:00:00:01" || event.Capability != service.CapabilityEvent || event.Instance != "lackWaterEvent" {
+		t.Errorf("first event %+v", event)
+	}
+
+	data, ok := event.Data.(service.DeviceEventData)
+	if !ok {
+		t.Fatalf("event data is %T, want DeviceEventData", event.Data)
+	}
+	if data.DeviceName != "Ice Maker" || data.Instance != "lackWaterEvent" || len(data.State) != 1 {
+		t.Errorf("first event data %+v", data)
+	}
+	if state := data.State[0]; state.Name != "lack" || state.Value != float64(1) || state.Message != "Lack of Water" {
+		t.Errorf("first event state %+v", state)
+	}
+
+	if parsed[1].Instance != "iceFullEvent" {
+		t.Errorf("second event instance %s, want iceFullEvent", parsed[1].Instance)
+	}
+}
+
+func TestParseCloudEventUnknownSKU(t *testing.T) {
+	payload := `{"sku":"H9999","device":"AA:00:00:00:00:00:00:02","capabilities":[{"type":"devices.capabilities.event","instance":"bodyAppearedEvent","state":[{"name":"Presence","value":1}]}]}`
+
+	parsed, err := service.ParseCloudEvent([]byte(payload))
+	if err != nil {
+		t.Fatalf("ParseCloudEvent: %v", err)
+	}
+
+	// SKUs are passed through as sent, so devices go-vee does not know about still publish events
+	if len(parsed) != 1 || parsed[0].SKU != "H9999" || parsed[0].Instance != "bodyAppearedEvent" {
+		t.Errorf("got %+v", parsed)
+	}
+}
+
+func TestParseCloudEventWithoutEventCapability(t *testing.T) {
+	payloads := []string{
+		`{"sku":"H7172","device":"AA:00:00:00:00:00:00:01"}`,
+		`{"sku":"H7172","device":"AA:00:00:00:00:00:00:01","capabilities":[]}`,
+		`{"sku":"H7172","device":"AA:00:00:00:00:00:00:01","capabilities":[{"instance":"lackWaterEvent"}]}`,
+	}
+
+	for _, payload := range payloads {
+		parsed, err := service.ParseCloudEvent([]byte(payload))
+		if err != nil {
+			t.Errorf("%s: %v", payload, err)
+			continue
+		}
+		if len(parsed) != 0 {
+			t.Errorf("%s: got %d events, want none", payload, len(parsed))
+		}
+	}
+}
+
+func TestParseCloudEventMalformed(t *testing.T) {
+	payloads := []string{
+		``,
+		`not json`,
+		`{"sku":"H7172","capabilities":`,
+		`{"sku":"H7172","capabilities":{"type":"devices.capabilities.event"}}`,
+		`["devices.capabilities.event"]`,
+	}
+
+	for _, payload := range payloads {
+		if parsed, err := service.ParseCloudEvent([]byte(payload)); err == nil {
+			t.Errorf("%q: got %+v, want an error", payload, parsed)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service/lan"
+)
+
+// Number of consecutive scans a device may miss before it is considered gone.
+// Scan replies are UDP, so a single missed reply is not enough.
+const lanMissesBeforeGone = 2
+
+// Data of lan.device.appeared and lan.device.disappeared events
+type LANDeviceEventData struct {
+	IP string `json:"ip"`
+}
+
+// A LAN device with a known address. Static devices are never scanned for and never disappear.
+type StaticDevice struct {
+	IP     string
+	Device string
+	SKU    string
+}
+
+// Sets where discovery scans for devices and registers static devices. Call it before any
+// discovery runs.
+func (s *GoveeService) ConfigureLAN(opts lan.DiscoverOptions, static []StaticDevice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lanOptions = opts
+
+	for _, device := range static {
+		var scan lan.ScanResponse
+		scan.Msg.Cmd = "scan"
+		scan.Msg.Data.IP = device.IP
+		scan.Msg.Data.Device = device.Device
+		scan.Msg.Data.SKU = device.SKU
+
+		s.lanDevices[device.Device] = scan
+		s.staticLAN[device.Device] = true
+	}
+}
+
+// Runs LAN discovery, updating the device cache and the discovery status used by readiness
+func (s *GoveeService) DiscoverLANDevices(ctx context.Context) ([]lan.ScanResponse, error) {
+	s.discoveryMu.Lock()
+	defer s.discoveryMu.Unlock()
+
+	s.mu.RLock()
+	opts := s.lanOptions
+	s.mu.RUnlock()
+
+	devices, err := lan.Discover(ctx, 2*time.Second, opts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lanLastError = err
+	if err != nil {
+		return nil, err
+	}
+
+	s.lanDiscoveredAt = time.Now()
+
+	seen := make(map[string]bool, len(devices))
+	for i, device := range devices {
+		id := device.Msg.Data.Device
+		seen[id] = true
+
+		// The configured address of a static device wins over the one it reports
+		if s.staticLAN[id] {
+			devices[i] = s.lanDevices[id]
+			continue
+		}
+		delete(s.lanMisses, id)
+
+		if _, known := s.lanDevices[id]; !known {
+			s.publishLANEvent(events.TypeDeviceAppeared, device)
+		}
+		s.lanDevices[id] = device
+	}
+
+	for id, device := range s.lanDevices {
+		if s.staticLAN[id] {
+			if !seen[id] {
+				devices = append(devices, device)
+			}
+			continue
+		}
+
+		if seen[id] {
+			continue
+		}
+
+		s.lanMisses[id]++
+		if s.lanMisses[id] >= lanMissesBeforeGone {
+			delete(s.lanDevices, id)
+			delete(s.lanMisses, id)
+			s.publishLANEvent(events.TypeDeviceDisappeared, device)
+		}
+	}
+
+	return devices, nil
+}
+
+func (s *GoveeService) publishLANEvent(eventType string, device lan.ScanResponse) {
+	s.events.Publish(events.Event{
+		Type:   eventType,
+		Source: "lan",
+		SKU:    device.Msg.Data.SKU,
+		Device: device.Msg.Data.Device,
+		Data:   LANDeviceEventData{IP: device.Msg.Data.IP},
+	})
+}
+
+// Returns the devices found by recent LAN scans
+func (s *GoveeService) CachedLANDevices() []lan.ScanResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]lan.ScanResponse, 0, len(s.lanDevices))
+	for _, device := range s.lanDevices {
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+// Runs LAN discovery immediately and then at every interval until ctx is cancelled
+func (s *GoveeService) RunDiscovery(ctx context.Context, interval time.Duration) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		devices, err := s.DiscoverLANDevices(ctx)
+		if err != nil {
+			logger.Warn("Background LAN discovery failed", "error", err)
+		} else {
+			logger.Debug("Background LAN discovery finished", "devices", len(devices))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error categories of the service, told apart with errors.Is. An error can match more than one:
+// an APIError may fit several categories, and LAN control failures wrap the cause, such as
+// ErrDeviceNotFound. Callers that map categories check them from the most specific to the most
+// general, as the HTTP handlers do.
+var (
+	ErrUnauthorized          = errors.New("govee api key rejected")
+	ErrDeviceNotFound        = errors.New("device not found")
+	ErrUnsupportedCapability = errors.New("capability not supported")
+	ErrRateLimited           = errors.New("govee api rate limit reached")
+	ErrDeviceOffline         = errors.New("device offline")
+	ErrLANTimeout            = errors.New("lan device did not respond")
+	ErrUpstreamUnavailable   = errors.New("govee api unavailable")
+)
+
+// An error response of the Govee cloud API. Status is the HTTP status, or the code in the body
+// when Govee answered 200 with an error code.
+type APIError struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("govee api returned status %d: %s", e.Status, e.Message)
+}
+
+// Maps the response to an error category. Govee reports unknown devices and unsupported
+// commands as 400 with a message, so those are told apart by the message.
+func (e *APIError) Is(target error) bool {
+	message := strings.ToLower(e.Message)
+
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == 401 || e.Status == 403
+	case ErrRateLimited:
+		return e.Status == 429
+	case ErrUpstreamUnavailable:
+		return e.Status >= 500
+	case ErrDeviceNotFound:
+		return e.Status == 404 || (e.Status == 400 && strings.Contains(message, "not exist"))
+	case ErrDeviceOffline:
+		return strings.Contains(message, "offline")
+	case ErrUnsupportedCapability:
+		return e.Status == 400 && (strings.Contains(message, "not support") || strings.Contains(message, "cannot be controlled"))
+	case ErrInvalidValue:
+		return e.Status == 400 && (strings.Contains(message, "parameter") || strings.Contains(message, "out of range"))
+	}
+
+	return false
+}
+
+// Longest message an APIError keeps from a Govee response. Errors end up in the logs, and a body
+// that is not a Govee error, such as the HTML page of a proxy, can be arbitrarily long.
+const maxAPIErrorMessage = 200
+
+// Cuts a message to maxAPIErrorMessage bytes without splitting a character
+func truncateMessage(message string) string {
+	if len(message) <= maxAPIErrorMessage {
+		return message
+	}
+	return strings.ToValidUTF8(message[:maxAPIErrorMessage], "") + "..."
+}
+
+// Builds an APIError from an HTTP response, taking the message from a JSON error body when
+// there is one. Other bodies are kept truncated.
+func newAPIError(status int, body []byte, retryAfter string) *APIError {
+	apiErr := &APIError{Status: status, Message: strings.TrimSpace(string(body))}
+
+	var parsed struct {
+		Message string `json:"message"`
+		Msg     string `json:"msg"`
+	}
+	if jsonErr := json.Unmarshal(body, &parsed); jsonErr == nil {
+		if parsed.Message != "" {
+			apiErr.Message = parsed.Message
+		} else if parsed.Msg != "" {
+			apiErr.Message = parsed.Msg
+		}
+	}
+	apiErr.Message = truncateMessage(apiErr.Message)
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return apiErr
+}
+
+// Error code reported for errors outside the categories, such as a failure to read a file
+const ErrorCodeInternal = "internal_server_error"
+
+// Stable error codes and fixed descriptions of the error categories, reported to clients in
+// error responses and per-device results. An error can match several entries, so the first
+// match wins and the order is the precedence: request validation, then credentials and rate
+// limits, then device and capability errors, then upstream and LAN failures. A LAN failure for
+// an unknown device is therefore device_not_found, not lan_control_failed.
+var errorCodes = []struct {
+	err         error
+	code        string
+	description string
+}{
+	{ErrInvalidTransport, "invalid_transport", "Invalid transport: expected auto, lan or cloud"},
+	{ErrInvalidValue, "invalid_value", "The Govee API rejected a value of the request"},
+	{ErrNoAccounts, "missing_api_key", "No Govee API key configured: configure an account or send a Govee-API-Key header"},
+	{ErrUnauthorized, "govee_unauthorized", "The Govee API rejected the API key"},
+	{ErrRateLimited, "rate_limited", "The Govee API rate limit was reached"},
+	{ErrDeviceOffline, "device_offline", "The device is offline"},
+	{ErrLANTimeout, "lan_timeout", "The device did not answer on the LAN"},
+	{ErrDeviceNotFound, "device_not_found", "Device not found"},
+	{ErrUnsupportedCapability, "unsupported_capability", "The device does not support the capability"},
+	{ErrCircuitOpen, "circuit_open", "The Govee API is failing, so the call was not attempted"},
+	{ErrUpstreamUnavailable, "upstream_unavailable", "The Govee API is unavailable"},
+	{ErrLANControl, "lan_control_failed", "The device could not be controlled over the LAN"},
+}
+
+// Returns the error code and the description of err to report to a client. Descriptions are
+// fixed, so nothing from a Govee response reaches the client; only invalid values rejected by
+// go-vee itself keep their message, which names the problem with the request. Errors outside
+// the categories are reported as ErrorCodeInternal with a generic description, and callers log
+// the error itself.
+func DescribeError(err error) (code string, description string) {
+	for _, known := range errorCodes {
+		if !errors.Is(err, known.err) {
+			continue
+		}
+
+		var apiErr *APIError
+		if known.err == ErrInvalidValue && !errors.As(err, &apiErr) {
+			return known.code, err.Error()
+		}
+		return known.code, known.description
+	}
+
+	return ErrorCodeInternal, "Internal error"
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAPIError(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("Bad gateway ", 100) + "</body></html>"
+
+	tests := []struct {
+		name       string
+		body       string
+		retryAfter string
+		message    string
+		wait       time.Duration
+	}{
+		{"message field", `{"code": 400, "message": "devices not exist"}`, "", "devices not exist", 0},
+		{"msg field", `{"code": 429, "msg": "too many requests"}`, "30", "too many requests", 30 * time.Second},
+		{"plain text", "  upstream down\n", "soon", "upstream down", 0},
+		{"long page", page, "", page[:maxAPIErrorMessage] + "...", 0},
+		{"cut inside a character", strings.Repeat("a", maxAPIErrorMessage-1) + "é", "", strings.Repeat("a", maxAPIErrorMessage-1) + "...", 0},
+	}
+
+	for _, tt := range tests {
+		err := newAPIError(502, []byte(tt.body), tt.retryAfter)
+		if err.Message != tt.message || err.RetryAfter != tt.wait {
+			t.Errorf("%s: got %q retry after %s, want %q retry after %s", tt.name, err.Message, err.RetryAfter, tt.message, tt.wait)
+		}
+	}
+}
@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/EternityX/go-vee/internal/color"
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/history"
+	"github.com/EternityX/go-vee/internal/logging"
 	"github.com/EternityX/go-vee/internal/service/lan"
 	"github.com/google/uuid"
 )
 
+const DefaultCloudURL = "https://openapi.api.govee.com"
+
 const (
 	DeviceTypeLight         = "devices.types.light"
 	DeviceTypeAirPurifier   = "devices.types.air_purifier"
@@ -29,10 +38,48 @@ const (
 )
 
 type GoveeService struct {
-	client  *http.Client
-	apiKey  string
-	baseURL string
-	useLAN  bool
+	client   *http.Client
+	accounts []Account
+	baseURL  string
+	useLAN   bool
+	policy   CloudPolicy
+	breaker  *circuitBreaker
+
+	mu               sync.RWMutex
+	owners           map[string]string // device ID -> account name
+	lanDevices       map[string]lan.ScanResponse
+	lanMisses        map[string]int
+	lanOptions       lan.DiscoverOptions
+	staticLAN        map[string]bool
+	states           map[string]DeviceState
+	lanDiscoveredAt  time.Time
+	lanLastError     error
+	cloudCheckResult Check
+	cloudDevices     []Device
+	cloudFetchedAt   time.Time
+
+	// Discovery binds the fixed response port, so only one scan may run at a time
+	discoveryMu sync.Mutex
+
+	// Held while the readiness probe checks the cloud credentials
+	cloudCheckMu sync.Mutex
+
+	queuesMu      sync.Mutex
+	queues        map[string]*deviceQueue // device ID -> pending commands
+	lanCommandGap time.Duration
+
+	snapshotsMu sync.Mutex
+	snapshots   map[string]Snapshot
+
+	readingsMu     sync.Mutex
+	readings       map[string][]Reading // device ID -> readings, oldest first
+	readingHistory int
+	alertRules     []AlertRule
+	alertStates    map[string]*AlertState // rule name + "/" + device ID -> state
+
+	history *history.Store
+
+	events *events.Bus
 }
 
 type CapabilityParameter struct {
@@ -85,6 +132,7 @@ type Device struct {
 	DeviceName   string       `json:"deviceName"`
 	Type         string       `json:"type"`
 	Capabilities []Capability `json:"capabilities"`
+	Account      string       `json:"account,omitempty"`
 }
 
 type DeviceResponse struct {
@@ -121,165 +169,419 @@ type ControlResponse struct {
 	Message string `json:"message"`
 }
 
-func NewGoveeService(apiKey string, useLAN bool) *GoveeService {
-	return &GoveeService{
-		client:  &http.Client{},
-		apiKey:  apiKey,
-		baseURL: "https://openapi.api.govee.com",
-		useLAN:  useLAN,
+const (
+	TransportAuto  = "auto"
+	TransportLAN   = "lan"
+	TransportCloud = "cloud"
+)
+
+var (
+	ErrInvalidTransport = errors.New("invalid transport: expected auto, lan or cloud")
+	ErrLANControl       = errors.New("lan control failed")
+)
+
+// Describes how a control command was delivered
+type ControlResult struct {
+	Transport string
+	LANError  string
+	Latency   time.Duration
+	// Number of earlier commands for the same capability that this one replaced while queued
+	Coalesced int
+}
+
+func NewGoveeService(accounts []Account, useLAN bool) *GoveeService {
+	s := &GoveeService{
+		client:     &http.Client{},
+		accounts:   accounts,
+		baseURL:    DefaultCloudURL,
+		useLAN:     useLAN,
+		owners:     make(map[string]string),
+		lanDevices: make(map[string]lan.ScanResponse),
+		lanMisses:  make(map[string]int),
+		staticLAN:  make(map[string]bool),
+		states:     make(map[string]DeviceState),
+		snapshots:  make(map[string]Snapshot),
+		queues:     make(map[string]*deviceQueue),
+		readings:   make(map[string][]Reading),
+		events:     events.NewBus(),
+
+		alertStates:    make(map[string]*AlertState),
+		lanCommandGap:  DefaultLANCommandGap,
+		readingHistory: DefaultReadingHistory,
 	}
+	s.SetCloudPolicy(DefaultCloudPolicy())
+
+	return s
 }
 
-// Fetches devices from the Govee cloud API
-func (s *GoveeService) GetDevices(ctx context.Context) ([]Device, error) {
-	url := s.baseURL + "/router/api/v1/user/devices"
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// Points the service at another Govee cloud API, such as a fake server for tests. Call it
+// before the service is used.
+func (s *GoveeService) SetCloudURL(url string) {
+	s.baseURL = strings.TrimSuffix(url, "/")
+}
+
+// Sends a request to the Govee cloud API and returns the response body of a successful call.
+// Upstream failures are retried with backoff under the cloud policy. Every call go-vee makes is
+// safe to repeat: reads are idempotent, and a retried control request keeps its requestId so
+// Govee can drop the duplicate.
+func (s *GoveeService) cloudRequest(ctx context.Context, apiKey string, method string, path string, payload interface{}) ([]byte, error) {
+	logger := logging.FromContext(ctx)
+
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling request body: %w", err)
+		}
+	}
+
+	for retry := 1; ; retry++ {
+		probe, err := s.breaker.allow()
+		if err != nil {
+			return nil, err
+		}
+
+		responseBody, err := s.cloudAttempt(ctx, apiKey, method, path, body)
+
+		// A caller that gave up says nothing about the upstream and is not worth retrying
+		if err != nil && ctx.Err() != nil {
+			s.breaker.abandon(probe)
+			return nil, err
+		}
+
+		s.breaker.record(probe, err)
+		if err == nil {
+			return responseBody, nil
+		}
+
+		delay, ok := s.policy.retryDelay(retry, err)
+		if !ok {
+			return nil, err
+		}
+
+		logger.Warn("Retrying Govee API request", "method", method, "path", path, "retry", retry, "delay", delay, "error", err)
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// Makes a single call to the Govee cloud API, bounded by the policy timeout
+func (s *GoveeService) cloudAttempt(ctx context.Context, apiKey string, method string, path string, body []byte) ([]byte, error) {
+	logger := logging.FromContext(ctx)
+	url := s.baseURL + path
+
+	if s.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.policy.Timeout)
+		defer cancel()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("creating request to %s: %w", url, err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Govee-API-Key", s.apiKey)
+	req.Header.Set("Govee-API-Key", apiKey)
 
-	log.Printf("Making request to Govee API: %s", url)
+	logger.Debug("Making request to Govee API", "method", method, "url", url)
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("making request to Govee API: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading response body: %w", err)
+		return nil, fmt.Errorf("%w: reading response body: %w", ErrUpstreamUnavailable, err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Govee API error response: %s", string(body))
-		return nil, fmt.Errorf("govee api returned status %d: %s", resp.StatusCode, string(body))
+		apiErr := newAPIError(resp.StatusCode, responseBody, resp.Header.Get("Retry-After"))
+		logger.Warn("Govee API error response", "status", resp.StatusCode, "message", apiErr.Message)
+		return nil, apiErr
+	}
+
+	return responseBody, nil
+}
+
+// Fetches the devices of a single Govee account
+func (s *GoveeService) fetchDevices(ctx context.Context, account Account) ([]Device, error) {
+	logger := logging.FromContext(ctx)
+
+	body, err := s.cloudRequest(ctx, account.APIKey, http.MethodGet, "/router/api/v1/user/devices", nil)
+	if err != nil {
+		return nil, err
 	}
 
 	var deviceResp DeviceResponse
 	if err := json.Unmarshal(body, &deviceResp); err != nil {
-		log.Printf("Failed to parse response: %s", string(body))
-		return nil, fmt.Errorf("parsing response body: %w", err)
+		logger.Warn("Failed to parse Govee API response", "bytes", len(body), "error", err)
+		return nil, fmt.Errorf("%w: parsing response body: %w", ErrUpstreamUnavailable, err)
 	}
 
 	if deviceResp.Code != 200 {
-		return nil, fmt.Errorf("govee api error: %s (code: %d)", deviceResp.Message, deviceResp.Code)
+		return nil, &APIError{Status: deviceResp.Code, Message: truncateMessage(deviceResp.Message)}
+	}
+
+	for i := range deviceResp.Data {
+		deviceResp.Data[i].Account = account.Name
 	}
 
-	log.Printf("Successfully fetched %d devices", len(deviceResp.Data))
 	return deviceResp.Data, nil
 }
 
-// Controls a device using either LAN or the Govee cloud API
-func (s *GoveeService) ControlDevice(ctx context.Context, sku string, deviceID string, capability ControlCapability) error {
-	if s.useLAN {
-		devices, err := lan.DiscoverDevices(2 * time.Second)
-		if err == nil {
-			// Look for matching device
-			for _, device := range devices {
-				if device.Msg.Data.Device == deviceID {
-					// Found device on LAN, try to control it
-					var err error
-
-					switch capability.Type {
-					case "devices.capabilities.on_off":
-						if val, ok := capability.Value.(float64); ok {
-							if val == 1 {
-								err = lan.TurnOn(device.Msg.Data.IP)
-							} else {
-								err = lan.TurnOff(device.Msg.Data.IP)
-							}
-						}
-					case "devices.capabilities.range":
-						if val, ok := capability.Value.(float64); ok {
-							err = lan.SetBrightness(device.Msg.Data.IP, int(val))
-						}
-					case "devices.capabilities.color_setting":
-						if colorInt, ok := capability.Value.(float64); ok {
-							r := int((uint32(colorInt) >> 16) & 0xFF)
-							g := int((uint32(colorInt) >> 8) & 0xFF)
-							b := int(uint32(colorInt) & 0xFF)
-
-							err = lan.SetColor(device.Msg.Data.IP, r, g, b)
-						}
-					}
-
-					if err == nil {
-						log.Printf("Successfully controlled device %s via LAN", deviceID)
-						return nil
-					}
-					log.Printf("Failed to control device via LAN, falling back to cloud API: %v", err)
-				}
-			}
-		} else {
-			log.Printf("Failed to discover LAN devices, falling back to cloud API: %v", err)
+// Fetches devices from the Govee cloud API. A key supplied by the client is used on its own,
+// otherwise the devices of every configured account are merged.
+func (s *GoveeService) GetDevices(ctx context.Context) ([]Device, error) {
+	logger := logging.FromContext(ctx)
+
+	if apiKey := APIKeyFromContext(ctx); apiKey != "" {
+		devices, err := s.fetchDevices(ctx, Account{APIKey: apiKey})
+		if err != nil {
+			return nil, err
 		}
+
+		logger.Info("Fetched devices from Govee API", "devices", len(devices), "account", "request")
+		return devices, nil
 	}
 
-	// Fall back to cloud API
-	url := s.baseURL + "/router/api/v1/device/control"
+	if len(s.accounts) == 0 {
+		return nil, ErrNoAccounts
+	}
 
-	// Validate capability
-	if capability.Type == "" || capability.Instance == "" {
-		return fmt.Errorf("invalid capability: type and instance are required")
+	type result struct {
+		account Account
+		devices []Device
+		err     error
 	}
 
-	request := ControlRequest{
-		RequestID: uuid.New().String(),
-		Payload: ControlPayload{
-			SKU:        sku,
-			Device:     deviceID,
-			Capability: capability,
-		},
+	results := make([]result, len(s.accounts))
+	var wg sync.WaitGroup
+	for i, account := range s.accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			devices, err := s.fetchDevices(ctx, account)
+			results[i] = result{account: account, devices: devices, err: err}
+		}()
 	}
+	wg.Wait()
+
+	var devices []Device
+	var firstErr error
+	failed := 0
+	for _, res := range results {
+		if res.err != nil {
+			logger.Warn("Failed to fetch devices for account", "account", res.account.Name, "error", res.err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("account %s: %w", res.account.Name, res.err)
+			}
+			failed++
+			continue
+		}
 
-	body, err := json.Marshal(request)
-	if err != nil {
-		return fmt.Errorf("marshaling request body: %w", err)
+		s.mu.Lock()
+		for _, device := range res.devices {
+			s.owners[device.Device] = res.account.Name
+		}
+		s.mu.Unlock()
+
+		devices = append(devices, res.devices...)
+	}
+
+	// Only fail when no account could be reached, otherwise return what we have
+	if failed == len(results) {
+		return nil, firstErr
 	}
 
-	// Log the request payload for debugging
-	log.Printf("Control request payload: %s", string(body))
+	logger.Info("Fetched devices from Govee API", "devices", len(devices), "accounts", len(results)-failed)
+	return devices, nil
+}
+
+// Looks up a device on the LAN, scanning again when it is not in the discovery cache
+func (s *GoveeService) findLANDevice(ctx context.Context, deviceID string) (lan.ScanResponse, error) {
+	s.mu.RLock()
+	device, ok := s.lanDevices[deviceID]
+	s.mu.RUnlock()
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if ok {
+		return device, nil
+	}
+
+	devices, err := s.DiscoverLANDevices(ctx)
 	if err != nil {
-		return fmt.Errorf("creating request to %s: %w", url, err)
+		return lan.ScanResponse{}, fmt.Errorf("discovering LAN devices: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Govee-API-Key", s.apiKey)
+	for _, device := range devices {
+		if device.Msg.Data.Device == deviceID {
+			return device, nil
+		}
+	}
 
-	log.Printf("Making control request to Govee API: %s", url)
-	resp, err := s.client.Do(req)
+	return lan.ScanResponse{}, fmt.Errorf("%w on LAN: %s", ErrDeviceNotFound, deviceID)
+}
+
+// Translates a capability into the matching LAN command
+func controlLAN(ctx context.Context, deviceIP string, capability ControlCapability) error {
+	val, ok := capability.Value.(float64)
+	if !ok {
+		return fmt.Errorf("%w over LAN: value %v for %s/%s", ErrUnsupportedCapability, capability.Value, capability.Type, capability.Instance)
+	}
+
+	switch {
+	case capability.Type == "devices.capabilities.on_off":
+		if val == 1 {
+			return lan.TurnOn(ctx, deviceIP)
+		}
+		return lan.TurnOff(ctx, deviceIP)
+	case capability.Type == "devices.capabilities.range" && capability.Instance == "brightness":
+		return lan.SetBrightness(ctx, deviceIP, int(val))
+	case capability.Type == CapabilityColorSetting && capability.Instance == InstanceColorRGB:
+		rgb := color.FromPacked(int(val))
+		return lan.SetColor(ctx, deviceIP, rgb.R, rgb.G, rgb.B)
+	case capability.Type == CapabilityColorSetting && capability.Instance == InstanceColorTemp:
+		return lan.SetColorTemperature(ctx, deviceIP, int(val))
+	}
+
+	return fmt.Errorf("%w over LAN: %s/%s", ErrUnsupportedCapability, capability.Type, capability.Instance)
+}
+
+// Data of control.succeeded and control.failed events
+type ControlEventData struct {
+	Value     interface{} `json:"value"`
+	Transport string      `json:"transport,omitempty"`
+	LANError  string      `json:"lanError,omitempty"`
+	LatencyMs float64     `json:"latencyMs,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	ErrorCode string      `json:"errorCode,omitempty"`
+}
+
+// Controls a device over the requested transport and publishes the outcome as an event.
+// With TransportAuto the LAN is tried first and the Govee cloud API is used as a fallback.
+// Color values are normalized with NormalizeCapability before they are sent. Commands to the
+// same device are queued and sent in order; a queued command is replaced by a newer one for
+// the same capability.
+func (s *GoveeService) ControlDevice(ctx context.Context, sku string, deviceID string, capability ControlCapability, transport string) (*ControlResult, error) {
+	normalized, err := NormalizeCapability(capability)
 	if err != nil {
-		return fmt.Errorf("making request to Govee API: %w", err)
+		s.publishControl(sku, deviceID, capability, nil, err)
+		s.recordControl(ctx, sku, deviceID, capability, transport, nil, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	responseBody, err := io.ReadAll(resp.Body)
+	result, err := s.enqueueControl(ctx, sku, deviceID, normalized, transport, false)
+	s.recordControl(ctx, sku, deviceID, capability, transport, result, err)
+
+	return result, err
+}
+
+func (s *GoveeService) controlDevice(ctx context.Context, sku string, deviceID string, capability ControlCapability, transport string) (*ControlResult, error) {
+	logger := logging.FromContext(ctx).With("sku", sku, "device", deviceID)
+	start := time.Now()
+
+	if transport == "" {
+		transport = TransportAuto
+	}
+
+	if transport != TransportAuto && transport != TransportLAN && transport != TransportCloud {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTransport, transport)
+	}
+
+	// Validate capability
+	if capability.Type == "" || capability.Instance == "" {
+		return nil, fmt.Errorf("%w: capability type and instance are required", ErrInvalidValue)
+	}
+
+	result := &ControlResult{}
+	var lanErr error
+
+	if transport == TransportLAN && !s.useLAN {
+		return nil, fmt.Errorf("%w: LAN control is disabled", ErrLANControl)
+	}
+
+	if transport != TransportCloud && s.useLAN {
+		device, err := s.findLANDevice(ctx, deviceID)
+		if err == nil {
+			err = controlLAN(ctx, device.Msg.Data.IP, capability)
+		}
+
+		if err == nil {
+			result.Transport = TransportLAN
+			result.Latency = time.Since(start)
+			logger.Info("Controlled device via LAN", "ip", device.Msg.Data.IP, "latency", result.Latency)
+			return result, nil
+		}
+
+		// LAN-only requests never fall through to the cloud
+		if transport == TransportLAN {
+			return nil, fmt.Errorf("%w: %w", ErrLANControl, err)
+		}
+
+		logger.Warn("Failed to control device via LAN, falling back to cloud API", "error", err)
+		result.LANError = err.Error()
+		lanErr = err
+	}
+
+	// Fall back to cloud API
+	if err := s.controlCloud(ctx, logger, sku, deviceID, capability); err != nil {
+		// The cloud error decides how the failure is classified; the LAN error is kept for context
+		if lanErr != nil {
+			return nil, fmt.Errorf("%w (LAN attempt failed first: %v)", err, lanErr)
+		}
+		return nil, err
+	}
+
+	result.Transport = TransportCloud
+	result.Latency = time.Since(start)
+	logger.Info("Controlled device via cloud API", "latency", result.Latency)
+
+	return result, nil
+}
+
+// Sends a control request through the Govee cloud API with the key of the account that owns
+// the device
+func (s *GoveeService) controlCloud(ctx context.Context, logger *slog.Logger, sku string, deviceID string, capability ControlCapability) error {
+	account, err := s.accountForDevice(ctx, deviceID)
 	if err != nil {
-		return fmt.Errorf("reading response body: %w", err)
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Govee API error response: %s", string(responseBody))
-		return fmt.Errorf("govee api returned status %d: %s", resp.StatusCode, string(responseBody))
+	request := ControlRequest{
+		RequestID: uuid.New().String(),
+		Payload: ControlPayload{
+			SKU:        sku,
+			Device:     deviceID,
+			Capability: capability,
+		},
+	}
+
+	logger = logger.With("account", account.Name, "cloud_request_id", request.RequestID)
+	logger.Debug("Control request payload", "capability", capability.Type, "instance", capability.Instance, "value", capability.Value)
+
+	responseBody, err := s.cloudRequest(ctx, account.APIKey, http.MethodPost, "/router/api/v1/device/control", request)
+	if err != nil {
+		return err
 	}
 
 	var controlResp ControlResponse
 	if err := json.Unmarshal(responseBody, &controlResp); err != nil {
-		log.Printf("Failed to parse response: %s", string(responseBody))
-		return fmt.Errorf("parsing response body: %w", err)
+		logger.Warn("Failed to parse Govee API response", "bytes", len(responseBody), "error", err)
+		return fmt.Errorf("%w: parsing response body: %w", ErrUpstreamUnavailable, err)
 	}
 
 	if controlResp.Code != 200 {
-		log.Printf("Full response body: %s", string(responseBody))
-		return fmt.Errorf("govee api error: %s (code: %d)", controlResp.Message, controlResp.Code)
+		logger.Warn("Govee API rejected control request", "code", controlResp.Code, "message", truncateMessage(controlResp.Message))
+		return &APIError{Status: controlResp.Code, Message: truncateMessage(controlResp.Message)}
 	}
 
-	log.Printf("Successfully controlled device %s", deviceID)
-
 	return nil
 }
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+const (
+	CheckOK       = "ok"
+	CheckFailing  = "failing"
+	CheckPending  = "pending"
+	CheckDisabled = "disabled"
+)
+
+// How long a cloud credential check is reused before the Govee API is asked again
+const cloudCheckTTL = time.Minute
+
+type Check struct {
+	Status    string     `json:"status"`
+	Message   string     `json:"message,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Fails while the cloud circuit breaker is open, so load balancers see that cloud calls are
+// being refused without waiting for the next credential check. The upstream error is left out,
+// since the readiness probe is served without authentication.
+func (s *GoveeService) breakerCheck() Check {
+	if len(s.accounts) == 0 {
+		return Check{Status: CheckDisabled, Message: "no Govee accounts configured"}
+	}
+
+	status := s.breaker.status()
+	switch status.State {
+	case BreakerOpen:
+		return Check{
+			Status:  CheckFailing,
+			Message: fmt.Sprintf("open after %d consecutive failures until %s", status.Failures, status.OpenUntil.Format(time.RFC3339)),
+		}
+	case BreakerHalfOpen:
+		return Check{Status: CheckOK, Message: "half-open, the next cloud call is a probe"}
+	}
+
+	return Check{Status: CheckOK}
+}
+
+// Fails until LAN discovery has succeeded once. Like the breaker check, it leaves the error out
+// of the probe. The callers of DiscoverLANDevices log each failure, so the probe only repeats
+// it at debug level with the request ID.
+func (s *GoveeService) lanCheck(ctx context.Context) Check {
+	if !s.useLAN {
+		return Check{Status: CheckDisabled}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.lanDiscoveredAt.IsZero() {
+		check := Check{Status: CheckPending, Message: "LAN discovery has not completed yet"}
+		if s.lanLastError != nil {
+			check.Status = CheckFailing
+			check.Message = "LAN discovery failing"
+			logging.FromContext(ctx).Debug("LAN readiness check failing", "error", s.lanLastError)
+		}
+		return check
+	}
+
+	discoveredAt := s.lanDiscoveredAt
+	return Check{Status: CheckOK, CheckedAt: &discoveredAt}
+}
+
+// Verifies that every configured account's API key is accepted, reusing recent results. Only one
+// check runs at a time; probes that arrive meanwhile wait for it and share its result, so a burst
+// of probes costs one request per account. The result names a failing account without its
+// error, which is logged instead.
+func (s *GoveeService) cloudCheck(ctx context.Context) Check {
+	if len(s.accounts) == 0 {
+		return Check{Status: CheckDisabled, Message: "no Govee accounts configured"}
+	}
+
+	if check, ok := s.cachedCloudCheck(); ok {
+		return check
+	}
+
+	s.cloudCheckMu.Lock()
+	defer s.cloudCheckMu.Unlock()
+
+	// Another probe may have refreshed the result while this one waited
+	if check, ok := s.cachedCloudCheck(); ok {
+		return check
+	}
+
+	// The result is shared, so a probe that disconnects does not cancel the check
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	check := Check{Status: CheckOK, CheckedAt: &now}
+	for _, account := range s.accounts {
+		if _, err := s.fetchDevices(ctx, account); err != nil {
+			logging.FromContext(ctx).Warn("Readiness check failed for account", "account", account.Name, "error", err)
+			check.Status = CheckFailing
+			check.Message = "account " + account.Name
+			break
+		}
+	}
+
+	s.mu.Lock()
+	s.cloudCheckResult = check
+	s.mu.Unlock()
+
+	return check
+}
+
+func (s *GoveeService) cachedCloudCheck() (Check, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cached := s.cloudCheckResult
+	return cached, cached.CheckedAt != nil && time.Since(*cached.CheckedAt) < cloudCheckTTL
+}
+
+// Reports whether cloud credentials work and LAN discovery has succeeded at least once
+func (s *GoveeService) Readiness(ctx context.Context) Readiness {
+	checks := map[string]Check{
+		"cloud":        s.cloudCheck(ctx),
+		"cloudBreaker": s.breakerCheck(),
+		"lan":          s.lanCheck(ctx),
+	}
+
+	ready := true
+	for _, check := range checks {
+		if check.Status != CheckOK && check.Status != CheckDisabled {
+			ready = false
+		}
+	}
+
+	return Readiness{Ready: ready, Checks: checks}
+}
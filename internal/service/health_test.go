@@ -0,0 +1,70 @@
+package service_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+func TestReadinessSharesOneCloudCheck(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"code":200,"message":"success","data":[]}`))
+	}))
+	defer server.Close()
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "key"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if readiness := svc.Readiness(context.Background()); !readiness.Ready {
+				t.Errorf("not ready: %+v", readiness.Checks)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("20 concurrent probes made %d cloud requests, want 1", got)
+	}
+}
+
+func TestReadinessHidesUpstreamErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code":401,"message":"secret upstream detail"}`))
+	}))
+	defer server.Close()
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "key"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	readiness := svc.Readiness(context.Background())
+	if readiness.Ready {
+		t.Fatal("ready with a rejected API key")
+	}
+
+	cloud := readiness.Checks["cloud"]
+	if cloud.Status != service.CheckFailing || cloud.Message != "account home" {
+		t.Errorf("cloud check %+v, want failing for account home", cloud)
+	}
+
+	for name, check := range readiness.Checks {
+		if strings.Contains(check.Message, "secret upstream detail") {
+			t.Errorf("%s check exposes the upstream error: %s", name, check.Message)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// How long the cloud device list is reused when building the inventory
+const inventoryCloudTTL = 5 * time.Minute
+
+// A device known from the cloud device list, LAN discovery, or both
+type InventoryDevice struct {
+	SKU     string `json:"sku"`
+	Device  string `json:"device"`
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+	Account string `json:"account,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Cloud   bool   `json:"cloud"`
+	LAN     bool   `json:"lan"`
+}
+
+// Reports whether the device is a light. Devices only seen on the LAN are lights,
+// since the LAN API is only offered by lights.
+func (d InventoryDevice) IsLight() bool {
+	return d.Type == DeviceTypeLight || (!d.Cloud && d.LAN)
+}
+
+// Returns the cloud device list for the inventory. The list of the configured accounts is shared
+// and cached; a client supplied key gets its own uncached list, so one client's devices are never
+// served to another.
+func (s *GoveeService) cachedCloudDevices(ctx context.Context) []Device {
+	logger := logging.FromContext(ctx)
+
+	if APIKeyFromContext(ctx) != "" {
+		devices, err := s.GetDevices(ctx)
+		if err != nil {
+			logger.Debug("Cloud device list unavailable for inventory", "account", "request", "error", err)
+			return nil
+		}
+		return devices
+	}
+
+	s.mu.RLock()
+	devices, fetchedAt := s.cloudDevices, s.cloudFetchedAt
+	s.mu.RUnlock()
+
+	if !fetchedAt.IsZero() && time.Since(fetchedAt) < inventoryCloudTTL {
+		return devices
+	}
+
+	if len(s.accounts) == 0 {
+		return nil
+	}
+
+	fresh, err := s.GetDevices(ctx)
+	if err != nil {
+		logger.Debug("Cloud device list unavailable for inventory", "error", err)
+		return devices
+	}
+
+	s.mu.Lock()
+	s.cloudDevices = fresh
+	s.cloudFetchedAt = time.Now()
+	s.mu.Unlock()
+
+	return fresh
+}
+
+// Looks up a device in the cloud device list. Like Inventory, a client supplied key in ctx is
+// looked up in that key's own list.
+func (s *GoveeService) CloudDevice(ctx context.Context, deviceID string) (Device, bool) {
+	for _, d := range s.cachedCloudDevices(ctx) {
+		if d.Device == deviceID {
+			return d, true
+		}
+	}
+
+	return Device{}, false
+}
+
+// Merges the cloud device list with the devices found by LAN discovery. The list of the configured
+// accounts is cached for a few minutes, so this is cheap to call often; with a client supplied key
+// in ctx the list of that key is fetched instead and never cached.
+func (s *GoveeService) Inventory(ctx context.Context) []InventoryDevice {
+	byID := make(map[string]*InventoryDevice)
+
+	for _, d := range s.cachedCloudDevices(ctx) {
+		byID[d.Device] = &InventoryDevice{
+			SKU:     d.SKU,
+			Device:  d.Device,
+			Name:    d.DeviceName,
+			Type:    d.Type,
+			Account: d.Account,
+			Cloud:   true,
+		}
+	}
+
+	for _, d := range s.CachedLANDevices() {
+		entry, ok := byID[d.Msg.Data.Device]
+		if !ok {
+			entry = &InventoryDevice{
+				SKU:    d.Msg.Data.SKU,
+				Device: d.Msg.Data.Device,
+				Name:   d.Msg.Data.SKU + " " + d.Msg.Data.Device,
+			}
+			byID[d.Msg.Data.Device] = entry
+		}
+
+		entry.IP = d.Msg.Data.IP
+		entry.LAN = true
+	}
+
+	devices := make([]InventoryDevice, 0, len(byID))
+	for _, d := range byID {
+		devices = append(devices, *d)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Device < devices[j].Device
+	})
+
+	return devices
+}
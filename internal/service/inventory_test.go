@@ -0,0 +1,135 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+// Serves a different device list for every API key and counts the requests of each key
+func deviceListServer(t *testing.T, devices map[string]string) (*httptest.Server, func(string) int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := r.Header.Get("Govee-API-Key")
+
+		mu.Lock()
+		calls[apiKey]++
+		mu.Unlock()
+
+		device, ok := devices[apiKey]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(service.DeviceResponse{
+			Code: 200,
+			Data: []service.Device{{SKU: "H6022", Device: device, DeviceName: device, Type: service.DeviceTypeLight}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func(apiKey string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls[apiKey]
+	}
+}
+
+func inventoryIDs(devices []service.InventoryDevice) []string {
+	ids := make([]string, len(devices))
+	for i, d := range devices {
+		ids[i] = d.Device
+	}
+	return ids
+}
+
+func TestInventoryKeepsRequestKeysApart(t *testing.T) {
+	server, calls := deviceListServer(t, map[string]string{
+		"configured": "AA:00:00:00:00:00:00:01",
+		"alice":      "AA:00:00:00:00:00:00:02",
+		"bob":        "AA:00:00:00:00:00:00:03",
+	})
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "configured"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	tests := []struct {
+		name   string
+		apiKey string
+		want   string
+	}{
+		{"alice", "alice", "AA:00:00:00:00:00:00:02"},
+		{"bob", "bob", "AA:00:00:00:00:00:00:03"},
+		{"configured account", "", "AA:00:00:00:00:00:00:01"},
+		{"alice again", "alice", "AA:00:00:00:00:00:00:02"},
+		{"configured account again", "", "AA:00:00:00:00:00:00:01"},
+	}
+
+	for _, tt := range tests {
+		ctx := service.WithAPIKey(context.Background(), tt.apiKey)
+		got := inventoryIDs(svc.Inventory(ctx))
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: inventory %v, want [%s]", tt.name, got, tt.want)
+		}
+	}
+
+	// Request keys are never cached, the configured account is fetched once
+	if got := calls("alice"); got != 2 {
+		t.Errorf("alice's devices fetched %d times, want 2", got)
+	}
+	if got := calls("configured"); got != 1 {
+		t.Errorf("configured account's devices fetched %d times, want 1", got)
+	}
+}
+
+func TestInventoryWithoutAccountsUsesRequestKey(t *testing.T) {
+	server, _ := deviceListServer(t, map[string]string{"alice": "AA:00:00:00:00:00:00:02"})
+
+	svc := service.NewGoveeService(nil, false)
+	svc.SetCloudURL(server.URL)
+
+	got := inventoryIDs(svc.Inventory(service.WithAPIKey(context.Background(), "alice")))
+	if len(got) != 1 || got[0] != "AA:00:00:00:00:00:00:02" {
+		t.Errorf("inventory with a request key %v, want [AA:00:00:00:00:00:00:02]", got)
+	}
+
+	if got := svc.Inventory(context.Background()); len(got) != 0 {
+		t.Errorf("inventory without a key %v, want none", inventoryIDs(got))
+	}
+}
+
+func TestCloudDeviceWithRequestKey(t *testing.T) {
+	server, _ := deviceListServer(t, map[string]string{
+		"configured": "AA:00:00:00:00:00:00:01",
+		"alice":      "AA:00:00:00:00:00:00:02",
+	})
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "configured"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	alice := service.WithAPIKey(context.Background(), "alice")
+	if _, ok := svc.CloudDevice(alice, "AA:00:00:00:00:00:00:02"); !ok {
+		t.Error("alice's device not found with alice's key")
+	}
+	if _, ok := svc.CloudDevice(alice, "AA:00:00:00:00:00:00:01"); ok {
+		t.Error("configured account's device found with alice's key")
+	}
+
+	// A lookup with a request key leaves the shared list untouched
+	if _, ok := svc.CloudDevice(context.Background(), "AA:00:00:00:00:00:00:02"); ok {
+		t.Error("alice's device found without a key")
+	}
+	if _, ok := svc.CloudDevice(context.Background(), "AA:00:00:00:00:00:00:01"); !ok {
+		t.Error("configured account's device not found without a key")
+	}
+}
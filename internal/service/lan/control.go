@@ -4,9 +4,12 @@
 package lan
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+
+	"github.com/EternityX/go-vee/internal/logging"
 )
 
 func clampValue(value, min, max int) int {
@@ -45,7 +48,7 @@ type ControlResponse struct {
 }
 
 // Sends a control command to a device over LAN
-func ControlDevice(deviceIP string, cmd string, data interface{}) error {
+func ControlDevice(ctx context.Context, deviceIP string, cmd string, data interface{}) error {
 	addr, err := net.ResolveUDPAddr("udp", deviceIP+":4003")
 	if err != nil {
 		return fmt.Errorf("failed to resolve device address: %w", err)
@@ -57,6 +60,10 @@ func ControlDevice(deviceIP string, cmd string, data interface{}) error {
 	}
 	defer conn.Close()
 
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
 	// Prepare control request
 	req := ControlRequest{}
 	req.Msg.Cmd = cmd
@@ -67,6 +74,8 @@ func ControlDevice(deviceIP string, cmd string, data interface{}) error {
 		return fmt.Errorf("failed to marshal control request: %w", err)
 	}
 
+	logging.FromContext(ctx).Debug("Sending LAN command", "ip", deviceIP, "cmd", cmd)
+
 	// Send control request
 	_, err = conn.Write(reqData)
 	if err != nil {
@@ -91,27 +100,27 @@ func ControlDevice(deviceIP string, cmd string, data interface{}) error {
 }
 
 // Common control commands
-func TurnOn(deviceIP string) error {
+func TurnOn(ctx context.Context, deviceIP string) error {
 	data := struct {
 		Value int `json:"value"`
 	}{
 		Value: 1,
 	}
 
-	return ControlDevice(deviceIP, "turn", data)
+	return ControlDevice(ctx, deviceIP, "turn", data)
 }
 
-func TurnOff(deviceIP string) error {
+func TurnOff(ctx context.Context, deviceIP string) error {
 	data := struct {
 		Value int `json:"value"`
 	}{
 		Value: 0,
 	}
 
-	return ControlDevice(deviceIP, "turn", data)
+	return ControlDevice(ctx, deviceIP, "turn", data)
 }
 
-func SetBrightness(deviceIP string, brightness int) error {
+func SetBrightness(ctx context.Context, deviceIP string, brightness int) error {
 	brightness = clampValue(brightness, 1, 100)
 
 	data := struct {
@@ -120,10 +129,10 @@ func SetBrightness(deviceIP string, brightness int) error {
 		Value: brightness,
 	}
 
-	return ControlDevice(deviceIP, "brightness", data)
+	return ControlDevice(ctx, deviceIP, "brightness", data)
 }
 
-func SetColor(deviceIP string, r, g, b int) error {
+func SetColor(ctx context.Context, deviceIP string, r, g, b int) error {
 	r = clampValue(r, 0, 255)
 	g = clampValue(g, 0, 255)
 	b = clampValue(b, 0, 255)
@@ -148,11 +157,28 @@ func SetColor(deviceIP string, r, g, b int) error {
 		ColorTemInKelvin: 0, // Set to 0 to use RGB values
 	}
 
-	return ControlDevice(deviceIP, "colorwc", data)
+	return ControlDevice(ctx, deviceIP, "colorwc", data)
+}
+
+func SetColorTemperature(ctx context.Context, deviceIP string, kelvin int) error {
+	kelvin = clampValue(kelvin, 2000, 9000)
+
+	data := struct {
+		Color struct {
+			R int `json:"r"`
+			G int `json:"g"`
+			B int `json:"b"`
+		} `json:"color"`
+		ColorTemInKelvin int `json:"colorTemInKelvin"`
+	}{
+		ColorTemInKelvin: kelvin,
+	}
+
+	return ControlDevice(ctx, deviceIP, "colorwc", data)
 }
 
 // Queries the status of a device over LAN
-func GetDeviceStatus(deviceIP string) (*ControlResponse, error) {
+func GetDeviceStatus(ctx context.Context, deviceIP string) (*ControlResponse, error) {
 	data := struct{}{} // Empty data for status query
 
 	addr, err := net.ResolveUDPAddr("udp", deviceIP+":4003")
@@ -166,6 +192,10 @@ func GetDeviceStatus(deviceIP string) (*ControlResponse, error) {
 	}
 	defer conn.Close()
 
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
 	// Prepare status request
 	req := ControlRequest{}
 	req.Msg.Cmd = "devStatus"
@@ -176,6 +206,8 @@ func GetDeviceStatus(deviceIP string) (*ControlResponse, error) {
 		return nil, fmt.Errorf("failed to marshal status request: %w", err)
 	}
 
+	logging.FromContext(ctx).Debug("Sending LAN status query", "ip", deviceIP)
+
 	// Send status request
 	_, err = conn.Write(reqData)
 	if err != nil {
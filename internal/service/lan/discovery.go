@@ -4,16 +4,24 @@
 package lan
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
+	"net/netip"
+	"strings"
 	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
 )
 
 const (
 	multicastAddr = "239.255.255.250:4001"
+	scanPort      = 4001
 	listenPort    = "4002"
+
+	// Largest number of addresses a single unicast target subnet may expand to
+	maxTargetHosts = 1024
 )
 
 type ScanRequest struct {
@@ -40,20 +48,40 @@ type ScanResponse struct {
 	} `json:"msg"`
 }
 
+// Controls where scan requests are sent. The zero value sends one multicast scan out of the
+// interface picked by the routing table.
+type DiscoverOptions struct {
+	// Names of the network interfaces to send the multicast scan on
+	Interfaces []string
+	// Sends the multicast scan on every interface that is up and supports multicast
+	AllInterfaces bool
+	// IP addresses or CIDR subnets that are also probed by unicast on port 4001, for devices
+	// multicast cannot reach
+	Targets []string
+	// Skips the multicast scan, so only Targets are probed
+	NoMulticast bool
+}
+
 // Scans for Govee devices on the local network
-func DiscoverDevices(timeout time.Duration) ([]ScanResponse, error) {
-	// Create UDP address for multicast
-	multicastAddr, err := net.ResolveUDPAddr("udp", multicastAddr)
+func DiscoverDevices(ctx context.Context, timeout time.Duration) ([]ScanResponse, error) {
+	return Discover(ctx, timeout, DiscoverOptions{})
+}
+
+// Scans for Govee devices as configured by opts and collects replies until the timeout or the
+// ctx deadline. Devices that reply more than once, for example on several interfaces, are
+// returned once.
+func Discover(ctx context.Context, timeout time.Duration, opts DiscoverOptions) ([]ScanResponse, error) {
+	logger := logging.FromContext(ctx)
+
+	group, err := net.ResolveUDPAddr("udp4", multicastAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve multicast address: %w", err)
 	}
 
-	// Create UDP connection for sending
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	targets, err := expandTargets(opts.Targets)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
+		return nil, err
 	}
-	defer conn.Close()
 
 	// Create UDP server for receiving responses
 	serverAddr, err := net.ResolveUDPAddr("udp", ":"+listenPort)
@@ -77,36 +105,246 @@ func DiscoverDevices(timeout time.Duration) ([]ScanResponse, error) {
 		return nil, fmt.Errorf("failed to marshal scan request: %w", err)
 	}
 
-	// Send scan request
-	_, err = conn.WriteToUDP(reqData, multicastAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send scan request: %w", err)
+	if !opts.NoMulticast {
+		if err := sendMulticastScans(ctx, reqData, group, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(targets) > 0 {
+		if err := sendUnicastScans(reqData, targets); err != nil {
+			return nil, err
+		}
+		logger.Debug("Sent unicast scans", "targets", len(targets))
 	}
 
 	// Collect responses
 	var devices []ScanResponse
+	seen := make(map[string]bool)
 	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
 	buffer := make([]byte, 1024)
 
-	for time.Now().Before(deadline) {
+	// Unblock the read below as soon as ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		server.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for time.Now().Before(deadline) && ctx.Err() == nil {
 		server.SetReadDeadline(deadline)
 		n, _, err := server.ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				break
 			}
-			log.Printf("Error reading UDP response: %v", err)
+			logger.Warn("Error reading UDP response", "error", err)
 			continue
 		}
 
 		var resp ScanResponse
 		if err := json.Unmarshal(buffer[:n], &resp); err != nil {
-			log.Printf("Error unmarshaling response: %v", err)
+			logger.Warn("Error unmarshaling scan response", "error", err)
+			continue
+		}
+
+		if seen[resp.Msg.Data.Device] {
 			continue
 		}
+		seen[resp.Msg.Data.Device] = true
 
 		devices = append(devices, resp)
 	}
 
+	logger.Debug("LAN discovery finished", "devices", len(devices))
 	return devices, nil
 }
+
+// Sends the multicast scan from the default route, or once per selected interface
+func sendMulticastScans(ctx context.Context, reqData []byte, group *net.UDPAddr, opts DiscoverOptions) error {
+	logger := logging.FromContext(ctx)
+
+	if len(opts.Interfaces) == 0 && !opts.AllInterfaces {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		if err != nil {
+			return fmt.Errorf("failed to create UDP connection: %w", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.WriteToUDP(reqData, group); err != nil {
+			return fmt.Errorf("failed to send scan request: %w", err)
+		}
+
+		return nil
+	}
+
+	interfaces, err := scanInterfaces(opts)
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for _, iface := range interfaces {
+		if err := sendOnInterface(reqData, group, iface); err != nil {
+			logger.Warn("Failed to send scan request on interface", "interface", iface.Name, "error", err)
+			continue
+		}
+
+		logger.Debug("Sent scan request", "interface", iface.Name)
+		sent++
+	}
+
+	if sent == 0 {
+		return fmt.Errorf("failed to send scan request on any of %d interfaces", len(interfaces))
+	}
+
+	return nil
+}
+
+// Returns the interfaces named in opts, or all usable ones with AllInterfaces
+func scanInterfaces(opts DiscoverOptions) ([]net.Interface, error) {
+	if !opts.AllInterfaces {
+		interfaces := make([]net.Interface, 0, len(opts.Interfaces))
+		for _, name := range opts.Interfaces {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, fmt.Errorf("network interface %q: %w", name, err)
+			}
+			interfaces = append(interfaces, *iface)
+		}
+
+		return interfaces, nil
+	}
+
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("listing network interfaces: %w", err)
+	}
+
+	var interfaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		if _, err := interfaceIPv4(iface); err != nil {
+			continue
+		}
+
+		interfaces = append(interfaces, iface)
+	}
+
+	if len(interfaces) == 0 {
+		return nil, fmt.Errorf("no network interface is up with multicast and an IPv4 address")
+	}
+
+	return interfaces, nil
+}
+
+func interfaceIPv4(iface net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip := ipNet.IP.To4(); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no IPv4 address", iface.Name)
+}
+
+// Sends the scan from the interface's address with the interface as the multicast egress
+func sendOnInterface(reqData []byte, group *net.UDPAddr, iface net.Interface) error {
+	ip, err := interfaceIPv4(iface)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip, Port: 0})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := setMulticastInterface(conn, ip); err != nil {
+		return err
+	}
+
+	_, err = conn.WriteToUDP(reqData, group)
+	return err
+}
+
+func sendUnicastScans(reqData []byte, targets []netip.Addr) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	defer conn.Close()
+
+	for _, target := range targets {
+		addr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(target, scanPort))
+		if _, err := conn.WriteToUDP(reqData, addr); err != nil {
+			return fmt.Errorf("failed to send scan request to %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+// Expands IPv4 addresses and CIDR subnets into the host addresses to probe
+func expandTargets(targets []string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+
+		if !strings.Contains(target, "/") {
+			addr, err := netip.ParseAddr(target)
+			if err != nil || !addr.Is4() {
+				return nil, fmt.Errorf("invalid scan target %q: expected an IPv4 address or subnet", target)
+			}
+			addrs = append(addrs, addr)
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil || !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("invalid scan target %q: expected an IPv4 address or subnet", target)
+		}
+		prefix = prefix.Masked()
+
+		if hostBits := 32 - prefix.Bits(); hostBits > 10 {
+			return nil, fmt.Errorf("scan target %s is too large: at most %d addresses are probed", target, maxTargetHosts)
+		}
+
+		// Skip the network and broadcast addresses of subnets that have them
+		first, last := prefix.Addr(), lastAddr(prefix)
+		if prefix.Bits() < 31 {
+			first, last = first.Next(), last.Prev()
+		}
+
+		for addr := first; addr.Compare(last) <= 0; addr = addr.Next() {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs, nil
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().As4()
+	host := uint32(1)<<(32-prefix.Bits()) - 1
+	v := (uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])) | host
+
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lan
+
+import "net"
+
+// Binding the socket to the interface address is all that can be done portably here; the
+// operating system still picks the multicast egress interface.
+func setMulticastInterface(conn *net.UDPConn, ip net.IP) error {
+	return nil
+}
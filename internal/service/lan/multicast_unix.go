@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package lan
+
+import (
+	"net"
+	"syscall"
+)
+
+// Makes multicast packets sent on conn leave through the interface that owns ip
+func setMulticastInterface(conn *net.UDPConn, ip net.IP) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var addr [4]byte
+	copy(addr[:], ip.To4())
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
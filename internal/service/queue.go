@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+)
+
+// Default minimum time between two LAN commands to the same device. Bulbs drop packets that
+// arrive in quick succession.
+const DefaultLANCommandGap = 100 * time.Millisecond
+
+// Longest a queued command may run, covering LAN discovery and the retries of a cloud fallback.
+// Commands run on a context owned by the queue, so one caller giving up does not cancel a
+// command other callers still wait for.
+const controlCommandTimeout = time.Minute
+
+// The commands waiting for one device. A queue is removed once it is drained and the LAN
+// command gap after its last command has passed; until then a new command reuses it and waits
+// out the gap.
+type deviceQueue struct {
+	pending []*queuedCommand
+	running bool
+	// Time of the last command sent over the LAN
+	lastLAN time.Time
+}
+
+// A command waiting in a device queue. A newer command for the same capability, transport and
+// client supplied key replaces the value of a pending one at the end of the queue, and every
+// caller that queued it gets the outcome of the newest.
+type queuedCommand struct {
+	// Values of the first caller's context, such as its logger and API key, without its cancellation
+	ctx        context.Context
+	sku        string
+	capability ControlCapability
+	transport  string
+	// Intermediate commands, such as transition steps, are neither published nor recorded
+	silent  bool
+	waiters int
+
+	done   chan struct{}
+	result *ControlResult
+	err    error
+}
+
+// Sets the minimum time between two LAN commands to the same device, 0 to send at once. Call
+// it before the service is used.
+func (s *GoveeService) SetLANCommandGap(gap time.Duration) {
+	s.lanCommandGap = gap
+}
+
+// Reports whether a new command may replace the value of a pending one. Commands only merge
+// when they would be delivered the same way: over the same transport with the same client
+// supplied key, so a LAN request is never answered by a cloud delivery and no caller's command
+// is sent with another caller's key.
+func (c *queuedCommand) coalesces(ctx context.Context, capability ControlCapability, transport string, silent bool) bool {
+	return c.silent == silent &&
+		c.capability.Type == capability.Type &&
+		c.capability.Instance == capability.Instance &&
+		requestedTransport(c.transport) == requestedTransport(transport) &&
+		APIKeyFromContext(c.ctx) == APIKeyFromContext(ctx)
+}
+
+func requestedTransport(transport string) string {
+	if transport == "" {
+		return TransportAuto
+	}
+	return transport
+}
+
+// Queues a command for the device and waits for its outcome. Commands to one device are sent
+// in order, one at a time. The outcome of a silent command is not published.
+func (s *GoveeService) enqueueControl(ctx context.Context, sku string, deviceID string, capability ControlCapability, transport string, silent bool) (*ControlResult, error) {
+	s.queuesMu.Lock()
+	queue, ok := s.queues[deviceID]
+	if !ok {
+		queue = &deviceQueue{}
+		s.queues[deviceID] = queue
+	}
+
+	// Only the last pending command is replaced, so a command never moves past a later command
+	// of another kind: on, brightness, off stays in that order
+	var command *queuedCommand
+	if n := len(queue.pending); n > 0 && queue.pending[n-1].coalesces(ctx, capability, transport, silent) {
+		command = queue.pending[n-1]
+	}
+
+	if command != nil {
+		// Last write wins: the pending command keeps its place in the queue with the new value
+		command.sku = sku
+		command.capability = capability
+		command.waiters++
+	} else {
+		command = &queuedCommand{
+			ctx:        context.WithoutCancel(ctx),
+			sku:        sku,
+			capability: capability,
+			transport:  transport,
+			silent:     silent,
+			waiters:    1,
+			done:       make(chan struct{}),
+		}
+		queue.pending = append(queue.pending, command)
+	}
+
+	if !queue.running {
+		queue.running = true
+		go s.runQueue(deviceID, queue)
+	}
+	s.queuesMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		s.abandonControl(queue, command)
+		return nil, ctx.Err()
+	case <-command.done:
+		return command.result, command.err
+	}
+}
+
+// Drops a caller that stopped waiting. A pending command nobody waits for any more is removed
+// from the queue; one that is already running completes.
+func (s *GoveeService) abandonControl(queue *deviceQueue, command *queuedCommand) {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+
+	command.waiters--
+	if command.waiters > 0 {
+		return
+	}
+
+	for i, pending := range queue.pending {
+		if pending == command {
+			queue.pending = append(queue.pending[:i], queue.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sends the queued commands of a device until its queue is empty
+func (s *GoveeService) runQueue(deviceID string, queue *deviceQueue) {
+	for {
+		s.queuesMu.Lock()
+		wait := s.lanCommandGap - time.Since(queue.lastLAN)
+
+		if len(queue.pending) == 0 {
+			queue.running = false
+
+			// Kept until the LAN command gap has passed, so a command queued right after still
+			// waits for it. The gap is only waited for by a command, on the command's context.
+			if wait > 0 {
+				time.AfterFunc(wait, func() { s.removeIdleQueue(deviceID, queue) })
+			} else {
+				// Removed under the same lock enqueueControl takes, so a new command starts a new queue
+				delete(s.queues, deviceID)
+			}
+			s.queuesMu.Unlock()
+			return
+		}
+
+		command := queue.pending[0]
+		queue.pending = queue.pending[1:]
+		ctx, sku, capability, transport, silent, waiters := command.ctx, command.sku, command.capability, command.transport, command.silent, command.waiters
+		s.queuesMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(ctx, controlCommandTimeout)
+
+		var result *ControlResult
+		var err error
+		if transport != TransportCloud && s.useLAN && wait > 0 {
+			err = sleepContext(ctx, wait)
+		}
+
+		if err == nil {
+			result, err = s.controlDevice(ctx, sku, deviceID, capability, transport)
+		}
+		cancel()
+
+		if err == nil {
+			result.Coalesced = waiters - 1
+
+			if result.Transport == TransportLAN {
+				s.queuesMu.Lock()
+				queue.lastLAN = time.Now()
+				s.queuesMu.Unlock()
+			}
+		}
+
+		if !silent {
+			s.publishControl(sku, deviceID, capability, result, err)
+		}
+
+		command.result, command.err = result, err
+		close(command.done)
+	}
+}
+
+// Removes a drained queue whose LAN command gap has passed, unless a new command reused it
+func (s *GoveeService) removeIdleQueue(deviceID string, queue *deviceQueue) {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+
+	if s.queues[deviceID] == queue && !queue.running && len(queue.pending) == 0 {
+		delete(s.queues, deviceID)
+	}
+}
+
+// Publishes the outcome of a control command on the event bus
+func (s *GoveeService) publishControl(sku string, deviceID string, capability ControlCapability, result *ControlResult, err error) {
+	event := events.Event{
+		Type:       events.TypeControlSucceeded,
+		Source:     "control",
+		SKU:        sku,
+		Device:     deviceID,
+		Capability: capability.Type,
+		Instance:   capability.Instance,
+	}
+	data := ControlEventData{Value: capability.Value}
+
+	if err != nil {
+		event.Type = events.TypeControlFailed
+		data.ErrorCode, data.Error = DescribeError(err)
+	} else {
+		data.Transport = result.Transport
+		data.LANError = result.LANError
+		data.LatencyMs = float64(result.Latency.Microseconds()) / 1000
+	}
+
+	event.Data = data
+	s.events.Publish(event)
+}
@@ -0,0 +1,156 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/service"
+)
+
+const queueDevice = "AA:00:00:00:00:00:00:01"
+
+// A cloud API that records the control requests it receives. The first request blocks until
+// release is closed, so the following commands pile up in the device queue.
+type controlRecorder struct {
+	mu       sync.Mutex
+	requests []string
+	started  chan struct{}
+	release  chan struct{}
+	once     sync.Once
+}
+
+func newControlRecorder(t *testing.T) (*controlRecorder, *service.GoveeService) {
+	t.Helper()
+
+	recorder := &controlRecorder{started: make(chan struct{}), release: make(chan struct{})}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request service.ControlRequest
+		json.NewDecoder(r.Body).Decode(&request)
+
+		capability := request.Payload.Capability
+		recorder.mu.Lock()
+		recorder.requests = append(recorder.requests, fmt.Sprintf("%s=%v key=%s", capability.Instance, capability.Value, r.Header.Get("Govee-API-Key")))
+		recorder.mu.Unlock()
+
+		first := false
+		recorder.once.Do(func() { first = true })
+		if first {
+			close(recorder.started)
+			<-recorder.release
+		}
+
+		json.NewEncoder(w).Encode(service.ControlResponse{Code: 200, Message: "success"})
+	}))
+	t.Cleanup(server.Close)
+
+	svc := service.NewGoveeService([]service.Account{{Name: "home", APIKey: "configured"}}, false)
+	svc.SetCloudURL(server.URL)
+
+	return recorder, svc
+}
+
+func (c *controlRecorder) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.requests...)
+}
+
+func power(on bool) service.ControlCapability {
+	value := 0.0
+	if on {
+		value = 1
+	}
+	return service.ControlCapability{Type: "devices.capabilities.on_off", Instance: "powerSwitch", Value: value}
+}
+
+func brightness(value float64) service.ControlCapability {
+	return service.ControlCapability{Type: "devices.capabilities.range", Instance: "brightness", Value: value}
+}
+
+// Queues commands one after the other while the first one is held by the cloud API
+func queueCommands(t *testing.T, recorder *controlRecorder, svc *service.GoveeService, commands []func() (*service.ControlResult, error)) []*service.ControlResult {
+	t.Helper()
+
+	results := make([]*service.ControlResult, len(commands))
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := command()
+			if err != nil {
+				t.Errorf("command %d: %v", i, err)
+			}
+			results[i] = result
+		}()
+
+		if i == 0 {
+			<-recorder.started
+		} else {
+			// Lets the command reach the queue before the next one is sent
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	close(recorder.release)
+	wg.Wait()
+	return results
+}
+
+func TestQueueCoalescesOnlyTheLastCommand(t *testing.T) {
+	recorder, svc := newControlRecorder(t)
+	ctx := context.Background()
+
+	control := func(capability service.ControlCapability) func() (*service.ControlResult, error) {
+		return func() (*service.ControlResult, error) {
+			return svc.ControlDevice(ctx, "H6022", queueDevice, capability, service.TransportCloud)
+		}
+	}
+
+	results := queueCommands(t, recorder, svc, []func() (*service.ControlResult, error){
+		control(brightness(10)),
+		control(power(true)),
+		control(brightness(20)),
+		control(brightness(30)),
+		control(power(false)),
+	})
+
+	want := []string{"brightness=10 key=configured", "powerSwitch=1 key=configured", "brightness=30 key=configured", "powerSwitch=0 key=configured"}
+	if got := recorder.sent(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+
+	if results[3] == nil || results[3].Coalesced != 1 {
+		t.Errorf("brightness 30 result %+v, want one coalesced command", results[3])
+	}
+}
+
+func TestQueueKeepsCallersApart(t *testing.T) {
+	recorder, svc := newControlRecorder(t)
+
+	control := func(apiKey string, value float64, transport string) func() (*service.ControlResult, error) {
+		return func() (*service.ControlResult, error) {
+			ctx := service.WithAPIKey(context.Background(), apiKey)
+			return svc.ControlDevice(ctx, "H6022", queueDevice, brightness(value), transport)
+		}
+	}
+
+	queueCommands(t, recorder, svc, []func() (*service.ControlResult, error){
+		control("", 10, service.TransportCloud),
+		control("alice", 20, service.TransportCloud),
+		control("bob", 30, service.TransportCloud),
+		control("bob", 40, service.TransportAuto),
+	})
+
+	want := []string{"brightness=10 key=configured", "brightness=20 key=alice", "brightness=30 key=bob", "brightness=40 key=bob"}
+	if got := recorder.sent(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
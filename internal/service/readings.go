@@ -0,0 +1,401 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// Metrics a reading can hold
+const (
+	MetricTemperature = "temperature"
+	MetricHumidity    = "humidity"
+	MetricBattery     = "battery"
+	MetricPM25        = "pm25"
+	MetricWaterLevel  = "waterLevel"
+	MetricFilterLife  = "filterLife"
+)
+
+// Default number of readings kept per device, a day at the default poll interval of 5 minutes
+const DefaultReadingHistory = 288
+
+var ErrUnknownMetric = errors.New("unknown metric")
+
+// Device types whose readings are polled. Lights and sockets have nothing to read.
+var readingTypes = map[string]bool{
+	DeviceTypeThermometer:   true,
+	DeviceTypeSensor:        true,
+	DeviceTypeHumidifier:    true,
+	DeviceTypeDehumidifier:  true,
+	DeviceTypeAirPurifier:   true,
+	DeviceTypeHeater:        true,
+	DeviceTypeIceMaker:      true,
+	DeviceTypeAromaDiffuser: true,
+}
+
+// Sensor values of a device at one point in time. Values the device does not report are nil.
+// Temperatures are in degrees Celsius; humidity, battery, water level and filter life are
+// percentages; PM2.5 is in µg/m³.
+type Reading struct {
+	SKU         string    `json:"sku"`
+	Device      string    `json:"device"`
+	Time        time.Time `json:"time"`
+	Online      *bool     `json:"online,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Humidity    *float64  `json:"humidity,omitempty"`
+	Battery     *float64  `json:"battery,omitempty"`
+	PM25        *float64  `json:"pm25,omitempty"`
+	WaterLevel  *float64  `json:"waterLevel,omitempty"`
+	FilterLife  *float64  `json:"filterLife,omitempty"`
+}
+
+// Returns the value of a metric, or false when the reading does not hold it
+func (r Reading) Metric(metric string) (float64, bool) {
+	var value *float64
+	switch metric {
+	case MetricTemperature:
+		value = r.Temperature
+	case MetricHumidity:
+		value = r.Humidity
+	case MetricBattery:
+		value = r.Battery
+	case MetricPM25:
+		value = r.PM25
+	case MetricWaterLevel:
+		value = r.WaterLevel
+	case MetricFilterLife:
+		value = r.FilterLife
+	}
+
+	if value == nil {
+		return 0, false
+	}
+	return *value, true
+}
+
+// Reports whether metric names a value a reading can hold
+func ValidMetric(metric string) bool {
+	switch metric {
+	case MetricTemperature, MetricHumidity, MetricBattery, MetricPM25, MetricWaterLevel, MetricFilterLife:
+		return true
+	}
+	return false
+}
+
+// Extracts a number from a state value. Some properties report an object such as
+// {"currentHumidity": 44} instead of a plain number; the first numeric field is used then.
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if f, ok := numericValue(v[key]); ok {
+				return f, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Builds a reading from the capabilities of a cloud state response
+func readingFromState(sku string, deviceID string, capabilities []stateCapability) Reading {
+	reading := Reading{SKU: sku, Device: deviceID, Time: time.Now()}
+
+	for _, capability := range capabilities {
+		if capability.Instance == "online" {
+			online, ok := capability.State.Value.(bool)
+			if ok {
+				reading.Online = &online
+			}
+			continue
+		}
+
+		value, ok := numericValue(capability.State.Value)
+		if !ok {
+			continue
+		}
+
+		switch capability.Instance {
+		case "sensorTemperature":
+			// Govee reports sensor temperatures in Fahrenheit
+			celsius := math.Round((value-32)*5/9*100) / 100
+			reading.Temperature = &celsius
+		case "sensorHumidity", "humidity":
+			reading.Humidity = &value
+		case "battery":
+			reading.Battery = &value
+		case "pm25", "airQuality":
+			reading.PM25 = &value
+		case "waterLevel":
+			reading.WaterLevel = &value
+		case "filterLifeTime", "filterLife":
+			reading.FilterLife = &value
+		}
+	}
+
+	return reading
+}
+
+// Sets how many readings are kept per device. Call it before the service is used.
+func (s *GoveeService) SetReadingHistory(size int) {
+	s.readingHistory = size
+}
+
+// Queries the sensor values of a device through the cloud state API and adds them to its history.
+// A reading made with a client supplied key is only returned: the history, its events and the
+// alerts are shared, so one client's devices are never shown to another.
+func (s *GoveeService) QueryReadings(ctx context.Context, sku string, deviceID string) (Reading, error) {
+	capabilities, err := s.fetchCloudState(ctx, sku, deviceID)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	reading := readingFromState(sku, deviceID, capabilities)
+	if APIKeyFromContext(ctx) == "" {
+		s.recordReading(reading)
+	}
+
+	return reading, nil
+}
+
+func (s *GoveeService) recordReading(reading Reading) {
+	s.readingsMu.Lock()
+	history := s.readings[reading.Device]
+	if len(history) >= s.readingHistory && len(history) > 0 {
+		history = history[len(history)-s.readingHistory+1:]
+	}
+	s.readings[reading.Device] = append(history, reading)
+	s.readingsMu.Unlock()
+
+	s.events.Publish(events.Event{
+		Type:   events.TypeDeviceReading,
+		Source: "cloud",
+		SKU:    reading.SKU,
+		Device: reading.Device,
+		Data:   reading,
+	})
+
+	s.evaluateAlerts(reading)
+}
+
+// Returns the latest reading of every device with readings, sorted by device ID
+func (s *GoveeService) LatestReadings() []Reading {
+	s.readingsMu.Lock()
+	defer s.readingsMu.Unlock()
+
+	readings := make([]Reading, 0, len(s.readings))
+	for _, history := range s.readings {
+		if len(history) > 0 {
+			readings = append(readings, history[len(history)-1])
+		}
+	}
+
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Device < readings[j].Device
+	})
+
+	return readings
+}
+
+// Returns the readings of a device taken at or after since, oldest first
+func (s *GoveeService) ReadingHistory(deviceID string, since time.Time) []Reading {
+	s.readingsMu.Lock()
+	defer s.readingsMu.Unlock()
+
+	history := s.readings[deviceID]
+	start := sort.Search(len(history), func(i int) bool {
+		return !history[i].Time.Before(since)
+	})
+
+	return append([]Reading{}, history[start:]...)
+}
+
+// Polls the readings of every cloud device with sensors at every interval until ctx is
+// cancelled. Each poll costs one request of the Govee API quota per device.
+func (s *GoveeService) RunReadingPolling(ctx context.Context, interval time.Duration) {
+	logger := logging.FromContext(ctx)
+
+	poll := func() {
+		for _, device := range s.Inventory(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !device.Cloud || !readingTypes[device.Type] {
+				continue
+			}
+
+			if _, err := s.QueryReadings(ctx, device.SKU, device.Device); err != nil {
+				logger.Debug("Failed to poll device readings", "device", device.Device, "error", err)
+			}
+		}
+	}
+
+	poll()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll()
+		}
+	}
+}
+
+// A threshold on a metric. The alert fires when the value rises above Above or drops below
+// Below, and clears when it is back within the bounds. An empty Device matches every device.
+type AlertRule struct {
+	Name   string   `json:"name"`
+	Device string   `json:"device,omitempty"`
+	Metric string   `json:"metric"`
+	Above  *float64 `json:"above,omitempty"`
+	Below  *float64 `json:"below,omitempty"`
+}
+
+// The state of an alert rule for one device
+type AlertState struct {
+	Rule   string    `json:"rule"`
+	Device string    `json:"device"`
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+	Firing bool      `json:"firing"`
+	Since  time.Time `json:"since"`
+}
+
+// Data of reading.alert and reading.alert_cleared events
+type AlertEventData struct {
+	Rule   string   `json:"rule"`
+	Metric string   `json:"metric"`
+	Value  float64  `json:"value"`
+	Above  *float64 `json:"above,omitempty"`
+	Below  *float64 `json:"below,omitempty"`
+}
+
+// Replaces the alert rules. Call it before the service is used.
+func (s *GoveeService) SetAlertRules(rules []AlertRule) error {
+	for _, rule := range rules {
+		if !ValidMetric(rule.Metric) {
+			return fmt.Errorf("alert %s: %w %q", rule.Name, ErrUnknownMetric, rule.Metric)
+		}
+
+		if rule.Above == nil && rule.Below == nil {
+			return fmt.Errorf("alert %s needs above or below", rule.Name)
+		}
+	}
+
+	s.readingsMu.Lock()
+	s.alertRules = rules
+	s.alertStates = make(map[string]*AlertState)
+	s.readingsMu.Unlock()
+
+	return nil
+}
+
+// Returns the alert rules and their state per device
+func (s *GoveeService) Alerts() ([]AlertRule, []AlertState) {
+	s.readingsMu.Lock()
+	defer s.readingsMu.Unlock()
+
+	states := make([]AlertState, 0, len(s.alertStates))
+	for _, state := range s.alertStates {
+		states = append(states, *state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Rule != states[j].Rule {
+			return states[i].Rule < states[j].Rule
+		}
+		return states[i].Device < states[j].Device
+	})
+
+	return append([]AlertRule{}, s.alertRules...), states
+}
+
+// Checks a reading against the alert rules and publishes an event when an alert starts or
+// stops firing
+func (s *GoveeService) evaluateAlerts(reading Reading) {
+	var published []events.Event
+
+	s.readingsMu.Lock()
+	for _, rule := range s.alertRules {
+		if rule.Device != "" && rule.Device != reading.Device {
+			continue
+		}
+
+		value, ok := reading.Metric(rule.Metric)
+		if !ok {
+			continue
+		}
+
+		firing := (rule.Above != nil && value > *rule.Above) || (rule.Below != nil && value < *rule.Below)
+
+		key := rule.Name + "/" + reading.Device
+		state, known := s.alertStates[key]
+		if !known {
+			state = &AlertState{Rule: rule.Name, Device: reading.Device, Metric: rule.Metric, Since: reading.Time}
+			s.alertStates[key] = state
+		}
+
+		changed := state.Firing != firing
+		state.Value = value
+		if changed {
+			state.Firing = firing
+			state.Since = reading.Time
+		}
+
+		// A rule that is fine on its first reading has nothing to report
+		if !changed {
+			continue
+		}
+
+		eventType := events.TypeAlertFiring
+		if !firing {
+			eventType = events.TypeAlertCleared
+		}
+
+		published = append(published, events.Event{
+			Type:   eventType,
+			Source: "readings",
+			SKU:    reading.SKU,
+			Device: reading.Device,
+			Data: AlertEventData{
+				Rule:   rule.Name,
+				Metric: rule.Metric,
+				Value:  value,
+				Above:  rule.Above,
+				Below:  rule.Below,
+			},
+		})
+	}
+	s.readingsMu.Unlock()
+
+	for _, event := range published {
+		s.events.Publish(event)
+	}
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/fakecloud"
+)
+
+const thermometer = "6B:20:A4:C1:38:5F:E3:1D"
+
+func capability(instance string, value interface{}) stateCapability {
+	c := stateCapability{Type: "devices.capabilities.property", Instance: instance}
+	c.State.Value = value
+	return c
+}
+
+// Formats an optional value for comparison
+func optional(value *float64) string {
+	if value == nil {
+		return "none"
+	}
+	return fmt.Sprint(*value)
+}
+
+// Returns the types of the events published so far
+func drain(sub *events.Subscription) []string {
+	var types []string
+	for {
+		select {
+		case event := <-sub.Events():
+			types = append(types, event.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestNumericValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{"number", 44.5, 44.5, true},
+		{"json number", json.Number("87"), 87, true},
+		{"numeric string", "12.5", 12.5, true},
+		{"other string", "high", 0, false},
+		{"object", map[string]interface{}{"currentHumidity": 44.0}, 44, true},
+		{"object with a name", map[string]interface{}{"unit": "percent", "current": 61.0}, 61, true},
+		{"first numeric field by name", map[string]interface{}{"b": 2.0, "a": 1.0}, 1, true},
+		{"nested object", map[string]interface{}{"value": map[string]interface{}{"level": 3.0}}, 3, true},
+		{"object without numbers", map[string]interface{}{"mode": "auto"}, 0, false},
+		{"bool", true, 0, false},
+		{"nil", nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := numericValue(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReadingFromState(t *testing.T) {
+	reading := readingFromState("H5179", thermometer, []stateCapability{
+		capability("online", true),
+		capability("sensorTemperature", 71.6),
+		capability("sensorHumidity", map[string]interface{}{"currentHumidity": 44.0}),
+		capability("battery", "87"),
+		capability("powerSwitch", 1.0),
+		capability("filterLife", "unknown"),
+	})
+
+	if reading.SKU != "H5179" || reading.Device != thermometer || reading.Time.IsZero() {
+		t.Errorf("reading of %s %s at %s, want H5179 %s now", reading.SKU, reading.Device, reading.Time, thermometer)
+	}
+	if reading.Online == nil || !*reading.Online {
+		t.Errorf("online %v, want true", reading.Online)
+	}
+
+	// Temperatures come in Fahrenheit and are kept in Celsius
+	tests := []struct {
+		metric string
+		value  *float64
+		want   string
+	}{
+		{MetricTemperature, reading.Temperature, "22"},
+		{MetricHumidity, reading.Humidity, "44"},
+		{MetricBattery, reading.Battery, "87"},
+		{MetricPM25, reading.PM25, "none"},
+		{MetricWaterLevel, reading.WaterLevel, "none"},
+		{MetricFilterLife, reading.FilterLife, "none"},
+	}
+
+	for _, tt := range tests {
+		if got := optional(tt.value); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.metric, got, tt.want)
+		}
+	}
+}
+
+func TestReadingFromStateRoundsCelsius(t *testing.T) {
+	reading := readingFromState("H5179", thermometer, []stateCapability{capability("sensorTemperature", 70.0)})
+
+	if got := optional(reading.Temperature); got != "21.11" {
+		t.Errorf("70°F is %s°C, want 21.11", got)
+	}
+}
+
+func TestRecordReadingKeepsHistorySize(t *testing.T) {
+	s := NewGoveeService(nil, false)
+	s.SetReadingHistory(3)
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		battery := float64(i)
+		s.recordReading(Reading{Device: thermometer, Time: start.Add(time.Duration(i) * time.Minute), Battery: &battery})
+	}
+
+	history := s.ReadingHistory(thermometer, time.Time{})
+	if len(history) != 3 {
+		t.Fatalf("kept %d readings, want 3", len(history))
+	}
+	for i, reading := range history {
+		if want := fmt.Sprint(i + 2); optional(reading.Battery) != want {
+			t.Errorf("reading %d has battery %s, want %s", i, optional(reading.Battery), want)
+		}
+	}
+
+	if got := s.ReadingHistory(thermometer, start.Add(4*time.Minute)); len(got) != 1 {
+		t.Errorf("got %d readings since the last one, want 1", len(got))
+	}
+	if latest := s.LatestReadings(); len(latest) != 1 || optional(latest[0].Battery) != "4" {
+		t.Errorf("latest readings %+v, want the one with battery 4", latest)
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	s := NewGoveeService(nil, false)
+	above, below := 25.0, 10.0
+	err := s.SetAlertRules([]AlertRule{
+		{Name: "warm", Metric: MetricTemperature, Above: &above},
+		{Name: "cold", Device: "other", Metric: MetricTemperature, Below: &below},
+		{Name: "low-battery", Metric: MetricBattery, Below: &below},
+	})
+	if err != nil {
+		t.Fatalf("SetAlertRules: %v", err)
+	}
+
+	sub := s.Events().Subscribe(16)
+	defer sub.Close()
+
+	// Each step records a temperature and lists the alert events it should publish
+	steps := []struct {
+		temperature float64
+		want        string
+	}{
+		{20, ""},
+		{26, events.TypeAlertFiring},
+		{27, ""},
+		{25, events.TypeAlertCleared},
+		{5, ""},
+	}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, step := range steps {
+		temperature := step.temperature
+		s.recordReading(Reading{SKU: "H5179", Device: thermometer, Time: start.Add(time.Duration(i) * time.Minute), Temperature: &temperature})
+
+		var got string
+		for _, eventType := range drain(sub) {
+			if eventType != events.TypeDeviceReading {
+				got += eventType
+			}
+		}
+		if got != step.want {
+			t.Errorf("step %d at %v: got events %q, want %q", i, step.temperature, got, step.want)
+		}
+	}
+
+	_, states := s.Alerts()
+	if len(states) != 1 {
+		t.Fatalf("got %d alert states, want 1 for the warm rule", len(states))
+	}
+	state := states[0]
+	if state.Rule != "warm" || state.Firing || state.Value != 5 || !state.Since.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("alert state %+v, want warm, not firing, value 5, since the reading that cleared it", state)
+	}
+}
+
+func TestQueryReadingsWithClientKey(t *testing.T) {
+	server := httptest.NewServer(fakecloud.New(fakecloud.Options{}))
+	defer server.Close()
+
+	s := NewGoveeService([]Account{{Name: "home", APIKey: "key"}}, false)
+	s.SetCloudURL(server.URL)
+
+	sub := s.Events().Subscribe(16)
+	defer sub.Close()
+
+	// A reading made with a client's own key is returned but not shared
+	reading, err := s.QueryReadings(WithAPIKey(context.Background(), "client"), "H5179", thermometer)
+	if err != nil {
+		t.Fatalf("QueryReadings with a client key: %v", err)
+	}
+	if optional(reading.Temperature) != "22" {
+		t.Errorf("temperature %s, want 22", optional(reading.Temperature))
+	}
+	if history := s.ReadingHistory(thermometer, time.Time{}); len(history) != 0 {
+		t.Errorf("reading with a client key was recorded: %+v", history)
+	}
+	if published := drain(sub); len(published) != 0 {
+		t.Errorf("reading with a client key published %v", published)
+	}
+
+	// A reading of the configured account goes into the history
+	if _, err := s.QueryReadings(context.Background(), "H5179", thermometer); err != nil {
+		t.Fatalf("QueryReadings: %v", err)
+	}
+	if history := s.ReadingHistory(thermometer, time.Time{}); len(history) != 1 {
+		t.Errorf("got %d readings in the history, want 1", len(history))
+	}
+}
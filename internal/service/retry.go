@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// How calls to the Govee cloud API are bounded and retried
+type CloudPolicy struct {
+	// Upper bound of a single attempt, including reading the response
+	Timeout time.Duration
+	// Attempts after the first one for upstream failures and short rate limits
+	Retries int
+	// Backoff before the first retry, doubled for every further retry and jittered
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// Consecutive upstream failures that open the circuit breaker, 0 to disable it
+	BreakerThreshold int
+	// How long the breaker stays open before a probe call is let through
+	BreakerCooldown time.Duration
+}
+
+func DefaultCloudPolicy() CloudPolicy {
+	return CloudPolicy{
+		Timeout:          10 * time.Second,
+		Retries:          2,
+		RetryDelay:       250 * time.Millisecond,
+		MaxRetryDelay:    5 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// Replaces the timeout, retry and circuit breaker settings of cloud calls. Call it before the
+// service is used.
+func (s *GoveeService) SetCloudPolicy(policy CloudPolicy) {
+	s.policy = policy
+	s.breaker = &circuitBreaker{threshold: policy.BreakerThreshold, cooldown: policy.BreakerCooldown}
+}
+
+// Returns the state of the cloud circuit breaker
+func (s *GoveeService) CloudBreaker() BreakerStatus {
+	return s.breaker.status()
+}
+
+// Returns how long to wait before the given retry, or false when the error is not worth
+// retrying. Rate limits are only retried when Govee asks for a wait shorter than the maximum
+// backoff; longer limits are left to the caller.
+func (p CloudPolicy) retryDelay(retry int, err error) (time.Duration, bool) {
+	if retry > p.Retries {
+		return 0, false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && errors.Is(err, ErrRateLimited) {
+		if apiErr.RetryAfter <= 0 || apiErr.RetryAfter > p.MaxRetryDelay {
+			return 0, false
+		}
+		return apiErr.RetryAfter, true
+	}
+
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		return 0, false
+	}
+
+	delay := p.RetryDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxRetryDelay {
+		delay = p.MaxRetryDelay
+	}
+
+	// Full jitter keeps many clients from retrying in lockstep
+	return time.Duration(rand.Int64N(int64(delay) + 1)), true
+}
+
+// Waits for the delay, returning early with the context's error when it is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryDelayBackoff(t *testing.T) {
+	policy := CloudPolicy{Retries: 4, RetryDelay: 100 * time.Millisecond, MaxRetryDelay: 300 * time.Millisecond}
+
+	// Doubled for every retry and capped, then drawn from zero up to that bound
+	bounds := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+
+	for i, bound := range bounds {
+		retry := i + 1
+		seen := map[time.Duration]bool{}
+
+		for n := 0; n < 200; n++ {
+			delay, ok := policy.retryDelay(retry, errUpstream)
+			if !ok {
+				t.Fatalf("retry %d: not retried", retry)
+			}
+			if delay < 0 || delay > bound {
+				t.Fatalf("retry %d: delay %v outside [0, %v]", retry, delay, bound)
+			}
+			seen[delay] = true
+		}
+
+		if len(seen) < 2 {
+			t.Errorf("retry %d: all delays were equal, want jitter", retry)
+		}
+	}
+
+	if _, ok := policy.retryDelay(policy.Retries+1, errUpstream); ok {
+		t.Errorf("retried past %d retries", policy.Retries)
+	}
+}
+
+func TestRetryDelayErrors(t *testing.T) {
+	policy := CloudPolicy{Retries: 2, RetryDelay: 100 * time.Millisecond, MaxRetryDelay: 5 * time.Second}
+
+	tests := []struct {
+		name  string
+		err   error
+		delay time.Duration
+		ok    bool
+	}{
+		{"Retry-After below the maximum", &APIError{Status: 429, RetryAfter: 2 * time.Second}, 2 * time.Second, true},
+		{"Retry-After at the maximum", &APIError{Status: 429, RetryAfter: 5 * time.Second}, 5 * time.Second, true},
+		{"Retry-After above the maximum", &APIError{Status: 429, RetryAfter: 6 * time.Second}, 0, false},
+		{"rate limit without Retry-After", &APIError{Status: 429}, 0, false},
+		{"wrapped Retry-After", fmt.Errorf("cloud control: %w", &APIError{Status: 429, RetryAfter: time.Second}), time.Second, true},
+		{"unknown device", &APIError{Status: 400, Message: "devices not exist"}, 0, false},
+		{"rejected key", &APIError{Status: 401}, 0, false},
+		{"cancelled", context.Canceled, 0, false},
+	}
+
+	for _, tt := range tests {
+		delay, ok := policy.retryDelay(1, tt.err)
+		if delay != tt.delay || ok != tt.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, delay, ok, tt.delay, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Snapshots are kept in memory; the oldest is dropped once the limit is reached
+const maxSnapshots = 100
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Identifies a device by model and ID
+type DeviceRef struct {
+	SKU    string `json:"sku"`
+	Device string `json:"device"`
+}
+
+// Captured state of one device in a snapshot
+type DeviceSnapshot struct {
+	SKU       string       `json:"sku"`
+	Device    string       `json:"device"`
+	State     *DeviceState `json:"state,omitempty"`
+	Transport string       `json:"transport,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	ErrorCode string       `json:"errorCode,omitempty"`
+}
+
+// Power, brightness and color of a set of devices at one point in time
+type Snapshot struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Devices   []DeviceSnapshot `json:"devices"`
+}
+
+// Outcome of restoring one device
+type RestoreResult struct {
+	SKU       string `json:"sku"`
+	Device    string `json:"device"`
+	Success   bool   `json:"success"`
+	Transport string `json:"transport,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+// Captures the current state of the given devices. Devices whose state cannot be read are
+// recorded with their error and skipped when the snapshot is restored.
+func (s *GoveeService) TakeSnapshot(ctx context.Context, name string, devices []DeviceRef) (Snapshot, error) {
+	if len(devices) == 0 {
+		return Snapshot{}, errors.New("at least one device is required")
+	}
+
+	snapshot := Snapshot{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now(),
+		Devices:   make([]DeviceSnapshot, len(devices)),
+	}
+	errs := make([]error, len(devices))
+
+	var wg sync.WaitGroup
+	for i, ref := range devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			entry := DeviceSnapshot{SKU: ref.SKU, Device: ref.Device}
+			state, transport, err := s.QueryState(ctx, ref.SKU, ref.Device)
+			if err != nil {
+				logging.FromContext(ctx).Warn("Failed to capture device state", "device", ref.Device, "error", err)
+				entry.ErrorCode, entry.Error = DescribeError(err)
+				errs[i] = err
+			} else {
+				entry.State = &state
+				entry.Transport = transport
+			}
+
+			snapshot.Devices[i] = entry
+		}()
+	}
+	wg.Wait()
+
+	captured := 0
+	for _, entry := range snapshot.Devices {
+		if entry.State != nil {
+			captured++
+		}
+	}
+
+	if captured == 0 {
+		return Snapshot{}, fmt.Errorf("could not read the state of any device: %w", errs[0])
+	}
+
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+
+	if len(s.snapshots) >= maxSnapshots {
+		oldest := ""
+		for id, existing := range s.snapshots {
+			if oldest == "" || existing.CreatedAt.Before(s.snapshots[oldest].CreatedAt) {
+				oldest = id
+			}
+		}
+		delete(s.snapshots, oldest)
+	}
+	s.snapshots[snapshot.ID] = snapshot
+
+	logging.FromContext(ctx).Info("Took snapshot", "snapshot", snapshot.ID, "devices", len(devices), "captured", captured)
+
+	return snapshot, nil
+}
+
+// Returns all snapshots, newest first
+func (s *GoveeService) Snapshots() []Snapshot {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+
+	snapshots := make([]Snapshot, 0, len(s.snapshots))
+	for _, snapshot := range s.snapshots {
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots
+}
+
+func (s *GoveeService) Snapshot(id string) (Snapshot, error) {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+
+	snapshot, ok := s.snapshots[id]
+	if !ok {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	return snapshot, nil
+}
+
+func (s *GoveeService) DeleteSnapshot(id string) error {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+
+	if _, ok := s.snapshots[id]; !ok {
+		return ErrSnapshotNotFound
+	}
+
+	delete(s.snapshots, id)
+	return nil
+}
+
+// Replays a snapshot. Devices are restored concurrently; each one is switched on first and then
+// given its brightness and color, so it never comes back with whatever the device remembered.
+func (s *GoveeService) RestoreSnapshot(ctx context.Context, id string, transport string) ([]RestoreResult, error) {
+	snapshot, err := s.Snapshot(id)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RestoreResult, len(snapshot.Devices))
+
+	var wg sync.WaitGroup
+	for i, entry := range snapshot.Devices {
+		results[i] = RestoreResult{SKU: entry.SKU, Device: entry.Device}
+
+		if entry.State == nil {
+			results[i].ErrorCode = entry.ErrorCode
+			results[i].Error = "state was not captured: " + entry.Error
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			used, err := s.ApplyState(ctx, entry.SKU, entry.Device, *entry.State, transport)
+			results[i].Transport = used
+			if err != nil {
+				logging.FromContext(ctx).Warn("Failed to restore device state", "device", entry.Device, "error", err)
+				results[i].ErrorCode, results[i].Error = DescribeError(err)
+				return
+			}
+			results[i].Success = true
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// Sends the commands that bring a device into the given state and returns the transport of the
+// last command. A device that is off is only switched off. A zero brightness or color is left
+// unchanged, since neither can be displayed by a light that is on.
+func (s *GoveeService) ApplyState(ctx context.Context, sku string, deviceID string, state DeviceState, transport string) (string, error) {
+	capabilities := []ControlCapability{{
+		Type:     "devices.capabilities.on_off",
+		Instance: "powerSwitch",
+		Value:    float64(0),
+	}}
+
+	if state.On {
+		capabilities[0].Value = float64(1)
+
+		if state.Brightness > 0 {
+			capabilities = append(capabilities, ControlCapability{
+				Type:     "devices.capabilities.range",
+				Instance: "brightness",
+				Value:    float64(state.Brightness),
+			})
+		}
+
+		if state.ColorTemperatureK > 0 {
+			capabilities = append(capabilities, ControlCapability{
+				Type:     CapabilityColorSetting,
+				Instance: InstanceColorTemp,
+				Value:    float64(state.ColorTemperatureK),
+			})
+		} else if state.Color != (RGBColor{}) {
+			capabilities = append(capabilities, ControlCapability{
+				Type:     CapabilityColorSetting,
+				Instance: InstanceColorRGB,
+				Value:    float64(state.Color.R<<16 | state.Color.G<<8 | state.Color.B),
+			})
+		}
+	}
+
+	used := ""
+	for _, capability := range capabilities {
+		result, err := s.ControlDevice(ctx, sku, deviceID, capability, transport)
+		if err != nil {
+			return used, fmt.Errorf("%s: %w", capability.Instance, err)
+		}
+		used = result.Transport
+	}
+
+	return used, nil
+}
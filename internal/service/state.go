@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/EternityX/go-vee/internal/service/lan"
+	"github.com/google/uuid"
+)
+
+const statusQueryTimeout = 2 * time.Second
+
+// Last observed power, brightness and color of a device
+type DeviceState struct {
+	On                bool      `json:"on"`
+	Brightness        int       `json:"brightness"`
+	Color             RGBColor  `json:"color"`
+	ColorTemperatureK int       `json:"colorTemperatureK"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// Data of device.state_changed events
+type StateChangeData struct {
+	Previous DeviceState `json:"previous"`
+	Current  DeviceState `json:"current"`
+}
+
+// Reports whether two states differ in anything other than when they were observed
+func (st DeviceState) Equal(other DeviceState) bool {
+	return st.On == other.On &&
+		st.Brightness == other.Brightness &&
+		st.Color == other.Color &&
+		st.ColorTemperatureK == other.ColorTemperatureK
+}
+
+func stateFromLAN(resp *lan.ControlResponse) DeviceState {
+	data := resp.Msg.Data
+
+	return DeviceState{
+		On:                data.OnOff == 1,
+		Brightness:        data.Brightness,
+		Color:             RGBColor{R: data.Color.R, G: data.Color.G, B: data.Color.B},
+		ColorTemperatureK: data.ColorTemInKelvin,
+		UpdatedAt:         time.Now(),
+	}
+}
+
+// Queries the state of a LAN device with devStatus and records it, publishing a
+// device.state_changed event when it differs from the last observation
+func (s *GoveeService) QueryLANState(ctx context.Context, deviceID string) (DeviceState, error) {
+	if !s.useLAN {
+		return DeviceState{}, fmt.Errorf("%w: LAN control is disabled", ErrLANControl)
+	}
+
+	device, err := s.findLANDevice(ctx, deviceID)
+	if err != nil {
+		return DeviceState{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, statusQueryTimeout)
+	defer cancel()
+
+	resp, err := lan.GetDeviceStatus(ctx, device.Msg.Data.IP)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return DeviceState{}, fmt.Errorf("%w: querying status of %s: %w", ErrLANTimeout, deviceID, err)
+		}
+		return DeviceState{}, fmt.Errorf("querying status of %s: %w", deviceID, err)
+	}
+
+	state := stateFromLAN(resp)
+	s.recordState(ctx, device.Msg.Data.SKU, deviceID, "lan", state)
+
+	return state, nil
+}
+
+type stateRequest struct {
+	RequestID string `json:"requestId"`
+	Payload   struct {
+		SKU    string `json:"sku"`
+		Device string `json:"device"`
+	} `json:"payload"`
+}
+
+// A capability with its current value, as reported by the cloud state API
+type stateCapability struct {
+	Type     string `json:"type"`
+	Instance string `json:"instance"`
+	State    struct {
+		Value interface{} `json:"value"`
+	} `json:"state"`
+}
+
+type stateResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Msg     string `json:"msg"`
+	Payload struct {
+		Capabilities []stateCapability `json:"capabilities"`
+	} `json:"payload"`
+}
+
+// Asks the Govee cloud state API for the current value of every capability of a device
+func (s *GoveeService) fetchCloudState(ctx context.Context, sku string, deviceID string) ([]stateCapability, error) {
+	account, err := s.accountForDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	request := stateRequest{RequestID: uuid.New().String()}
+	request.Payload.SKU = sku
+	request.Payload.Device = deviceID
+
+	body, err := s.cloudRequest(ctx, account.APIKey, http.MethodPost, "/router/api/v1/device/state", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp stateResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("%w: parsing response body: %w", ErrUpstreamUnavailable, err)
+	}
+
+	if resp.Code != 200 {
+		message := resp.Message
+		if message == "" {
+			message = resp.Msg
+		}
+		return nil, &APIError{Status: resp.Code, Message: truncateMessage(message)}
+	}
+
+	return resp.Payload.Capabilities, nil
+}
+
+// Queries the state of a device through the Govee cloud state API and records it
+func (s *GoveeService) QueryCloudState(ctx context.Context, sku string, deviceID string) (DeviceState, error) {
+	capabilities, err := s.fetchCloudState(ctx, sku, deviceID)
+	if err != nil {
+		return DeviceState{}, err
+	}
+
+	state := DeviceState{UpdatedAt: time.Now()}
+	for _, capability := range capabilities {
+		value, _ := capability.State.Value.(float64)
+
+		switch capability.Instance {
+		case "powerSwitch":
+			state.On = value == 1
+		case "brightness":
+			state.Brightness = int(value)
+		case InstanceColorRGB:
+			packed := int(value)
+			state.Color = RGBColor{R: (packed >> 16) & 0xFF, G: (packed >> 8) & 0xFF, B: packed & 0xFF}
+		case InstanceColorTemp:
+			state.ColorTemperatureK = int(value)
+		}
+	}
+
+	s.recordState(ctx, sku, deviceID, "cloud", state)
+
+	return state, nil
+}
+
+// Queries the state of a device over the LAN when it can be reached there, otherwise through
+// the cloud state API. Returns the transport that answered.
+func (s *GoveeService) QueryState(ctx context.Context, sku string, deviceID string) (DeviceState, string, error) {
+	if s.useLAN {
+		state, err := s.QueryLANState(ctx, deviceID)
+		if err == nil {
+			return state, TransportLAN, nil
+		}
+
+		logging.FromContext(ctx).Debug("LAN state query failed, using cloud API", "device", deviceID, "error", err)
+	}
+
+	state, err := s.QueryCloudState(ctx, sku, deviceID)
+	if err != nil {
+		return DeviceState{}, "", err
+	}
+
+	return state, TransportCloud, nil
+}
+
+func (s *GoveeService) recordState(ctx context.Context, sku string, deviceID string, source string, state DeviceState) {
+	s.mu.Lock()
+	previous, known := s.states[deviceID]
+	s.states[deviceID] = state
+	s.mu.Unlock()
+
+	if known && !previous.Equal(state) {
+		event := events.Event{
+			ID:     uuid.New().String(),
+			Time:   time.Now(),
+			Type:   events.TypeDeviceStateChanged,
+			Source: source,
+			SKU:    sku,
+			Device: deviceID,
+			Data:   StateChangeData{Previous: previous, Current: state},
+		}
+
+		// Recorded here rather than from the event bus, which drops events for slow subscribers
+		s.recordStateChange(ctx, event)
+		s.events.Publish(event)
+	}
+}
+
+// Returns the last observed state of a device
+func (s *GoveeService) CachedState(deviceID string) (DeviceState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.states[deviceID]
+	return state, ok
+}
+
+// Polls the state of every discovered LAN device at every interval until ctx is cancelled
+func (s *GoveeService) RunStatePolling(ctx context.Context, interval time.Duration) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, device := range s.CachedLANDevices() {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if _, err := s.QueryLANState(ctx, device.Msg.Data.Device); err != nil {
+				logger.Debug("Failed to poll LAN device state", "device", device.Msg.Data.Device, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/logging"
+)
+
+// Transitions send intermediate brightness and color commands over the LAN at this interval
+const (
+	transitionStepInterval = 200 * time.Millisecond
+	maxTransitionSteps     = 50
+)
+
+func lerp(from, to int, t float64) int {
+	return int(math.Round(float64(from) + (float64(to)-float64(from))*t))
+}
+
+// Sends the commands of an intermediate transition state over the LAN. Steps go through the
+// device queue but are neither published nor recorded, and never use the cloud API quota.
+func (s *GoveeService) transitionStep(ctx context.Context, sku string, deviceID string, capabilities []ControlCapability) error {
+	for _, capability := range capabilities {
+		if _, err := s.enqueueControl(ctx, sku, deviceID, capability, TransportLAN, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fades a device from its current state to the target over the given duration and then applies
+// the target with ApplyState. Only brightness and RGB color are interpolated; a color temperature
+// target is set at the end. A device that is off fades in from 1% brightness.
+// Without a target brightness the current one is kept, or 100% when the device was off.
+// The intermediate steps are only sent over the LAN. With the cloud transport, with LAN control
+// disabled, or when a step fails, the target is applied directly.
+func (s *GoveeService) Transition(ctx context.Context, sku string, deviceID string, target DeviceState, duration time.Duration, transport string) (string, error) {
+	logger := logging.FromContext(ctx)
+
+	if duration <= 0 || !target.On || transport == TransportCloud || !s.useLAN {
+		return s.ApplyState(ctx, sku, deviceID, target, transport)
+	}
+
+	current, _, err := s.QueryState(ctx, sku, deviceID)
+	if err != nil {
+		logger.Debug("Cannot read state for transition, applying target directly", "device", deviceID, "error", err)
+		return s.ApplyState(ctx, sku, deviceID, target, transport)
+	}
+
+	// Targets without brightness or color keep the current value
+	targetBrightness := target.Brightness
+	if targetBrightness == 0 {
+		targetBrightness = current.Brightness
+		if !current.On {
+			targetBrightness = 100
+		}
+	}
+
+	targetColor := target.Color
+	if target.ColorTemperatureK > 0 || targetColor == (RGBColor{}) {
+		targetColor = current.Color
+	}
+
+	if !current.On {
+		current.Brightness = 1
+		current.Color = targetColor
+	}
+
+	steps := int(duration / transitionStepInterval)
+	steps = max(1, min(steps, maxTransitionSteps))
+	interval := duration / time.Duration(steps)
+
+	if !current.On {
+		start := []ControlCapability{
+			{Type: "devices.capabilities.on_off", Instance: "powerSwitch", Value: float64(1)},
+			{Type: "devices.capabilities.range", Instance: "brightness", Value: float64(1)},
+		}
+		if current.Color != (RGBColor{}) {
+			start = append(start, ControlCapability{
+				Type:     CapabilityColorSetting,
+				Instance: InstanceColorRGB,
+				Value:    float64(current.Color.R<<16 | current.Color.G<<8 | current.Color.B),
+			})
+		}
+
+		if err := s.transitionStep(ctx, sku, deviceID, start); err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			logger.Debug("Transition step failed, applying target directly", "device", deviceID, "error", err)
+			return s.ApplyState(ctx, sku, deviceID, target, transport)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for step := 1; step < steps; step++ {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+
+		t := float64(step) / float64(steps)
+		brightness := lerp(current.Brightness, targetBrightness, t)
+		color := RGBColor{
+			R: lerp(current.Color.R, targetColor.R, t),
+			G: lerp(current.Color.G, targetColor.G, t),
+			B: lerp(current.Color.B, targetColor.B, t),
+		}
+
+		capabilities := []ControlCapability{{
+			Type:     "devices.capabilities.range",
+			Instance: "brightness",
+			Value:    float64(max(1, brightness)),
+		}}
+		if current.Color != targetColor {
+			capabilities = append(capabilities, ControlCapability{
+				Type:     CapabilityColorSetting,
+				Instance: InstanceColorRGB,
+				Value:    float64(color.R<<16 | color.G<<8 | color.B),
+			})
+		}
+
+		if err := s.transitionStep(ctx, sku, deviceID, capabilities); err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			logger.Debug("Transition step failed, applying target directly", "device", deviceID, "error", err)
+			break
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-ticker.C:
+	}
+
+	target.Brightness = targetBrightness
+	return s.ApplyState(ctx, sku, deviceID, target, transport)
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package simulator
+
+import "syscall"
+
+// Address reuse is not set up here, so run the simulator with NoMulticast when binding the
+// per-device scan sockets fails
+func reuseAddr(network, address string, conn syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,385 @@
+// Package simulator emulates Govee LAN devices so discovery and control can be exercised
+// without real lights. Each simulated device binds its own IP address, answers scan requests
+// on the multicast group and by unicast, and keeps its state in memory.
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/service/lan"
+)
+
+const (
+	multicastGroup = "239.255.255.250"
+	scanPort       = 4001
+	replyPort      = 4002
+	controlPort    = 4003
+)
+
+// A simulated device. Each device needs its own IP address; on Linux any address in
+// 127.0.0.0/8 can be bound without configuring the loopback interface.
+type Device struct {
+	IP     string
+	Device string
+	SKU    string
+}
+
+// Power, brightness and color of a simulated device
+type State struct {
+	On                bool `json:"on"`
+	Brightness        int  `json:"brightness"`
+	R                 int  `json:"r"`
+	G                 int  `json:"g"`
+	B                 int  `json:"b"`
+	ColorTemperatureK int  `json:"colorTemperatureK"`
+}
+
+// Fault injection, applied to every packet a device receives
+type Faults struct {
+	// Probability (0-1) that a packet is ignored
+	DropRate float64
+	// Probability (0-1) that a reply is replaced by malformed JSON
+	MalformedRate float64
+	// Delay before a packet is handled, plus a random extra of up to Jitter
+	Delay  time.Duration
+	Jitter time.Duration
+}
+
+type Options struct {
+	Devices []Device
+	Faults  Faults
+	// Interface that joins the multicast group; empty lets the system choose
+	Interface string
+	// Skips the multicast listener, so devices only answer unicast scans
+	NoMulticast bool
+	Logger      *slog.Logger
+}
+
+type device struct {
+	cfg Device
+
+	mu    sync.Mutex
+	state State
+}
+
+type Simulator struct {
+	opts    Options
+	logger  *slog.Logger
+	devices []*device
+
+	conns []*net.UDPConn
+	wg    sync.WaitGroup
+}
+
+// Checks the options and returns a simulator with every device switched on at full brightness
+func New(opts Options) (*Simulator, error) {
+	if len(opts.Devices) == 0 {
+		return nil, errors.New("at least one device is required")
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	sim := &Simulator{opts: opts, logger: opts.Logger}
+
+	ids := make(map[string]bool)
+	ips := make(map[string]bool)
+	for _, d := range opts.Devices {
+		if net.ParseIP(d.IP).To4() == nil {
+			return nil, fmt.Errorf("device %s: invalid IPv4 address %q", d.Device, d.IP)
+		}
+
+		if d.Device == "" || d.SKU == "" {
+			return nil, fmt.Errorf("device at %s needs a device ID and a SKU", d.IP)
+		}
+
+		if ids[d.Device] || ips[d.IP] {
+			return nil, fmt.Errorf("device %s at %s is not unique", d.Device, d.IP)
+		}
+		ids[d.Device] = true
+		ips[d.IP] = true
+
+		sim.devices = append(sim.devices, &device{
+			cfg:   d,
+			state: State{On: true, Brightness: 100, R: 255, G: 255, B: 255},
+		})
+	}
+
+	return sim, nil
+}
+
+// Binds the sockets of every device and serves them until ctx is cancelled. The simulator is
+// ready when Start returns without an error.
+func (s *Simulator) Start(ctx context.Context) error {
+	for _, d := range s.devices {
+		ip := net.ParseIP(d.cfg.IP)
+
+		control, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip, Port: controlPort})
+		if err != nil {
+			s.close()
+			return fmt.Errorf("device %s: %w", d.cfg.Device, err)
+		}
+		s.conns = append(s.conns, control)
+
+		lc := net.ListenConfig{Control: reuseAddr}
+		packetConn, err := lc.ListenPacket(ctx, "udp4", net.JoinHostPort(d.cfg.IP, strconv.Itoa(scanPort)))
+		if err != nil {
+			s.close()
+			return fmt.Errorf("device %s: %w", d.cfg.Device, err)
+		}
+		scan := packetConn.(*net.UDPConn)
+		s.conns = append(s.conns, scan)
+
+		s.serve(control, func(packet []byte, from *net.UDPAddr) {
+			s.handleControl(control, d, packet, from)
+		})
+		s.serve(scan, func(packet []byte, from *net.UDPAddr) {
+			s.handleScan(d, packet, from)
+		})
+	}
+
+	if !s.opts.NoMulticast {
+		var iface *net.Interface
+		if s.opts.Interface != "" {
+			var err error
+			if iface, err = net.InterfaceByName(s.opts.Interface); err != nil {
+				s.close()
+				return fmt.Errorf("multicast interface: %w", err)
+			}
+		}
+
+		group := &net.UDPAddr{IP: net.ParseIP(multicastGroup), Port: scanPort}
+		multicast, err := net.ListenMulticastUDP("udp4", iface, group)
+		if err != nil {
+			s.close()
+			return fmt.Errorf("joining multicast group: %w", err)
+		}
+		s.conns = append(s.conns, multicast)
+
+		// Every device answers a multicast scan, like separate bulbs on one network would
+		s.serve(multicast, func(packet []byte, from *net.UDPAddr) {
+			for _, d := range s.devices {
+				s.handleScan(d, packet, from)
+			}
+		})
+	}
+
+	context.AfterFunc(ctx, s.close)
+
+	s.logger.Info("LAN simulator started", "devices", len(s.devices), "multicast", !s.opts.NoMulticast)
+	return nil
+}
+
+// Waits until every socket has been closed after ctx was cancelled
+func (s *Simulator) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Simulator) close() {
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Reads packets from conn until it is closed and hands each one to handle in its own goroutine,
+// so delays of one packet do not hold up the next
+func (s *Simulator) serve(conn *net.UDPConn, handle func(packet []byte, from *net.UDPAddr)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		buffer := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					s.logger.Warn("Simulator read failed", "addr", conn.LocalAddr(), "error", err)
+				}
+				return
+			}
+
+			packet := append([]byte(nil), buffer[:n]...)
+			go func() {
+				if s.inject() {
+					handle(packet, from)
+				}
+			}()
+		}
+	}()
+}
+
+// Applies the delay and drop faults; reports whether the packet should be handled
+func (s *Simulator) inject() bool {
+	faults := s.opts.Faults
+
+	delay := faults.Delay
+	if faults.Jitter > 0 {
+		delay += rand.N(faults.Jitter)
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return faults.DropRate <= 0 || rand.Float64() >= faults.DropRate
+}
+
+func (s *Simulator) malformed() bool {
+	return s.opts.Faults.MalformedRate > 0 && rand.Float64() < s.opts.Faults.MalformedRate
+}
+
+func (s *Simulator) handleScan(d *device, packet []byte, from *net.UDPAddr) {
+	var req lan.ScanRequest
+	if err := json.Unmarshal(packet, &req); err != nil || req.Msg.Cmd != "scan" {
+		s.logger.Debug("Ignoring packet on scan port", "device", d.cfg.Device, "from", from)
+		return
+	}
+
+	var resp lan.ScanResponse
+	resp.Msg.Cmd = "scan"
+	resp.Msg.Data.IP = d.cfg.IP
+	resp.Msg.Data.Device = d.cfg.Device
+	resp.Msg.Data.SKU = d.cfg.SKU
+	resp.Msg.Data.BleVersionHard = "3.01.01"
+	resp.Msg.Data.BleVersionSoft = "1.03.01"
+	resp.Msg.Data.WifiVersionHard = "1.00.10"
+	resp.Msg.Data.WifiVersionSoft = "1.02.03"
+
+	// Scan replies go to the fixed reply port of the sender, sent from the device's address
+	conn, err := net.DialUDP("udp4", &net.UDPAddr{IP: net.ParseIP(d.cfg.IP)}, &net.UDPAddr{IP: from.IP, Port: replyPort})
+	if err != nil {
+		s.logger.Warn("Simulator cannot reply to scan", "device", d.cfg.Device, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	s.write(conn, nil, resp)
+	s.logger.Debug("Answered scan", "device", d.cfg.Device, "from", from)
+}
+
+func (s *Simulator) handleControl(conn *net.UDPConn, d *device, packet []byte, from *net.UDPAddr) {
+	var req struct {
+		Msg struct {
+			Cmd  string          `json:"cmd"`
+			Data json.RawMessage `json:"data"`
+		} `json:"msg"`
+	}
+	if err := json.Unmarshal(packet, &req); err != nil {
+		s.logger.Warn("Simulator received malformed command", "device", d.cfg.Device, "error", err)
+		return
+	}
+
+	var data struct {
+		Value int `json:"value"`
+		Color struct {
+			R int `json:"r"`
+			G int `json:"g"`
+			B int `json:"b"`
+		} `json:"color"`
+		ColorTemInKelvin int `json:"colorTemInKelvin"`
+	}
+	if len(req.Msg.Data) > 0 {
+		if err := json.Unmarshal(req.Msg.Data, &data); err != nil {
+			s.logger.Warn("Simulator received malformed command data", "device", d.cfg.Device, "cmd", req.Msg.Cmd, "error", err)
+			return
+		}
+	}
+
+	d.mu.Lock()
+	switch req.Msg.Cmd {
+	case "turn":
+		d.state.On = data.Value == 1
+	case "brightness":
+		d.state.Brightness = max(1, min(100, data.Value))
+	case "colorwc":
+		d.state.ColorTemperatureK = data.ColorTemInKelvin
+		if data.ColorTemInKelvin == 0 {
+			d.state.R, d.state.G, d.state.B = data.Color.R, data.Color.G, data.Color.B
+		}
+	case "devStatus":
+	default:
+		d.mu.Unlock()
+		s.logger.Warn("Simulator received unknown command", "device", d.cfg.Device, "cmd", req.Msg.Cmd)
+		return
+	}
+	state := d.state
+	d.mu.Unlock()
+
+	s.logger.Info("Simulated device handled command", "device", d.cfg.Device, "cmd", req.Msg.Cmd, "state", state)
+
+	if req.Msg.Cmd != "devStatus" {
+		return
+	}
+
+	var resp lan.ControlResponse
+	resp.Msg.Cmd = "devStatus"
+	if state.On {
+		resp.Msg.Data.OnOff = 1
+	}
+	resp.Msg.Data.Brightness = state.Brightness
+	resp.Msg.Data.Color.R = state.R
+	resp.Msg.Data.Color.G = state.G
+	resp.Msg.Data.Color.B = state.B
+	resp.Msg.Data.ColorTemInKelvin = state.ColorTemperatureK
+
+	s.write(conn, from, resp)
+}
+
+// Sends a reply, replacing it with malformed JSON when that fault triggers. Without an address
+// the reply is written to a connected socket.
+func (s *Simulator) write(conn *net.UDPConn, to *net.UDPAddr, reply interface{}) {
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		s.logger.Error("Simulator cannot encode reply", "error", err)
+		return
+	}
+
+	if s.malformed() {
+		payload = payload[:len(payload)/2]
+	}
+
+	if to == nil {
+		_, err = conn.Write(payload)
+	} else {
+		_, err = conn.WriteToUDP(payload, to)
+	}
+	if err != nil {
+		s.logger.Warn("Simulator reply failed", "error", err)
+	}
+}
+
+// Returns the current state of a simulated device
+func (s *Simulator) State(deviceID string) (State, bool) {
+	for _, d := range s.devices {
+		if d.cfg.Device == deviceID {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.state, true
+		}
+	}
+
+	return State{}, false
+}
+
+// Replaces the state of a simulated device, as if it had been changed with the Govee app
+func (s *Simulator) SetState(deviceID string, state State) bool {
+	for _, d := range s.devices {
+		if d.cfg.Device == deviceID {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.state = state
+			return true
+		}
+	}
+
+	return false
+}
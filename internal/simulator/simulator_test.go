@@ -0,0 +1,224 @@
+package simulator_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/service"
+	"github.com/EternityX/go-vee/internal/service/lan"
+	"github.com/EternityX/go-vee/internal/simulator"
+)
+
+const testDevice = "5A:1E:00:00:00:00:00:01"
+
+// Starts a simulated H6022 at ip that is stopped when the test ends. Every test uses its own
+// address, since the Govee ports are fixed.
+func startSimulator(t *testing.T, ip string, faults simulator.Faults) *simulator.Simulator {
+	t.Helper()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("binding addresses in 127.0.0.0/8 other than 127.0.0.1 needs Linux")
+	}
+
+	sim, err := simulator.New(simulator.Options{
+		Devices:     []simulator.Device{{IP: ip, Device: testDevice, SKU: "H6022"}},
+		Faults:      faults,
+		NoMulticast: true,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := sim.Start(ctx); err != nil {
+		cancel()
+		t.Fatalf("Start: %v", err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		sim.Wait()
+	})
+
+	return sim
+}
+
+// Polls the simulator until the device reaches the wanted state. Each packet is handled in its
+// own goroutine, so a command is applied shortly after it was sent.
+func waitForState(t *testing.T, sim *simulator.Simulator, want func(simulator.State) bool) simulator.State {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		state, _ := sim.State(testDevice)
+		if want(state) {
+			return state
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("simulated device did not reach the expected state, last state %+v", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	startSimulator(t, "127.0.0.51", simulator.Faults{})
+
+	devices, err := lan.Discover(context.Background(), time.Second, lan.DiscoverOptions{
+		Targets:     []string{"127.0.0.51"},
+		NoMulticast: true,
+	})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("discovered %d devices, want 1", len(devices))
+	}
+
+	data := devices[0].Msg.Data
+	if data.IP != "127.0.0.51" || data.Device != testDevice || data.SKU != "H6022" {
+		t.Errorf("discovered %+v", data)
+	}
+}
+
+func TestControlDevice(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.52", simulator.Faults{})
+
+	svc := service.NewGoveeService(nil, true)
+	svc.ConfigureLAN(lan.DiscoverOptions{Targets: []string{"127.0.0.52"}, NoMulticast: true}, nil)
+
+	ctx := context.Background()
+	control := func(capType, instance string, value interface{}) {
+		t.Helper()
+
+		result, err := svc.ControlDevice(ctx, "H6022", testDevice, service.ControlCapability{
+			Type:     capType,
+			Instance: instance,
+			Value:    value,
+		}, service.TransportLAN)
+		if err != nil {
+			t.Fatalf("%s: %v", instance, err)
+		}
+		if result.Transport != service.TransportLAN {
+			t.Errorf("%s: controlled over %s, want lan", instance, result.Transport)
+		}
+	}
+
+	control("devices.capabilities.on_off", "powerSwitch", float64(0))
+	waitForState(t, sim, func(s simulator.State) bool { return !s.On })
+
+	control("devices.capabilities.on_off", "powerSwitch", float64(1))
+	control("devices.capabilities.range", "brightness", float64(40))
+	control(service.CapabilityColorSetting, service.InstanceColorRGB, "#ff8000")
+	waitForState(t, sim, func(s simulator.State) bool {
+		return s.On && s.Brightness == 40 && s.R == 255 && s.G == 128 && s.B == 0
+	})
+
+	state, err := svc.QueryLANState(ctx, testDevice)
+	if err != nil {
+		t.Fatalf("QueryLANState: %v", err)
+	}
+
+	want := service.RGBColor{R: 255, G: 128, B: 0}
+	if !state.On || state.Brightness != 40 || state.Color != want {
+		t.Errorf("QueryLANState returned %+v", state)
+	}
+}
+
+func TestQueryLANStateFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		faults  simulator.Faults
+		timeout bool
+	}{
+		{"dropped", "127.0.0.53", simulator.Faults{DropRate: 1}, true},
+		{"malformed", "127.0.0.54", simulator.Faults{MalformedRate: 1}, false},
+	}
+
+	for _, tt := range tests {
+		startSimulator(t, tt.ip, tt.faults)
+
+		svc := service.NewGoveeService(nil, true)
+		svc.ConfigureLAN(lan.DiscoverOptions{NoMulticast: true}, []service.StaticDevice{
+			{IP: tt.ip, Device: testDevice, SKU: "H6022"},
+		})
+
+		_, err := svc.QueryLANState(context.Background(), testDevice)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+
+		if timeout := errors.Is(err, service.ErrLANTimeout); timeout != tt.timeout {
+			t.Errorf("%s: got %v, want a timeout error: %v", tt.name, err, tt.timeout)
+		}
+	}
+}
+
+func TestControlUnknownDevice(t *testing.T) {
+	startSimulator(t, "127.0.0.55", simulator.Faults{})
+
+	svc := service.NewGoveeService(nil, true)
+	svc.ConfigureLAN(lan.DiscoverOptions{Targets: []string{"127.0.0.55"}, NoMulticast: true}, nil)
+
+	_, err := svc.ControlDevice(context.Background(), "H6022", "AA:BB:CC:DD:EE:FF:00:11", service.ControlCapability{
+		Type:     "devices.capabilities.on_off",
+		Instance: "powerSwitch",
+		Value:    float64(1),
+	}, service.TransportLAN)
+	if !errors.Is(err, service.ErrLANControl) || !errors.Is(err, service.ErrDeviceNotFound) {
+		t.Errorf("got %v, want a LAN control error for a device that was not found", err)
+	}
+}
+
+func TestTransitionPublishesOnlyTheFinalState(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.56", simulator.Faults{})
+
+	svc := service.NewGoveeService(nil, true)
+	svc.ConfigureLAN(lan.DiscoverOptions{Targets: []string{"127.0.0.56"}, NoMulticast: true}, nil)
+	svc.SetLANCommandGap(0)
+
+	sub := svc.Events().Subscribe(256)
+	defer sub.Close()
+
+	target := service.DeviceState{On: true, Brightness: 80, Color: service.RGBColor{R: 0, G: 0, B: 255}}
+	transport, err := svc.Transition(context.Background(), "H6022", testDevice, target, time.Second, service.TransportAuto)
+	if err != nil {
+		t.Fatalf("Transition: %v", err)
+	}
+	if transport != service.TransportLAN {
+		t.Errorf("transition ended over %s, want lan", transport)
+	}
+
+	waitForState(t, sim, func(s simulator.State) bool {
+		return s.On && s.Brightness == 80 && s.R == 0 && s.G == 0 && s.B == 255
+	})
+
+	// Power, brightness and color of the final state; none of the steps
+	published := 0
+drain:
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Type == events.TypeControlSucceeded || event.Type == events.TypeControlFailed {
+				published++
+			}
+		default:
+			break drain
+		}
+	}
+
+	if published != 3 {
+		t.Errorf("published %d control events, want 3", published)
+	}
+}
@@ -0,0 +1,453 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+	"github.com/EternityX/go-vee/internal/logging"
+	"github.com/google/uuid"
+)
+
+const (
+	SignatureHeader = "X-Go-Vee-Signature"
+	TimestampHeader = "X-Go-Vee-Timestamp"
+	EventHeader     = "X-Go-Vee-Event"
+	DeliveryHeader  = "X-Go-Vee-Delivery"
+
+	SourceConfig = "config"
+	SourceAPI    = "api"
+
+	maxDeliveryLog = 500
+
+	// Events waiting for delivery to one target. Further events are dropped while it is full,
+	// so a slow target cannot hold up the others or pile up goroutines.
+	targetQueueSize = 100
+)
+
+var (
+	ErrNotFound = errors.New("webhook not found")
+	ErrReadOnly = errors.New("webhook is defined in the config file")
+
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleTimestamp   = errors.New("webhook timestamp outside the allowed window")
+)
+
+// A destination that receives events matching its filters. Empty filters match everything.
+type Target struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	Secret       string   `json:"secret,omitempty"`
+	Devices      []string `json:"devices,omitempty"`
+	EventTypes   []string `json:"eventTypes,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Source       string   `json:"source"`
+}
+
+// One attempt to deliver an event to a target. An event dropped because the target's queue was
+// full is logged with attempt 0.
+type Delivery struct {
+	ID         string    `json:"id"`
+	TargetID   string    `json:"targetId"`
+	EventID    string    `json:"eventId"`
+	EventType  string    `json:"eventType"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+type Manager struct {
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+
+	mu         sync.RWMutex
+	targets    map[string]*Target
+	deliveries []Delivery
+}
+
+func NewManager(maxAttempts int, baseDelay time.Duration) *Manager {
+	return &Manager{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		targets:     make(map[string]*Target),
+	}
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Registers a target, assigning an ID and generating a signing secret when none is given
+func (m *Manager) Add(target Target) (Target, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Target{}, fmt.Errorf("invalid webhook URL %q", target.URL)
+	}
+
+	if target.ID == "" {
+		target.ID = uuid.New().String()
+	}
+
+	if target.Source == "" {
+		target.Source = SourceAPI
+	}
+
+	if target.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return Target{}, fmt.Errorf("generating webhook secret: %w", err)
+		}
+		target.Secret = secret
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.targets[target.ID]; exists {
+		return Target{}, fmt.Errorf("webhook %s already exists", target.ID)
+	}
+
+	stored := target
+	m.targets[target.ID] = &stored
+
+	return target, nil
+}
+
+// Removes a target created through the API
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target, ok := m.targets[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if target.Source == SourceConfig {
+		return ErrReadOnly
+	}
+
+	delete(m.targets, id)
+	return nil
+}
+
+// Returns a target without its secret
+func (m *Manager) Get(id string) (Target, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	target, ok := m.targets[id]
+	if !ok {
+		return Target{}, ErrNotFound
+	}
+
+	result := *target
+	result.Secret = ""
+	return result, nil
+}
+
+// Returns every target without its secret
+func (m *Manager) List() []Target {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	targets := make([]Target, 0, len(m.targets))
+	for _, target := range m.targets {
+		result := *target
+		result.Secret = ""
+		targets = append(targets, result)
+	}
+
+	return targets
+}
+
+// Returns the logged delivery attempts for a target, newest first
+func (m *Manager) Deliveries(targetID string) []Delivery {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deliveries := []Delivery{}
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].TargetID == targetID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+
+	return deliveries
+}
+
+func (m *Manager) logDelivery(delivery Delivery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries = append(m.deliveries, delivery)
+	if len(m.deliveries) > maxDeliveryLog {
+		m.deliveries = m.deliveries[len(m.deliveries)-maxDeliveryLog:]
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether an event passes the target's device, event type and capability filters.
+// The capability filter accepts either a capability type or an instance name.
+func (t *Target) Matches(event events.Event) bool {
+	if len(t.Devices) > 0 && !contains(t.Devices, event.Device) {
+		return false
+	}
+
+	if len(t.EventTypes) > 0 && !contains(t.EventTypes, event.Type) {
+		return false
+	}
+
+	if len(t.Capabilities) > 0 && !contains(t.Capabilities, event.Capability) && !contains(t.Capabilities, event.Instance) {
+		return false
+	}
+
+	return true
+}
+
+// Returns the hex encoded HMAC-SHA256 of timestamp + "." + body, as sent in the signature
+// header. The timestamp is the value of the timestamp header, in Unix seconds.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the signature and timestamp headers of a delivery. Deliveries signed more than maxAge
+// before now are rejected, so a captured delivery cannot be replayed later.
+func Verify(secret string, timestamp string, body []byte, signature string, maxAge time.Duration, now time.Time) error {
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrStaleTimestamp, timestamp)
+	}
+
+	if age := now.Sub(time.Unix(seconds, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: signed %s ago", ErrStaleTimestamp, age.Round(time.Second))
+	}
+
+	return nil
+}
+
+// An event waiting in a target's queue, with the target as it was when the event matched
+type queuedEvent struct {
+	target Target
+	event  events.Event
+}
+
+// Delivers events from the bus to matching targets until ctx is cancelled. Every target has its
+// own queue and worker, so deliveries to one target are sent in order and a slow target only
+// delays itself.
+func (m *Manager) Run(ctx context.Context, bus *events.Bus) {
+	sub := bus.Subscribe(256)
+	defer sub.Close()
+
+	m.run(ctx, sub.Events())
+}
+
+func (m *Manager) run(ctx context.Context, incoming <-chan events.Event) {
+	queues := make(map[string]chan queuedEvent) // target ID -> queue
+	var wg sync.WaitGroup
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-incoming:
+			if !ok {
+				return
+			}
+
+			m.mu.RLock()
+			var matched []Target
+			for _, target := range m.targets {
+				if target.Matches(event) {
+					matched = append(matched, *target)
+				}
+			}
+
+			// Stop the workers of removed targets
+			for id, queue := range queues {
+				if _, ok := m.targets[id]; !ok {
+					close(queue)
+					delete(queues, id)
+				}
+			}
+			m.mu.RUnlock()
+
+			for _, target := range matched {
+				queue, ok := queues[target.ID]
+				if !ok {
+					queue = make(chan queuedEvent, targetQueueSize)
+					queues[target.ID] = queue
+
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						m.work(ctx, queue)
+					}()
+				}
+
+				select {
+				case queue <- queuedEvent{target: target, event: event}:
+				default:
+					m.drop(ctx, target, event)
+				}
+			}
+		}
+	}
+}
+
+// Delivers the events of one target's queue until it is closed. Events still queued when ctx
+// is cancelled are discarded.
+func (m *Manager) work(ctx context.Context, queue <-chan queuedEvent) {
+	for queued := range queue {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		m.deliver(ctx, queued.target, queued.event)
+	}
+}
+
+// Logs an event that did not fit in the target's queue
+func (m *Manager) drop(ctx context.Context, target Target, event events.Event) {
+	logging.FromContext(ctx).Warn("Webhook queue full, dropping event", "webhook", target.ID, "event", event.ID, "event_type", event.Type)
+
+	m.logDelivery(Delivery{
+		ID:        uuid.New().String(),
+		TargetID:  target.ID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Error:     "delivery queue full, event dropped",
+		Time:      time.Now(),
+	})
+}
+
+// Posts an event to a target, retrying failed attempts with exponential backoff
+func (m *Manager) deliver(ctx context.Context, target Target, event events.Event) {
+	logger := logging.FromContext(ctx).With("webhook", target.ID, "event", event.ID, "event_type", event.Type)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to encode webhook payload", "error", err)
+		return
+	}
+
+	deliveryID := uuid.New().String()
+	delay := m.baseDelay
+
+	for attempt := 1; attempt <= m.maxAttempts; attempt++ {
+		start := time.Now()
+		status, retryable, err := m.post(ctx, target, deliveryID, event.Type, body)
+
+		delivery := Delivery{
+			ID:         deliveryID,
+			TargetID:   target.ID,
+			EventID:    event.ID,
+			EventType:  event.Type,
+			Attempt:    attempt,
+			StatusCode: status,
+			Success:    err == nil,
+			Time:       start,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		m.logDelivery(delivery)
+
+		if err == nil {
+			logger.Debug("Delivered webhook", "status", status, "attempt", attempt)
+			return
+		}
+
+		if !retryable || attempt == m.maxAttempts {
+			logger.Warn("Webhook delivery failed", "error", err, "attempt", attempt)
+			return
+		}
+
+		logger.Debug("Webhook delivery failed, retrying", "error", err, "attempt", attempt, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// Sends one delivery attempt and reports whether a failure is worth retrying
+func (m *Manager) post(ctx context.Context, target Target, deliveryID string, eventType string, body []byte) (int, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-vee-webhooks")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(target.Secret, timestamp, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, true, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return resp.StatusCode, retryable, fmt.Errorf("target returned status %d", resp.StatusCode)
+}
@@ -0,0 +1,320 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternityX/go-vee/internal/events"
+)
+
+// A webhook receiver that answers with the queued statuses, then 204, and records every request
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	t.Helper()
+
+	rec := &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		status := http.StatusNoContent
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		rec.mu.Unlock()
+
+		w.WriteHeader(status)
+		rec.received <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	return rec, server
+}
+
+// Waits for n more requests to reach the receiver
+func (rec *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-rec.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of %d requests", i, n)
+		}
+	}
+}
+
+// Waits until at least n deliveries of the target are logged and returns them
+func waitForDeliveries(t *testing.T, m *Manager, targetID string, n int) []Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		deliveries := m.Deliveries(targetID)
+		if len(deliveries) >= n {
+			return deliveries
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("logged %d deliveries, want %d", len(deliveries), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Starts delivering the events sent on the returned channel, stopped when the test ends
+func startManager(t *testing.T, m *Manager) chan<- events.Event {
+	t.Helper()
+
+	incoming := make(chan events.Event)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.run(ctx, incoming)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return incoming
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"control.succeeded"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign("secret", timestamp, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		signature string
+		now       time.Time
+		err       error
+	}{
+		{"valid", "secret", timestamp, body, signature, now, nil},
+		{"valid within the window", "secret", timestamp, body, signature, now.Add(4 * time.Minute), nil},
+		{"wrong secret", "other", timestamp, body, signature, now, ErrInvalidSignature},
+		{"changed body", "secret", timestamp, []byte(`{"type":"control.failed"}`), signature, now, ErrInvalidSignature},
+		{"changed timestamp", "secret", "1700000001", body, signature, now, ErrInvalidSignature},
+		{"replayed later", "secret", timestamp, body, signature, now.Add(10 * time.Minute), ErrStaleTimestamp},
+		{"from the future", "secret", timestamp, body, signature, now.Add(-10 * time.Minute), ErrStaleTimestamp},
+		{"malformed timestamp", "secret", "soon", body, Sign("secret", "soon", body), now, ErrStaleTimestamp},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.secret, tt.timestamp, tt.body, tt.signature, 5*time.Minute, tt.now)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	rec, server := newReceiver(t)
+
+	m := NewManager(1, time.Millisecond)
+	if _, err := m.Add(Target{ID: "hook", URL: server.URL, Secret: "secret"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	incoming := startManager(t, m)
+	incoming <- events.Event{Type: events.TypeControlSucceeded, Device: "AA"}
+	rec.wait(t, 1)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	req := rec.requests[0]
+	if req.Header.Get(EventHeader) != events.TypeControlSucceeded {
+		t.Errorf("event header %q", req.Header.Get(EventHeader))
+	}
+
+	err := Verify("secret", req.Header.Get(TimestampHeader), rec.bodies[0], req.Header.Get(SignatureHeader), time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	event := events.Event{Type: events.TypeControlSucceeded, Device: "AA", Capability: "devices.capabilities.on_off", Instance: "powerSwitch"}
+
+	tests := []struct {
+		name   string
+		target Target
+		want   bool
+	}{
+		{"no filters", Target{}, true},
+		{"event type", Target{EventTypes: []string{events.TypeControlSucceeded}}, true},
+		{"other event type", Target{EventTypes: []string{events.TypeControlFailed}}, false},
+		{"device", Target{Devices: []string{"BB", "AA"}}, true},
+		{"other device", Target{Devices: []string{"BB"}}, false},
+		{"capability type", Target{Capabilities: []string{"devices.capabilities.on_off"}}, true},
+		{"instance", Target{Capabilities: []string{"powerSwitch"}}, true},
+		{"other capability", Target{Capabilities: []string{"brightness"}}, false},
+		{"every filter", Target{Devices: []string{"AA"}, EventTypes: []string{events.TypeControlSucceeded}, Capabilities: []string{"powerSwitch"}}, true},
+		{"one filter fails", Target{Devices: []string{"AA"}, EventTypes: []string{events.TypeControlFailed}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.target.Matches(event); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEventTypeFilter(t *testing.T) {
+	rec, server := newReceiver(t)
+
+	m := NewManager(1, time.Millisecond)
+	m.Add(Target{ID: "hook", URL: server.URL, EventTypes: []string{events.TypeControlFailed}})
+
+	incoming := startManager(t, m)
+	incoming <- events.Event{ID: "1", Type: events.TypeControlSucceeded}
+	incoming <- events.Event{ID: "2", Type: events.TypeControlFailed}
+	rec.wait(t, 1)
+
+	// Deliveries to a target are sent in order, so nothing else is on the way
+	select {
+	case <-rec.received:
+		t.Error("received an event that does not match the filter")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := rec.requests[0].Header.Get(EventHeader); got != events.TypeControlFailed {
+		t.Errorf("received %s, want %s", got, events.TypeControlFailed)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	rec, server := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	m := NewManager(5, 20*time.Millisecond)
+	m.Add(Target{ID: "hook", URL: server.URL})
+
+	incoming := startManager(t, m)
+	start := time.Now()
+	incoming <- events.Event{ID: "1", Type: events.TypeControlSucceeded}
+	rec.wait(t, 3)
+
+	// Two retries after 20 and 40 ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("three attempts took %v, want at least 60ms of backoff", elapsed)
+	}
+
+	deliveries := waitForDeliveries(t, m, "hook", 3)
+	if len(deliveries) != 3 {
+		t.Fatalf("logged %d deliveries, want 3", len(deliveries))
+	}
+
+	// Newest first: the third attempt succeeded with the delivery ID of the first
+	want := []struct {
+		attempt int
+		status  int
+		success bool
+	}{
+		{3, http.StatusNoContent, true},
+		{2, http.StatusTooManyRequests, false},
+		{1, http.StatusServiceUnavailable, false},
+	}
+	for i, w := range want {
+		d := deliveries[i]
+		if d.Attempt != w.attempt || d.StatusCode != w.status || d.Success != w.success || d.ID != deliveries[0].ID {
+			t.Errorf("delivery %d: %+v, want attempt %d status %d success %v", i, d, w.attempt, w.status, w.success)
+		}
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	rec, server := newReceiver(t, http.StatusBadRequest)
+
+	m := NewManager(5, time.Millisecond)
+	m.Add(Target{ID: "hook", URL: server.URL})
+
+	incoming := startManager(t, m)
+	incoming <- events.Event{ID: "1", Type: events.TypeControlSucceeded}
+	rec.wait(t, 1)
+
+	select {
+	case <-rec.received:
+		t.Error("a 400 response was retried")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	m := NewManager(1, time.Millisecond)
+	m.Add(Target{ID: "hook", URL: server.URL})
+
+	incoming := startManager(t, m)
+
+	// One event is in flight and targetQueueSize wait in the queue; the rest are dropped
+	total := targetQueueSize + 20
+	for i := 0; i < total; i++ {
+		incoming <- events.Event{ID: strconv.Itoa(i), Type: events.TypeControlSucceeded}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		dropped := 0
+		for _, d := range m.Deliveries("hook") {
+			if d.Attempt == 0 && !d.Success {
+				dropped++
+			}
+		}
+
+		if dropped >= total-targetQueueSize-1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("logged %d dropped events, want %d", dropped, total-targetQueueSize-1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliveryLogCap(t *testing.T) {
+	m := NewManager(1, time.Millisecond)
+
+	for i := 0; i < maxDeliveryLog+50; i++ {
+		m.logDelivery(Delivery{ID: strconv.Itoa(i), TargetID: "hook"})
+	}
+
+	deliveries := m.Deliveries("hook")
+	if len(deliveries) != maxDeliveryLog {
+		t.Fatalf("kept %d deliveries, want %d", len(deliveries), maxDeliveryLog)
+	}
+
+	// The oldest are dropped and the newest come first
+	if deliveries[0].ID != strconv.Itoa(maxDeliveryLog+49) || deliveries[maxDeliveryLog-1].ID != "50" {
+		t.Errorf("kept deliveries %s to %s, want %d to 50", deliveries[0].ID, deliveries[maxDeliveryLog-1].ID, maxDeliveryLog+49)
+	}
+}